@@ -2,12 +2,9 @@ package cmd
 
 import (
 	"fmt"
-	"io"
-	"log"
-	"net/http"
 	"net/url"
-	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
@@ -25,127 +22,236 @@ var (
 	dryRun       bool
 	force        bool
 	logFile      string
+	reportFile   string
 	downloadCmd  = &cobra.Command{
 		Use:   "download",
 		Short: "Download individual posts or the entire public archive",
 		Long:  `You can provide the url of a single post or the main url of the Substack you want to download.`,
 		Run: func(cmd *cobra.Command, args []string) {
 			startTime := time.Now()
+			checkFormat(format)
 
-			extractor, err := lib.NewExtractor(fetcher, logFile)
+			manifest, err := lib.LoadManifest(outputFolder)
 			if err != nil {
-				log.Fatalf("Failed to create extractor: %v", err)
+				fatalf("failed to load manifest: %v", err)
 			}
 
-			if strings.Contains(downloadUrl, "/p/") {
-				if verbose {
-					fmt.Printf("Downloading post %s\n", downloadUrl)
+			extractor, err := lib.NewExtractor(fetcher, logFile, extractorOptions(manifest)...)
+			if err != nil {
+				fatalf("failed to create extractor: %v", err)
+			}
+
+			var report *downloadReport
+			if reportFile != "" && !dryRun {
+				report = newDownloadReport(downloadUrl, outputFolder, format)
+			}
+			saveReport := func() {
+				if err := report.save(reportFile); err != nil {
+					fatalf("failed to write report: %v", err)
 				}
+			}
+
+			if strings.Contains(downloadUrl, "/p/") {
+				logger.Debug("downloading post", "url", downloadUrl)
 				if dryRun {
-					fmt.Println("Dry run, exiting...")
+					logger.Info("dry run, exiting")
 					return
 				}
-				if (beforeDate != "" || afterDate != "") && verbose {
-					fmt.Println("Warning: --before and --after flags are ignored when downloading a single post")
+				if beforeDate != "" || afterDate != "" || filterFlagsChanged(cmd) {
+					logger.Warn("date and post filters are ignored when downloading a single post")
 				}
 
 				post, err := extractor.ExtractPost(ctx, downloadUrl, outputFolder, force)
 				if err != nil {
-					log.Fatalln(err)
+					report.addFailure(downloadUrl, err, time.Since(startTime))
+					saveReport()
+					fatal(err)
 				}
 				if post.Slug == "" {
-					fmt.Println("No post was downloaded. Skipping...")
+					logger.Debug("no post was downloaded, skipping")
+					report.addSkipped(downloadUrl)
+					saveReport()
 					return
 				}
+				logger.Debug("downloaded post", "url", downloadUrl, "duration", time.Since(startTime))
 
-				downloadTime := time.Since(startTime)
-				if verbose {
-					fmt.Printf("Downloaded post %s in %s\n", downloadUrl, downloadTime)
-				}
-
-				postFolder := filepath.Join(outputFolder, post.Slug)
-				path := filepath.Join(postFolder, fmt.Sprintf("%s.%s", post.Slug, format))
-				if verbose {
-					fmt.Printf("Writing post to file %s\n", path)
-				}
-
-				err = post.WriteToFile(path, format)
+				path, err := savePost(extractor, downloadUrl, post, outputFolder, format, manifest)
 				if err != nil {
-					log.Fatalln(err)
+					report.addFailure(downloadUrl, err, time.Since(startTime))
+					saveReport()
+					fatal(err)
 				}
+				report.addPost(downloadUrl, post, path, time.Since(startTime))
+				saveReport()
 
-				if verbose {
-					fmt.Println("Done in ", time.Since(startTime))
-				}
+				logger.Debug("done", "duration", time.Since(startTime))
 			} else {
-				var downloadedPostsCount int
-				dateFilterfunc := lib.MakeDateFilterFunc(beforeDate, afterDate)
-				urls, err := extractor.GetAllPostsURLs(ctx, downloadUrl, dateFilterfunc)
-				urlsCount := len(urls)
+				filter := postFilter(manifest.LastChecked(downloadUrl))
+				urls, err := extractor.GetPostsURLs(ctx, downloadUrl, filter)
 				if err != nil {
-					log.Fatalln(err)
+					fatal(err)
+				}
+				urls = withIncompletePosts(urls, manifest, outputFolder)
+				urlsCount := len(urls)
+				if dryRun {
+					fmt.Printf("Found %d posts\n", urlsCount)
+					logger.Info("dry run, exiting")
+					return
 				}
+				savePublication(extractor, manifest, downloadUrl, outputFolder)
 				if urlsCount == 0 {
-					if verbose {
-						fmt.Println("No posts found, exiting...")
+					// the check time is the start of the run, so that a later last-run doesn't miss the posts published meanwhile
+					manifest.MarkChecked(downloadUrl, startTime)
+					if err := manifest.Save(); err != nil {
+						fatal(err)
 					}
+					logger.Info("no posts found, exiting")
+					saveReport()
 					return
 				}
-				if verbose {
-					fmt.Printf("Found %d posts\n", urlsCount)
+				if err := manifest.Save(); err != nil {
+					fatal(err)
 				}
-				if dryRun {
-					fmt.Printf("Found %d posts\n", urlsCount)
-					fmt.Println("Dry run, exiting...")
-					return
+				logger.Debug("found posts", "count", urlsCount)
+				downloadedPostsCount, errs, writeErrs := downloadPosts(extractor, urls, outputFolder, format, force, manifest, report)
+				saveReport()
+				if downloadedPostsCount > 0 {
+					rewriteLinks(manifest)
 				}
-				bar := progressbar.NewOptions(len(urls),
-					progressbar.OptionSetWidth(25),
-					progressbar.OptionSetDescription("downloading"),
-					progressbar.OptionShowBytes(true))
-				for result := range extractor.ExtractAllPosts(ctx, urls, outputFolder, force) {
-					select {
-					case <-ctx.Done():
-						log.Fatalln("context cancelled")
-					default:
-					}
-					if result.Err != nil {
-						if verbose {
-							fmt.Printf("Error downloading post %s: %s\n", result.Post.CanonicalUrl, result.Err)
-							fmt.Println("Skipping...")
-						}
-						continue
-					}
-					if result.Post.Slug == "" {
-						continue
-					}
-					bar.Add(1)
-					downloadedPostsCount++
-					if verbose {
-						fmt.Printf("Downloading post %s\n", result.Post.CanonicalUrl)
-					}
-					post := result.Post
-
-					postFolder := filepath.Join(outputFolder, post.Slug)
-					path := filepath.Join(postFolder, fmt.Sprintf("%s.%s", post.Slug, format))
-					if verbose {
-						fmt.Printf("Writing post to file %s\n", path)
-					}
-
-					err = post.WriteToFile(path, format)
-					if err != nil {
-						log.Fatalln(err)
+				if ctx.Err() != nil {
+					fatalf("interrupted after downloading %d posts, out of %d", downloadedPostsCount, len(urls))
+				}
+				// last-run only moves forward once every post is downloaded, so that the failed ones are retried
+				if len(errs) == 0 && len(writeErrs) == 0 {
+					manifest.MarkChecked(downloadUrl, startTime)
+					if err := manifest.Save(); err != nil {
+						fatal(err)
 					}
 				}
-				if verbose {
-					fmt.Println("Downloaded", downloadedPostsCount, "posts, out of", len(urls))
-					fmt.Println("Done in ", time.Since(startTime))
+				logger.Info("download finished", "downloaded", downloadedPostsCount, "failed", len(errs)+len(writeErrs), "total", len(urls), "duration", time.Since(startTime))
+				if len(writeErrs) > 0 {
+					fatalf("failed to save %d posts", len(writeErrs))
 				}
 			}
 		},
 	}
 )
 
+// showProgress tells whether downloadPosts shows a progress bar, which long-running commands logging their progress turn off.
+var showProgress = true
+
+// downloadPosts extracts the posts at the given urls and writes them to outputFolder in the given format,
+// showing a progress bar along the way if showProgress is set. If manifest is not nil, every post written is recorded in it.
+// A post is only recorded as downloaded once its file has been written, and only if none of its media files failed.
+// If report is not nil, the outcome of every post is added to it, including the posts skipped as already downloaded.
+// Once ctx is cancelled, no more posts are written and the remaining results are drained.
+// It returns the number of posts written, the errors of the posts that failed to download, if any,
+// and the errors of the posts downloaded but that failed to be written or recorded.
+func downloadPosts(extractor *lib.Extractor, urls []string, outputFolder string, format string, force bool, manifest *lib.Manifest, report *downloadReport) (int, lib.ExtractErrors, lib.ExtractErrors) {
+	var downloadedPostsCount int
+	errs := make(lib.ExtractErrors)
+	writeErrs := make(lib.ExtractErrors)
+	bar := progressbar.NewOptions(len(urls),
+		progressbar.OptionSetWidth(25),
+		progressbar.OptionSetDescription("downloading"),
+		progressbar.OptionShowBytes(true),
+		progressbar.OptionSetVisibility(showProgress))
+	reported := make(map[string]bool, len(urls))
+	for result := range extractor.ExtractAllPosts(ctx, urls, outputFolder, force) {
+		if ctx.Err() != nil {
+			report.addFailure(result.Url, ctx.Err(), result.Elapsed)
+			reported[result.Url] = true
+			continue
+		}
+		if result.Err != nil {
+			errs[result.Url] = result.Err
+			logger.Warn("failed to download post, skipping", "url", result.Url, "error", result.Err)
+			report.addFailure(result.Url, result.Err, result.Elapsed)
+			reported[result.Url] = true
+			continue
+		}
+		if result.Post.Slug == "" {
+			continue
+		}
+		bar.Add(1)
+		post := result.Post
+
+		path, err := savePost(extractor, result.Url, post, outputFolder, format, manifest)
+		if err != nil {
+			writeErrs[result.Url] = err
+			logger.Error("failed to save post", "url", result.Url, "error", err)
+			report.addFailure(result.Url, err, result.Elapsed)
+			reported[result.Url] = true
+			continue
+		}
+		downloadedPostsCount++
+		report.addPost(result.Url, post, path, result.Elapsed)
+		reported[result.Url] = true
+	}
+	// ExtractAllPosts produces no result for the posts already downloaded
+	for _, u := range urls {
+		if !reported[u] {
+			report.addSkipped(u)
+		}
+	}
+	return downloadedPostsCount, errs, writeErrs
+}
+
+// withIncompletePosts returns urls followed by the posts recorded in manifest in folder whose media files
+// failed to download, so that they are retried even if they are no longer selected, e.g. by last-run.
+func withIncompletePosts(urls []string, manifest *lib.Manifest, folder string) []string {
+	for _, u := range manifest.IncompletePosts(folder) {
+		if !slices.Contains(urls, u) {
+			logger.Debug("retrying post with failed media", "url", u)
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// savePost writes post, downloaded from postUrl, to its folder in outputFolder in the given format,
+// then records it in manifest if not nil. It is only recorded as downloaded, and skipped by later runs,
+// if all its media files were downloaded too; otherwise the manifest records it as incomplete, so that it is
+// downloaded again by the next run. It returns the path of the file written.
+func savePost(extractor *lib.Extractor, postUrl string, post lib.Post, outputFolder string, format string, manifest *lib.Manifest) (string, error) {
+	postFolder := filepath.Join(outputFolder, post.Slug)
+	path := filepath.Join(postFolder, fmt.Sprintf("%s.%s", post.Slug, format))
+	logger.Debug("writing post to file", "url", postUrl, "path", path)
+
+	if err := post.WriteToFile(path, format, outputOptions()...); err != nil {
+		return path, err
+	}
+	if len(post.MediaErrors) == 0 {
+		if err := extractor.MarkDownloaded(postUrl); err != nil {
+			return path, err
+		}
+	}
+	if manifest != nil {
+		manifest.RecordPost(postUrl, post, path)
+		if err := manifest.Save(); err != nil {
+			return path, fmt.Errorf("failed to save manifest: %w", err)
+		}
+	}
+	return path, nil
+}
+
+// checkFormat exits if format isn't one of the formats posts can be written in.
+func checkFormat(format string) {
+	if !slices.Contains(lib.OutputFormats, format) {
+		fatalf("unknown format: %s (options: %s)", format, strings.Join(lib.OutputFormats, ", "))
+	}
+}
+
+// rewriteLinks rewrites the links between the posts recorded in manifest to the relative paths of their local files.
+// The links left untouched still work online, so errors are only logged.
+func rewriteLinks(manifest *lib.Manifest) {
+	changed, err := lib.NewLinkResolver(manifest).RewriteLinks()
+	if err != nil {
+		logger.Warn("failed to rewrite links between posts", "error", err)
+	}
+	logger.Debug("rewrote links between posts", "files", changed)
+}
+
 func init() {
 	downloadCmd.Flags().StringVarP(&downloadUrl, "url", "u", "", "Specify the Substack url")
 	downloadCmd.Flags().StringVarP(&format, "format", "f", "html", "Specify the output format (options: \"html\", \"md\", \"txt\"")
@@ -153,6 +259,8 @@ func init() {
 	downloadCmd.Flags().BoolVarP(&dryRun, "dry-run", "d", false, "Enable dry run")
 	downloadCmd.Flags().BoolVarP(&force, "force", "", false, "Force re-download of posts")
 	downloadCmd.Flags().StringVar(&logFile, "log-file", "downloaded_posts.log", "Specify the log file to track downloaded posts")
+	downloadCmd.Flags().StringVar(&reportFile, "report", "", "Write a JSON summary of every post processed to this file")
+	addFilterFlags(downloadCmd)
 	downloadCmd.MarkFlagRequired("url")
 }
 
@@ -214,28 +322,3 @@ func filterExistingPosts(urls []string, outputFolder string, format string) ([]s
 	}
 	return filtered, nil
 }
-
-// Añadir lógica para descargar archivos multimedia
-func downloadMedia(urls []string, outputFolder string) error {
-	for _, mediaUrl := range urls {
-		resp, err := http.Get(mediaUrl)
-		if err != nil {
-			return err
-		}
-		defer resp.Body.Close()
-
-		fileName := filepath.Base(mediaUrl)
-		outputPath := filepath.Join(outputFolder, fileName)
-		out, err := os.Create(outputPath)
-		if err != nil {
-			return err
-		}
-		defer out.Close()
-
-		_, err = io.Copy(out, resp.Body)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
-}
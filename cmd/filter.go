@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/alexferrari88/sbstck-dl/lib"
+	"github.com/spf13/cobra"
+)
+
+// flags selecting the posts listed or downloaded
+var (
+	filterTypes        []string
+	filterAudience     string
+	filterAuthors      []string
+	filterSections     []string
+	filterTags         []string
+	filterPattern      string
+	filterMinWordCount int
+	filterSlugs        []string
+	filterNewest       int
+	filterOldest       int
+)
+
+// addFilterFlags adds the flags selecting posts to cmd.
+func addFilterFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&filterTypes, "type", nil, "Only keep posts of these types (options: \"newsletter\", \"podcast\", \"thread\", \"video\")")
+	cmd.Flags().StringVar(&filterAudience, "audience", "", "Only keep free or paid posts (options: \"free\", \"paid\")")
+	cmd.Flags().StringSliceVar(&filterAuthors, "author", nil, "Only keep posts by these authors (name or handle)")
+	cmd.Flags().StringSliceVar(&filterSections, "section", nil, "Only keep posts in these sections (name or slug)")
+	cmd.Flags().StringSliceVar(&filterTags, "tag", nil, "Only keep posts with any of these tags (name or slug)")
+	cmd.Flags().StringVar(&filterPattern, "match", "", "Only keep posts whose title or slug matches this regular expression")
+	cmd.Flags().IntVar(&filterMinWordCount, "min-words", 0, "Only keep posts with at least this many words")
+	cmd.Flags().StringSliceVar(&filterSlugs, "slug", nil, "Only keep the posts with these slugs")
+	cmd.Flags().IntVar(&filterNewest, "newest", 0, "Only keep the N most recent matching posts")
+	cmd.Flags().IntVar(&filterOldest, "oldest", 0, "Only keep the N oldest matching posts")
+	cmd.MarkFlagsMutuallyExclusive("newest", "oldest")
+}
+
+// filterFlagsChanged reports whether any of the flags selecting posts was set on cmd.
+func filterFlagsChanged(cmd *cobra.Command) bool {
+	for _, name := range []string{"type", "audience", "author", "section", "tag", "match", "min-words", "slug", "newest", "oldest"} {
+		if cmd.Flags().Changed(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// postFilter returns the post filter set by the filter and date flags.
+// lastRun is the time of the previous run, which the last-run date expression refers to.
+func postFilter(lastRun time.Time) lib.PostFilter {
+	published, err := dateFilter(afterDate, beforeDate, lastRun)
+	if err != nil {
+		fatal(err)
+	}
+	filter := criteriaFilter()
+	filter.Published = published
+	return filter
+}
+
+// criteriaFilter returns the post filter set by the filter flags, leaving out the date flags
+// for the commands resolving them per publication.
+func criteriaFilter() lib.PostFilter {
+	filter := lib.PostFilter{
+		Types:        filterTypes,
+		Audience:     filterAudience,
+		Authors:      filterAuthors,
+		Sections:     filterSections,
+		Tags:         filterTags,
+		MinWordCount: filterMinWordCount,
+		Slugs:        filterSlugs,
+		Newest:       filterNewest,
+		Oldest:       filterOldest,
+	}
+	if filterPattern != "" {
+		pattern, err := regexp.Compile(filterPattern)
+		if err != nil {
+			fatalf("invalid --match pattern: %v", err)
+		}
+		filter.Pattern = pattern
+	}
+	if err := filter.Validate(); err != nil {
+		fatal(err)
+	}
+	return filter
+}
+
+// dateFilter returns the filter keeping the posts published between the after and before date expressions,
+// with the bounds set by --inclusive and --timezone. lastRun is the time the last-run expression refers to.
+func dateFilter(after string, before string, lastRun time.Time) (lib.DateFilter, error) {
+	return lib.ParseDateFilter(after, before, inclusiveDates, lib.DateContext{Now: runStart, LastRun: lastRun, Location: dateLocation})
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"github.com/alexferrari88/sbstck-dl/lib"
+	"github.com/cenkalti/backoff/v4"
+	"github.com/spf13/cobra"
+)
+
+// importCmd represents the import command
+var (
+	opmlFile           string
+	substackExportFile string
+	importOutputFile   string
+	importCmd          = &cobra.Command{
+		Use:   "import",
+		Short: "Create a subscription list from an OPML file or a Substack data export",
+		Long: `Create the subscription list used by the sync command from an OPML file
+(as exported by feed readers) or from a Substack account data export.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			var (
+				subs []lib.Subscription
+				err  error
+			)
+			if opmlFile != "" {
+				subs, err = importFile(opmlFile, lib.ImportOPML)
+			} else {
+				subs, err = importFile(substackExportFile, lib.ImportSubstackExport)
+			}
+			if ctx.Err() != nil {
+				fatal("interrupted")
+			}
+			if err != nil {
+				fatal(err)
+			}
+			logger.Debug("found publications", "count", len(subs))
+
+			out := os.Stdout
+			if importOutputFile != "" {
+				out, err = os.Create(importOutputFile)
+				if err != nil {
+					fatal(err)
+				}
+				defer out.Close()
+			}
+			if err := lib.WriteSubscriptions(out, subs); err != nil {
+				fatal(err)
+			}
+		},
+	}
+)
+
+// importFile opens path and parses it with the given import function, which checks the publications through probeFetcher.
+func importFile(path string, importFn func(context.Context, *lib.Fetcher, io.Reader) ([]lib.Subscription, error)) ([]lib.Subscription, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return importFn(ctx, probeFetcher(), f)
+}
+
+// probeFetcher returns the fetcher checking the publications to import. Many feeds aren't hosted by Substack,
+// so it doesn't send the session cookie, and it gives up on a host after a few seconds of failures.
+func probeFetcher() *lib.Fetcher {
+	b := backoff.NewExponentialBackOff()
+	b.MaxInterval = time.Second
+	b.MaxElapsedTime = 5 * time.Second
+	return lib.NewFetcher(lib.WithRatePerSecond(ratePerSecond), lib.WithProxyURL(parsedProxyURL), lib.WithBackOffConfig(b), lib.WithFetcherLogger(logger))
+}
+
+func init() {
+	importCmd.Flags().StringVar(&opmlFile, "opml", "", "Specify the OPML file to import")
+	importCmd.Flags().StringVar(&substackExportFile, "substack-export", "", "Specify the Substack data export (zip or subscriptions CSV) to import")
+	importCmd.Flags().StringVarP(&importOutputFile, "output", "o", "", "Specify the subscription list file to write (default stdout)")
+	importCmd.MarkFlagsOneRequired("opml", "substack-export")
+	importCmd.MarkFlagsMutuallyExclusive("opml", "substack-export")
+}
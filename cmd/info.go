@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/alexferrari88/sbstck-dl/lib"
+	"github.com/k3a/html2text"
+	"github.com/spf13/cobra"
+)
+
+// infoOutputs are the formats the info command can print the publication metadata in.
+var infoOutputs = []string{"text", "json"}
+
+// infoCmd represents the info command
+var (
+	infoUrl    string
+	infoOutput string
+	infoCmd    = &cobra.Command{
+		Use:   "info",
+		Short: "Show the metadata of a Substack",
+		Long: `Show the name, subtitle, authors, sections, subscription tiers and About page of a Substack.
+
+The same metadata is saved to publication.json when downloading or syncing
+the archive of a publication.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if !slices.Contains(infoOutputs, infoOutput) {
+				fatalf("unknown output format: %s (options: %s)", infoOutput, strings.Join(infoOutputs, ", "))
+			}
+			parsedURL, err := parseURL(infoUrl)
+			if err != nil {
+				fatal(err)
+			}
+			pub, err := extractor.GetPublication(ctx, parsedURL.String())
+			if err != nil {
+				fatal(err)
+			}
+			if infoOutput == "json" {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				err = enc.Encode(pub)
+			} else {
+				err = writePublicationText(os.Stdout, pub)
+			}
+			if err != nil {
+				fatal(err)
+			}
+		},
+	}
+)
+
+// writePublicationText writes the publication metadata to w in a human readable form.
+func writePublicationText(w io.Writer, pub lib.Publication) error {
+	var sb strings.Builder
+	sb.WriteString(pub.Name + "\n")
+	if pub.Subtitle != "" {
+		sb.WriteString(pub.Subtitle + "\n")
+	}
+	sb.WriteString("\nUrl: " + pub.Url + "\n")
+	if pub.CustomDomain != "" {
+		sb.WriteString("Substack: https://" + pub.Subdomain + ".substack.com\n")
+	}
+	if pub.Language != "" {
+		sb.WriteString("Language: " + pub.Language + "\n")
+	}
+	if pub.LogoUrl != "" {
+		sb.WriteString("Logo: " + pub.LogoUrl + "\n")
+	}
+
+	if len(pub.Authors) > 0 {
+		sb.WriteString("\nAuthors:\n")
+		for _, a := range pub.Authors {
+			sb.WriteString("  " + a.Name)
+			if a.Handle != "" {
+				sb.WriteString(" (@" + a.Handle + ")")
+			}
+			sb.WriteString("\n")
+			if a.Bio != "" {
+				sb.WriteString("    " + a.Bio + "\n")
+			}
+		}
+	}
+	if len(pub.Sections) > 0 {
+		sb.WriteString("\nSections:\n")
+		for _, s := range pub.Sections {
+			sb.WriteString("  " + s.Name + " (" + s.Slug + ")\n")
+		}
+	}
+	if len(pub.Tiers) > 0 {
+		sb.WriteString("\nTiers:\n")
+		for _, t := range pub.Tiers {
+			name := t.Name
+			if name == "" {
+				name = t.Id
+			}
+			fmt.Fprintf(&sb, "  %s: %.2f %s per %s\n", name, float64(t.Amount)/100, strings.ToUpper(t.Currency), t.Interval)
+		}
+	}
+	if pub.AboutHTML != "" {
+		sb.WriteString("\nAbout:\n\n" + html2text.HTML2Text(pub.AboutHTML) + "\n")
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// savePublication saves the metadata of the publication at pubUrl to the archive in folder,
+// and records its domains in manifest.
+// Failing to do so doesn't prevent downloading its posts, so errors are only logged.
+func savePublication(extractor *lib.Extractor, manifest *lib.Manifest, pubUrl string, folder string) {
+	pub, err := extractor.GetPublication(ctx, pubUrl)
+	if err == nil {
+		manifest.RecordPublication(pubUrl, pub)
+		err = pub.Save(folder)
+	}
+	if err != nil {
+		logger.Warn("failed to save publication metadata", "url", pubUrl, "error", err)
+	}
+}
+
+func init() {
+	infoCmd.Flags().StringVarP(&infoUrl, "url", "u", "", "Specify the Substack url")
+	infoCmd.Flags().StringVar(&infoOutput, "output", "text", "Specify the output format (options: "+strings.Join(infoOutputs, ", ")+")")
+	infoCmd.MarkFlagRequired("url")
+}
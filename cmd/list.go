@@ -2,36 +2,60 @@ package cmd
 
 import (
 	"fmt"
-	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/alexferrari88/sbstck-dl/lib"
 	"github.com/spf13/cobra"
 )
 
+// listOutputs are the formats the list command can print posts in.
+var listOutputs = []string{"urls", "json", "ndjson", "csv", "table"}
+
 // listCmd represents the list command
 var (
-	pubUrl  string
-	listCmd = &cobra.Command{
+	pubUrl     string
+	listOutput string
+	listCmd    = &cobra.Command{
 		Use:   "list",
 		Short: "List the posts of a Substack",
-		Long:  `List the posts of a Substack`,
+		Long: `List the posts of a Substack.
+
+By default only the url of each post is printed. The other output formats
+include the id, slug, title, date, audience, type and word count of each post.`,
 		Run: func(cmd *cobra.Command, args []string) {
+			if !slices.Contains(listOutputs, listOutput) {
+				fatalf("unknown output format: %s (options: %s)", listOutput, strings.Join(listOutputs, ", "))
+			}
+			filter := postFilter(time.Time{})
 			parsedURL, err := parseURL(pubUrl)
 			if err != nil {
-				log.Fatal(err)
+				fatal(err)
 			}
 			mainWebsite := fmt.Sprintf("%s://%s", parsedURL.Scheme, parsedURL.Host)
-			if verbose {
-				fmt.Printf("Main website: %s\n", mainWebsite)
-				fmt.Println("Getting all posts URLs...")
+
+			if listOutput != "urls" {
+				logger.Debug("getting the archive", "website", mainWebsite)
+				posts, err := extractor.GetArchive(ctx, mainWebsite, nil)
+				if err != nil {
+					fatal(err)
+				}
+				posts = filter.Apply(posts)
+				logger.Debug("found posts", "count", len(posts))
+				if err := lib.WritePostSummaries(os.Stdout, posts, listOutput); err != nil {
+					fatal(err)
+				}
+				return
 			}
-			dateFilterfunc := makeDateFilterFunc(beforeDate, afterDate)
-			urls, err := extractor.GetAllPostsURLs(ctx, mainWebsite, dateFilterfunc)
+
+			logger.Debug("getting all posts URLs", "website", mainWebsite)
+			urls, err := extractor.GetPostsURLs(ctx, mainWebsite, filter)
 			if err != nil {
-				log.Fatal(err)
-			}
-			if verbose {
-				fmt.Printf("Found %d posts.\n", len(urls))
+				fatal(err)
 			}
+			logger.Debug("found posts", "count", len(urls))
 			for _, url := range urls {
 				fmt.Println(url)
 			}
@@ -41,5 +65,7 @@ var (
 
 func init() {
 	listCmd.Flags().StringVarP(&pubUrl, "url", "u", "", "Specify the Substack url")
+	listCmd.Flags().StringVar(&listOutput, "output", "urls", "Specify the output format (options: "+strings.Join(listOutputs, ", ")+")")
+	addFilterFlags(listCmd)
 	listCmd.MarkFlagRequired("url")
 }
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"path/filepath"
+
+	"github.com/alexferrari88/sbstck-dl/lib"
+	"github.com/spf13/cobra"
+)
+
+// notesCmd represents the notes command
+var (
+	notesUrl          string
+	notesHandle       string
+	notesFormat       string
+	notesOutputFolder string
+	notesForce        bool
+	notesThreads      bool
+	notesCmd          = &cobra.Command{
+		Use:   "notes",
+		Short: "Archive the Substack Notes of a publication or an author",
+		Long: `Archive the Substack Notes of a publication (--url) or an author (--user),
+and with --threads the community threads (Substack Chat) of a publication.
+
+Notes and threads are not posts, so they are not downloaded by the download command.
+They are stored with their attachments and the notes they reply to in a folder per
+publication or author under the notes folder of the output directory, and rendered
+into one file per month. Later runs only fetch the ones published since the previous one.
+Threads are usually only visible to subscribers: pass the cookie of your session.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			checkFormat(notesFormat)
+			if notesThreads && notesUrl == "" {
+				fatal("--threads requires the --url of a publication")
+			}
+
+			src := lib.NotesSource{Handle: notesHandle}
+			if notesUrl != "" {
+				parsedURL, err := parseURL(notesUrl)
+				if err != nil {
+					fatal(err)
+				}
+				src.PubUrl = parsedURL.String()
+			}
+
+			archive, err := lib.LoadNotesArchive(filepath.Join(notesOutputFolder, lib.NotesFolder, src.Folder()))
+			if err != nil {
+				fatalf("failed to load notes: %v", err)
+			}
+			var known map[int]bool
+			if !notesForce {
+				known = archive.IDs()
+			}
+
+			notes, err := extractor.FetchNotes(ctx, src, known)
+			if err != nil {
+				fatalf("failed to fetch notes: %v", err)
+			}
+			added := archive.Add(notes)
+
+			addedThreads := 0
+			if notesThreads {
+				var knownThreads map[string]bool
+				if !notesForce {
+					knownThreads = archive.ThreadIDs()
+				}
+				threads, err := extractor.FetchThreads(ctx, src.PubUrl, knownThreads)
+				if err != nil {
+					fatalf("failed to fetch threads: %v", err)
+				}
+				addedThreads = archive.AddThreads(threads)
+			}
+
+			// with --shared-media, the images are saved once in the media store of the output directory
+			mediaExtractor := extractor
+			var manifest *lib.Manifest
+			if sharedMedia {
+				manifest, err = lib.LoadManifest(notesOutputFolder)
+				if err != nil {
+					fatalf("failed to load manifest: %v", err)
+				}
+				mediaExtractor, err = lib.NewExtractor(fetcher, filepath.Join(notesOutputFolder, "downloaded_posts.log"), extractorOptions(manifest)...)
+				if err != nil {
+					fatalf("failed to create extractor: %v", err)
+				}
+			}
+			for mediaUrl, err := range mediaExtractor.DownloadNoteMedia(ctx, archive) {
+				logger.Warn("failed to download media", "url", mediaUrl, "error", err)
+			}
+			if manifest != nil {
+				if err := manifest.Save(); err != nil {
+					fatalf("failed to save manifest: %v", err)
+				}
+			}
+			if err := archive.Save(); err != nil {
+				fatalf("failed to save notes: %v", err)
+			}
+
+			paths, err := archive.WriteMonths(notesFormat, outputOptions()...)
+			if err != nil {
+				fatalf("failed to write notes: %v", err)
+			}
+			logger.Info("notes archived", "new", added, "total", len(archive.Notes), "new_threads", addedThreads, "threads", len(archive.Threads), "months", len(paths), "folder", archive.Folder())
+		},
+	}
+)
+
+func init() {
+	notesCmd.Flags().StringVarP(&notesUrl, "url", "u", "", "Specify the Substack url of the publication")
+	notesCmd.Flags().StringVar(&notesHandle, "user", "", "Specify the handle of the author")
+	notesCmd.Flags().StringVarP(&notesFormat, "format", "f", "html", "Specify the output format (options: \"html\", \"md\", \"txt\"")
+	notesCmd.Flags().StringVarP(&notesOutputFolder, "output", "o", ".", "Specify the download directory")
+	notesCmd.Flags().BoolVarP(&notesForce, "force", "", false, "Fetch all the notes again, not only the new ones")
+	notesCmd.Flags().BoolVar(&notesThreads, "threads", false, "Also archive the community threads (Substack Chat) of the publication")
+	notesCmd.MarkFlagsOneRequired("url", "user")
+	notesCmd.MarkFlagsMutuallyExclusive("url", "user")
+}
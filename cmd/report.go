@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/alexferrari88/sbstck-dl/lib"
+)
+
+// Statuses of a post in a download report.
+const (
+	postDownloaded = "downloaded"
+	postSkipped    = "skipped"
+	postFailed     = "failed"
+)
+
+// downloadReport is the JSON summary of a download, written with --report.
+// A nil report records nothing.
+type downloadReport struct {
+	Url            string       `json:"url"`
+	Output         string       `json:"output"`
+	Format         string       `json:"format"`
+	StartedAt      time.Time    `json:"started_at"`
+	ElapsedSeconds float64      `json:"elapsed_seconds"`
+	Downloaded     int          `json:"downloaded"`
+	Skipped        int          `json:"skipped"`
+	Failed         int          `json:"failed"`
+	Posts          []postReport `json:"posts"`
+}
+
+// postReport is the outcome of a single post in a download report.
+type postReport struct {
+	Url    string `json:"url"`
+	Status string `json:"status"`
+	Slug   string `json:"slug,omitempty"`
+	Title  string `json:"title,omitempty"`
+	// Path is the file the post was written to.
+	Path string `json:"path,omitempty"`
+	// Media lists the files of the media downloaded for the post, which MediaCount counts.
+	Media       []string          `json:"media,omitempty"`
+	MediaCount  int               `json:"media_count"`
+	MediaFailed int               `json:"media_failed"`
+	MediaErrors map[string]string `json:"media_errors,omitempty"`
+	// CommentsError is the error of the comments that failed to download, if any.
+	CommentsError string `json:"comments_error,omitempty"`
+	Error         string `json:"error,omitempty"`
+	// ElapsedSeconds is the time it took to download the post, including its media.
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+}
+
+// newDownloadReport returns an empty report for the download of url into outputFolder, started now.
+func newDownloadReport(url string, outputFolder string, format string) *downloadReport {
+	return &downloadReport{
+		Url:       url,
+		Output:    outputFolder,
+		Format:    format,
+		StartedAt: time.Now(),
+		Posts:     []postReport{},
+	}
+}
+
+// addPost records the post at postUrl as written to path.
+func (r *downloadReport) addPost(postUrl string, post lib.Post, path string, elapsed time.Duration) {
+	if r == nil {
+		return
+	}
+	pr := postReport{
+		Url:            postUrl,
+		Status:         postDownloaded,
+		Slug:           post.Slug,
+		Title:          post.Title,
+		Path:           path,
+		MediaFailed:    len(post.MediaErrors),
+		ElapsedSeconds: elapsed.Seconds(),
+	}
+	for _, file := range post.Media {
+		pr.Media = append(pr.Media, filepath.Join(filepath.Dir(path), filepath.FromSlash(file.Path)))
+	}
+	sort.Strings(pr.Media)
+	pr.Media = slices.Compact(pr.Media) // several URLs may point to the same file
+	pr.MediaCount = len(pr.Media)
+	if len(post.MediaErrors) > 0 {
+		pr.MediaErrors = make(map[string]string, len(post.MediaErrors))
+		for mediaUrl, err := range post.MediaErrors {
+			pr.MediaErrors[mediaUrl] = err.Error()
+		}
+	}
+	if post.CommentsErr != nil {
+		pr.CommentsError = post.CommentsErr.Error()
+	}
+	r.Downloaded++
+	r.Posts = append(r.Posts, pr)
+}
+
+// addFailure records the post at postUrl as failed with err.
+func (r *downloadReport) addFailure(postUrl string, err error, elapsed time.Duration) {
+	if r == nil {
+		return
+	}
+	r.Failed++
+	r.Posts = append(r.Posts, postReport{Url: postUrl, Status: postFailed, Error: err.Error(), ElapsedSeconds: elapsed.Seconds()})
+}
+
+// addSkipped records the post at postUrl as skipped, because it was already downloaded.
+func (r *downloadReport) addSkipped(postUrl string) {
+	if r == nil {
+		return
+	}
+	r.Skipped++
+	r.Posts = append(r.Posts, postReport{Url: postUrl, Status: postSkipped})
+}
+
+// save atomically writes the report to path, as indented JSON.
+func (r *downloadReport) save(path string) error {
+	if r == nil {
+		return nil
+	}
+	r.ElapsedSeconds = time.Since(r.StartedAt).Seconds()
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return lib.WriteFileAtomic(path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
@@ -3,12 +3,18 @@ package cmd
 import (
 	"context"
 	"errors"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/alexferrari88/sbstck-dl/lib"
+	"github.com/alexferrari88/sbstck-dl/plugin"
 	"github.com/spf13/cobra"
 )
 
@@ -40,36 +46,96 @@ func (c *cookieName) Type() string {
 }
 
 var (
-	proxyURL       string
-	verbose        bool
-	ratePerSecond  int
-	beforeDate     string
-	afterDate      string
-	idCookieName   cookieName
-	idCookieVal    string
-	ctx            = context.Background()
-	parsedProxyURL *url.URL
-	fetcher        *lib.Fetcher
-	extractor      *lib.Extractor
+	proxyURL         string
+	verbose          bool
+	logFormat        string
+	logLevel         string
+	logger           = slog.New(slog.NewTextHandler(os.Stderr, nil))
+	ratePerSecond    int
+	concurrency      int
+	orderedResults   bool
+	mediaRate        int
+	mediaConcurrency int
+	strictMedia      bool
+	sharedMedia      bool
+	imageQuality     string
+	imagePolicy      lib.ImagePolicy
+	skipPodcasts     bool
+	skipVideos       bool
+	archiveEmbeds    bool
+	comments         bool
+	mdDisabledRules  []string
+	mediaFetcher     *lib.Fetcher
+	beforeDate       string
+	afterDate        string
+	sinceDate        string
+	inclusiveDates   bool
+	timezone         string
+	dateLocation     *time.Location
+	runStart         = time.Now()
+	idCookieName     cookieName
+	idCookieVal      string
+	ctx              = context.Background()
+	parsedProxyURL   *url.URL
+	fetcher          *lib.Fetcher
+	extractor        *lib.Extractor
 
 	rootCmd = &cobra.Command{
 		Use:   "sbstck-dl",
 		Short: "Substack Downloader",
 		Long:  `sbstck-dl is a command line tool for downloading Substack newsletters for archival purposes, offline reading, or data analysis.`,
 		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			l, err := newLogger(logFormat, logLevel, verbose)
+			if err != nil {
+				fatal(err)
+			}
+			logger = l
 
 			var cookie *http.Cookie
 
 			if proxyURL != "" {
-				var err error
 				parsedProxyURL, err = parseURL(proxyURL)
 				if err != nil {
-					log.Fatal(err)
+					fatal(err)
 				}
 			}
 
 			if ratePerSecond == 0 {
-				log.Fatal("rate must be greater than 0")
+				fatal("rate must be greater than 0")
+			}
+
+			if concurrency <= 0 {
+				fatal("concurrency must be greater than 0")
+			}
+
+			imagePolicy, err = lib.ParseImagePolicy(imageQuality)
+			if err != nil {
+				fatal(err)
+			}
+
+			if sinceDate != "" {
+				if afterDate != "" {
+					fatal("--since and --after can't be used together")
+				}
+				afterDate = sinceDate
+			}
+			dateLocation = time.Local
+			if timezone != "" {
+				dateLocation, err = time.LoadLocation(timezone)
+				if err != nil {
+					fatalf("invalid time zone: %v", err)
+				}
+			}
+			// check the dates early, leaving last-run to be resolved by each command
+			dc := lib.DateContext{Now: runStart, LastRun: runStart, Location: dateLocation}
+			if _, err := lib.ParseDateFilter(afterDate, beforeDate, inclusiveDates, dc); err != nil {
+				fatal(err)
+			}
+
+			for _, rule := range mdDisabledRules {
+				if !plugin.IsSubstackRule(rule) {
+					fatalf("unknown Markdown rule: %s (options: %s)", rule, strings.Join(plugin.SubstackRules(), ", "))
+				}
 			}
 
 			if idCookieVal != "" && idCookieName != "" {
@@ -86,16 +152,23 @@ var (
 				}
 			}
 
-			fetcher = lib.NewFetcher(lib.WithRatePerSecond(ratePerSecond), lib.WithProxyURL(parsedProxyURL), lib.WithCookie(cookie))
-			extractor, _ = lib.NewExtractor(fetcher, "downloaded_posts.log") // Default log file, can be overridden by flags
+			fetcher = lib.NewFetcher(lib.WithRatePerSecond(ratePerSecond), lib.WithProxyURL(parsedProxyURL), lib.WithCookie(cookie), lib.WithFetcherLogger(logger))
+			if mediaRate > 0 {
+				mediaFetcher = lib.NewFetcher(lib.WithRatePerSecond(mediaRate), lib.WithProxyURL(parsedProxyURL), lib.WithCookie(cookie), lib.WithFetcherLogger(logger))
+			}
+			extractor, _ = lib.NewExtractor(fetcher, "downloaded_posts.log", extractorOptions(nil)...) // Default log file, can be overridden by flags
 		},
 	}
 )
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
+// The context shared by all commands is cancelled on SIGINT (Ctrl-C) or SIGTERM.
 func Execute() {
+	var stop context.CancelFunc
+	ctx, stop = signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	err := rootCmd.Execute()
+	stop()
 	if err != nil {
 		os.Exit(1)
 	}
@@ -105,31 +178,95 @@ func init() {
 	rootCmd.PersistentFlags().StringVarP(&proxyURL, "proxy", "x", "", "Specify the proxy url")
 	rootCmd.PersistentFlags().Var(&idCookieName, "cookie_name", "Either \"substack.sid\" or \"connect.sid\", based on the cookie you have (required for private newsletters)")
 	rootCmd.PersistentFlags().StringVar(&idCookieVal, "cookie_val", "", "The substack.sid/connect.sid cookie value (required for private newsletters)")
-	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Enable verbose output")
+	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Enable verbose output (same as --log-level debug)")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "text", "Specify the format of the logs written to stderr (options: \"text\", \"json\")")
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "Specify the minimum level of the logs written to stderr (options: \"debug\", \"info\", \"warn\", \"error\")")
 	rootCmd.PersistentFlags().IntVarP(&ratePerSecond, "rate", "r", lib.DefaultRatePerSecond, "Specify the rate of requests per second")
-	rootCmd.PersistentFlags().StringVar(&beforeDate, "before", "", "Download posts published before this date (format: YYYY-MM-DD)")
-	rootCmd.PersistentFlags().StringVar(&afterDate, "after", "", "Download posts published after this date (format: YYYY-MM-DD)")
+	rootCmd.PersistentFlags().IntVarP(&concurrency, "concurrency", "c", lib.DefaultConcurrency, "Specify the number of posts downloaded concurrently")
+	rootCmd.PersistentFlags().BoolVar(&orderedResults, "ordered", false, "Process downloaded posts in the same order as they are listed")
+	rootCmd.PersistentFlags().IntVar(&mediaRate, "media-rate", 0, "Specify a separate rate of requests per second for media files (default: share --rate)")
+	rootCmd.PersistentFlags().IntVar(&mediaConcurrency, "media-concurrency", lib.DefaultMediaConcurrency, "Specify the number of media files of a post downloaded concurrently")
+	rootCmd.PersistentFlags().BoolVar(&strictMedia, "strict-media", false, "Fail a post when any of its media files can't be downloaded")
+	rootCmd.PersistentFlags().StringVar(&imageQuality, "image-quality", lib.ImageOriginal, "Specify which version of images to download (options: \"original\", \"largest\", or a width in pixels)")
+	rootCmd.PersistentFlags().BoolVar(&sharedMedia, "shared-media", false, "Save media files once in a shared, content-addressed media folder at the root of the output directory")
+	rootCmd.PersistentFlags().BoolVar(&skipPodcasts, "skip-podcasts", false, "Don't download the audio of podcast episodes")
+	rootCmd.PersistentFlags().BoolVar(&archiveEmbeds, "archive-embeds", false, "Replace embedded tweets, videos and other external content with a local copy of their text and preview image")
+	rootCmd.PersistentFlags().BoolVar(&comments, "comments", false, "Download the comments of each post, saved to comments.json and rendered as a thread after the post")
+	rootCmd.PersistentFlags().BoolVar(&skipVideos, "skip-videos", false, "Don't download Substack-hosted videos")
+	rootCmd.PersistentFlags().StringSliceVar(&mdDisabledRules, "md-disable", nil, "Disable Substack-specific Markdown rules (options: "+strings.Join(plugin.SubstackRules(), ", ")+")")
+	rootCmd.PersistentFlags().StringVar(&beforeDate, "before", "", "Download posts published before this date (YYYY-MM-DD, RFC 3339 timestamp, period such as 30d, or last-run)")
+	rootCmd.PersistentFlags().StringVar(&afterDate, "after", "", "Download posts published after this date (YYYY-MM-DD, RFC 3339 timestamp, period such as 30d, or last-run)")
+	rootCmd.PersistentFlags().StringVar(&sinceDate, "since", "", "Download posts published in this period, e.g. 30d or 2w (same as --after)")
+	rootCmd.PersistentFlags().BoolVar(&inclusiveDates, "inclusive", false, "Include the posts published on the --before and --after dates")
+	rootCmd.PersistentFlags().StringVar(&timezone, "timezone", "", "Specify the time zone of dates, e.g. Europe/Rome (default: local time zone)")
 	rootCmd.MarkFlagsRequiredTogether("cookie_name", "cookie_val")
 
 	rootCmd.AddCommand(downloadCmd)
 	rootCmd.AddCommand(listCmd)
+	rootCmd.AddCommand(syncCmd)
+	rootCmd.AddCommand(importCmd)
+	rootCmd.AddCommand(watchCmd)
+	rootCmd.AddCommand(notesCmd)
+	rootCmd.AddCommand(infoCmd)
 	rootCmd.AddCommand(versionCmd)
 }
 
-func makeDateFilterFunc(beforeDate string, afterDate string) lib.DateFilterFunc {
-	var dateFilterFunc lib.DateFilterFunc
-	if beforeDate != "" && afterDate != "" {
-		dateFilterFunc = func(date string) bool {
-			return date > afterDate && date < beforeDate
-		}
-	} else if beforeDate != "" {
-		dateFilterFunc = func(date string) bool {
-			return date < beforeDate
-		}
-	} else if afterDate != "" {
-		dateFilterFunc = func(date string) bool {
-			return date > afterDate
-		}
+// extractorOptions returns the Extractor options set by the global flags.
+// manifest is the manifest of the archive the posts are downloaded into, if any.
+func extractorOptions(manifest *lib.Manifest) []lib.ExtractorOption {
+	opts := []lib.ExtractorOption{
+		lib.WithConcurrency(concurrency),
+		lib.WithOrderedResults(orderedResults),
+		lib.WithMediaFetcher(mediaFetcher),
+		lib.WithMediaConcurrency(mediaConcurrency),
+		lib.WithStrictMedia(strictMedia),
+		lib.WithImagePolicy(imagePolicy),
+		lib.WithSkipPodcasts(skipPodcasts),
+		lib.WithSkipVideos(skipVideos),
+		lib.WithArchiveEmbeds(archiveEmbeds),
+		lib.WithComments(comments),
+		lib.WithExtractorLogger(logger),
+	}
+	if sharedMedia && manifest != nil {
+		opts = append(opts, lib.WithMediaStore(lib.NewMediaStore(manifest)))
+	}
+	return opts
+}
+
+// outputOptions returns the options for writing posts set by the global flags.
+func outputOptions() []lib.OutputOption {
+	return []lib.OutputOption{lib.WithoutMarkdownRules(mdDisabledRules...), lib.WithOutputLogger(logger)}
+}
+
+// newLogger returns the logger writing to stderr in the given format ("text" or "json"),
+// dropping the records below level ("debug", "info", "warn" or "error"). verbose forces the debug level.
+func newLogger(format string, level string, verbose bool) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level: %s", level)
 	}
-	return dateFilterFunc
+	if verbose {
+		lvl = slog.LevelDebug
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format: %s", format)
+	}
+}
+
+// fatal logs v as an error and exits.
+func fatal(v ...any) {
+	logger.Error(fmt.Sprint(v...))
+	os.Exit(1)
+}
+
+// fatalf logs the formatted message as an error and exits.
+func fatalf(format string, v ...any) {
+	logger.Error(fmt.Sprintf(format, v...))
+	os.Exit(1)
 }
@@ -0,0 +1,192 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/alexferrari88/sbstck-dl/lib"
+	"github.com/spf13/cobra"
+)
+
+// syncResult holds the outcome of syncing a single publication.
+type syncResult struct {
+	url        string
+	found      int
+	downloaded int
+	failed     int
+	err        error
+}
+
+// syncCmd represents the sync command
+var (
+	subscriptionsFile string
+	syncFormat        string
+	syncOutputFolder  string
+	syncForce         bool
+	syncCmd           = &cobra.Command{
+		Use:   "sync",
+		Short: "Download new posts from a list of Substacks",
+		Long: `Download new posts from every publication in a subscription list.
+
+The list contains one publication url per line, optionally followed by
+per-entry overrides of the format, dates and filter flags, e.g.:
+
+  https://example.substack.com format=md after=2023-01-01 type=podcast,video
+
+Without --file, the list is read from sbstck-dl/subscriptions.txt in the user
+configuration directory (e.g. ~/.config on Linux).
+Each publication is downloaded into its own subfolder of the output directory.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			startTime := time.Now()
+			checkFormat(syncFormat)
+			filter := criteriaFilter()
+
+			file := subscriptionsFile
+			if file == "" {
+				var err error
+				if file, err = configSubscriptionsFile(); err != nil {
+					fatalf("failed to find the subscription list, pass it with --file: %v", err)
+				}
+				logger.Debug("reading subscriptions from the configuration directory", "file", file)
+			}
+			subs, err := lib.ReadSubscriptions(file)
+			if err != nil {
+				fatalf("failed to read subscriptions: %v", err)
+			}
+			if len(subs) == 0 {
+				logger.Info("no subscriptions found, exiting")
+				return
+			}
+
+			manifest, err := lib.LoadManifest(syncOutputFolder)
+			if err != nil {
+				fatalf("failed to load manifest: %v", err)
+			}
+
+			var results []syncResult
+			for _, sub := range subs {
+				if ctx.Err() != nil {
+					logger.Warn("interrupted, skipping remaining publications")
+					break
+				}
+				logger.Debug("syncing publication", "url", sub.Url)
+				results = append(results, syncPublication(sub, syncOutputFolder, syncFormat, syncForce, filter, manifest))
+			}
+			for _, r := range results {
+				if r.downloaded > 0 {
+					rewriteLinks(manifest)
+					break
+				}
+			}
+			if err := manifest.Save(); err != nil {
+				fatalf("failed to save manifest: %v", err)
+			}
+
+			var downloadedPostsCount, failedCount int
+			fmt.Println("Sync summary:")
+			for _, r := range results {
+				downloadedPostsCount += r.downloaded
+				if r.err != nil {
+					failedCount++
+					fmt.Printf("  %s: error: %s\n", r.url, r.err)
+					continue
+				}
+				if r.failed > 0 {
+					fmt.Printf("  %s: %d new posts, %d failed (%d found)\n", r.url, r.downloaded, r.failed, r.found)
+				} else {
+					fmt.Printf("  %s: %d new posts (%d found)\n", r.url, r.downloaded, r.found)
+				}
+			}
+			fmt.Printf("Downloaded %d new posts from %d publications (%d failed) in %s\n",
+				downloadedPostsCount, len(results)-failedCount, failedCount, time.Since(startTime))
+		},
+	}
+)
+
+// syncPublication downloads the new posts of a single subscription matching filter, overridden by the criteria
+// of the subscription, into its own subfolder of outputFolder, skipping the posts already recorded in the manifest with all their media files unless force is set.
+// The publication is only marked as checked once all its new posts are downloaded, so that the failed ones
+// are retried by the next sync relative to last-run. All publications share the same rate-limited fetcher.
+func syncPublication(sub lib.Subscription, outputFolder string, format string, force bool, filter lib.PostFilter, manifest *lib.Manifest) syncResult {
+	result := syncResult{url: sub.Url}
+
+	if sub.Format != "" {
+		format = sub.Format
+	}
+	before, after := beforeDate, afterDate
+	if sub.Before != "" {
+		before = sub.Before
+	}
+	if sub.After != "" {
+		after = sub.After
+	}
+
+	pubFolder := filepath.Join(outputFolder, sub.Folder())
+	pubExtractor, err := lib.NewExtractor(fetcher, filepath.Join(pubFolder, "downloaded_posts.log"), extractorOptions(manifest)...)
+	if err != nil {
+		result.err = err
+		return result
+	}
+
+	checkedAt := time.Now()
+	published, err := dateFilter(after, before, manifest.LastChecked(sub.Url))
+	if err != nil {
+		result.err = err
+		return result
+	}
+	filter = filter.Override(sub.Filter)
+	filter.Published = published
+	urls, err := pubExtractor.GetPostsURLs(ctx, sub.Url, filter)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	result.found = len(urls)
+	savePublication(pubExtractor, manifest, sub.Url, pubFolder)
+	urls = withIncompletePosts(urls, manifest, pubFolder)
+
+	if !force {
+		var newUrls []string
+		for _, u := range urls {
+			if !manifest.HasCompletePost(u) {
+				newUrls = append(newUrls, u)
+			}
+		}
+		urls = newUrls
+	}
+	if len(urls) == 0 {
+		manifest.MarkChecked(sub.Url, checkedAt)
+		return result
+	}
+
+	var errs, writeErrs lib.ExtractErrors
+	result.downloaded, errs, writeErrs = downloadPosts(pubExtractor, urls, pubFolder, format, force, manifest, nil)
+	result.failed = len(errs) + len(writeErrs)
+	if len(writeErrs) > 0 {
+		result.err = fmt.Errorf("failed to save %d posts", len(writeErrs))
+	}
+	if result.failed == 0 && ctx.Err() == nil {
+		manifest.MarkChecked(sub.Url, checkedAt)
+	}
+	return result
+}
+
+// configSubscriptionsFile returns the path of the subscription list read by sync without --file,
+// in the configuration directory of the user.
+func configSubscriptionsFile() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "sbstck-dl", "subscriptions.txt"), nil
+}
+
+func init() {
+	syncCmd.Flags().StringVarP(&subscriptionsFile, "file", "i", "", "Specify the subscription list file (default: sbstck-dl/subscriptions.txt in the user configuration directory)")
+	syncCmd.Flags().StringVarP(&syncFormat, "format", "f", "html", "Specify the default output format (options: \"html\", \"md\", \"txt\"")
+	syncCmd.Flags().StringVarP(&syncOutputFolder, "output", "o", ".", "Specify the download directory")
+	syncCmd.Flags().BoolVarP(&syncForce, "force", "", false, "Force re-download of posts")
+	addFilterFlags(syncCmd)
+}
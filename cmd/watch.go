@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/alexferrari88/sbstck-dl/lib"
+	"github.com/spf13/cobra"
+)
+
+// watchCmd represents the watch command
+var (
+	watchUrl          string
+	watchFile         string
+	watchFormat       string
+	watchOutputFolder string
+	watchInterval     time.Duration
+	watchJitter       time.Duration
+	watchCmd          = &cobra.Command{
+		Use:   "watch",
+		Short: "Continuously poll Substacks and download new posts",
+		Long: `Run continuously, polling one Substack (--url) or every publication in a
+subscription list (--file) on an interval and downloading new posts as they appear.
+
+State is persisted in the archive manifest, so a restarted watch resumes where it left off.
+Stop it with Ctrl-C (SIGINT) or SIGTERM.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if watchInterval <= 0 {
+				fatal("interval must be greater than 0")
+			}
+			if watchJitter < 0 || watchJitter >= watchInterval {
+				fatal("jitter must be at least 0 and less than the interval")
+			}
+			checkFormat(watchFormat)
+			filter := criteriaFilter()
+			// watch runs unattended, its progress is reported through the logger only
+			showProgress = false
+
+			var subs []lib.Subscription
+			if watchUrl != "" {
+				subs = []lib.Subscription{{Url: watchUrl}}
+			} else {
+				var err error
+				subs, err = lib.ReadSubscriptions(watchFile)
+				if err != nil {
+					fatalf("failed to read subscriptions: %v", err)
+				}
+			}
+			if len(subs) == 0 {
+				logger.Info("no subscriptions found, exiting")
+				return
+			}
+
+			manifest, err := lib.LoadManifest(watchOutputFolder)
+			if err != nil {
+				fatalf("failed to load manifest: %v", err)
+			}
+
+			for {
+				pollStart := time.Now()
+				downloaded, failed := 0, 0
+				for _, sub := range subs {
+					if ctx.Err() != nil {
+						break
+					}
+					logger.Debug("checking publication", "url", sub.Url)
+					result := syncPublication(sub, watchOutputFolder, watchFormat, false, filter, manifest)
+					downloaded += result.downloaded
+					failed += result.failed
+					if result.err != nil {
+						logger.Error("failed to check publication", "url", sub.Url, "error", result.err)
+					} else if result.downloaded > 0 {
+						logger.Info("downloaded new posts", "url", sub.Url, "count", result.downloaded)
+					}
+					if err := manifest.Save(); err != nil {
+						fatalf("failed to save manifest: %v", err)
+					}
+				}
+				if downloaded > 0 {
+					rewriteLinks(manifest)
+				}
+
+				wait := nextPollWait(watchInterval, watchJitter)
+				if ctx.Err() == nil {
+					logger.Info("checked publications", "count", len(subs), "downloaded", downloaded, "failed", failed, "duration", time.Since(pollStart).Round(time.Millisecond))
+					logger.Debug("waiting for next check", "wait", wait.Round(time.Second))
+				}
+				select {
+				case <-ctx.Done():
+					logger.Info("stopping watch")
+					return
+				case <-time.After(wait):
+				}
+			}
+		},
+	}
+)
+
+// minPollWait is the shortest wait between two checks, whatever the interval and jitter.
+const minPollWait = time.Second
+
+// nextPollWait returns the interval randomly shifted by up to jitter in either direction,
+// so that many watchers don't hit Substack at the same time. It is never shorter than minPollWait.
+func nextPollWait(interval time.Duration, jitter time.Duration) time.Duration {
+	wait := interval
+	if jitter > 0 {
+		wait += time.Duration(rand.Int63n(int64(2*jitter))) - jitter
+	}
+	return max(wait, minPollWait)
+}
+
+func init() {
+	watchCmd.Flags().StringVarP(&watchUrl, "url", "u", "", "Specify the Substack url")
+	watchCmd.Flags().StringVarP(&watchFile, "file", "i", "", "Specify the subscription list file")
+	watchCmd.Flags().StringVarP(&watchFormat, "format", "f", "html", "Specify the default output format (options: \"html\", \"md\", \"txt\"")
+	watchCmd.Flags().StringVarP(&watchOutputFolder, "output", "o", ".", "Specify the download directory")
+	watchCmd.Flags().DurationVar(&watchInterval, "interval", time.Hour, "Specify how often to check for new posts")
+	watchCmd.Flags().DurationVar(&watchJitter, "jitter", 5*time.Minute, "Specify the maximum random deviation from the interval")
+	addFilterFlags(watchCmd)
+	watchCmd.MarkFlagsOneRequired("url", "file")
+	watchCmd.MarkFlagsMutuallyExclusive("url", "file")
+}
@@ -0,0 +1,152 @@
+package lib
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"text/tabwriter"
+)
+
+// PostSummary holds the metadata of a post as listed in the archive of a publication.
+type PostSummary struct {
+	Id           int    `json:"id"`
+	Slug         string `json:"slug"`
+	Title        string `json:"title"`
+	PostDate     string `json:"post_date"`
+	Audience     string `json:"audience"`
+	Type         string `json:"type"`
+	WordCount    int    `json:"wordcount"`
+	CanonicalUrl string `json:"canonical_url"`
+
+	Bylines     []Byline  `json:"publishedBylines,omitempty"`
+	SectionName string    `json:"section_name,omitempty"`
+	SectionSlug string    `json:"section_slug,omitempty"`
+	Tags        []PostTag `json:"postTags,omitempty"`
+}
+
+// Byline is an author of a post.
+type Byline struct {
+	Name   string `json:"name"`
+	Handle string `json:"handle"`
+}
+
+// PostTag is a tag of a post.
+type PostTag struct {
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+// archivePageSize is the number of posts requested per page of the archive API.
+const archivePageSize = 50
+
+// GetArchive returns the metadata of all the posts of the publication at pubUrl, newest first,
+// paging through its archive API. If f is not nil, only the posts whose date complies with it are returned.
+func (e *Extractor) GetArchive(ctx context.Context, pubUrl string, f DateFilterFunc) ([]PostSummary, error) {
+	u, err := url.Parse(pubUrl)
+	if err != nil {
+		return nil, err
+	}
+	u.Path, err = url.JoinPath(u.Path, "api/v1/archive")
+	if err != nil {
+		return nil, err
+	}
+
+	e.allowCookie(pubUrl)
+	posts := []PostSummary{}
+	for offset := 0; ; offset += archivePageSize {
+		u.RawQuery = url.Values{
+			"sort":   {"new"},
+			"offset": {strconv.Itoa(offset)},
+			"limit":  {strconv.Itoa(archivePageSize)},
+		}.Encode()
+		page, err := e.fetchArchivePage(ctx, u.String())
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range page {
+			if f == nil || f(p.PostDate) {
+				posts = append(posts, p)
+			}
+		}
+		if len(page) < archivePageSize {
+			return posts, nil
+		}
+	}
+}
+
+// GetPostsURLs returns the urls of the posts of the publication at pubUrl matching filter.
+// Without any criteria, they are read from the sitemap of the publication;
+// otherwise its archive is fetched to match the metadata of every post, including its publication date.
+func (e *Extractor) GetPostsURLs(ctx context.Context, pubUrl string, filter PostFilter) ([]string, error) {
+	if filter.IsZero() {
+		return e.GetAllPostsURLs(ctx, pubUrl, nil)
+	}
+	posts, err := e.GetArchive(ctx, pubUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	urls := []string{}
+	for _, p := range filter.Apply(posts) {
+		urls = append(urls, p.CanonicalUrl)
+	}
+	return urls, nil
+}
+
+// fetchArchivePage fetches and decodes a single page of the archive API.
+func (e *Extractor) fetchArchivePage(ctx context.Context, pageUrl string) ([]PostSummary, error) {
+	body, err := e.fetcher.FetchURL(ctx, pageUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var page []PostSummary
+	if err := json.NewDecoder(body).Decode(&page); err != nil {
+		return nil, fmt.Errorf("failed to decode archive: %w", err)
+	}
+	return page, nil
+}
+
+// WritePostSummaries writes posts to w in the given format:
+// "json" (an indented array), "ndjson" (one object per line), "csv" (with a header row) or "table" (aligned columns).
+func WritePostSummaries(w io.Writer, posts []PostSummary, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(posts)
+	case "ndjson":
+		enc := json.NewEncoder(w)
+		for _, p := range posts {
+			if err := enc.Encode(p); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"id", "slug", "title", "post_date", "audience", "type", "wordcount", "canonical_url"})
+		for _, p := range posts {
+			cw.Write([]string{strconv.Itoa(p.Id), p.Slug, p.Title, p.PostDate, p.Audience, p.Type, strconv.Itoa(p.WordCount), p.CanonicalUrl})
+		}
+		cw.Flush()
+		return cw.Error()
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tDATE\tTYPE\tAUDIENCE\tWORDS\tSLUG\tTITLE")
+		for _, p := range posts {
+			date := p.PostDate
+			if len(date) > len("2006-01-02") {
+				date = date[:len("2006-01-02")]
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%d\t%s\t%s\n", p.Id, date, p.Type, p.Audience, p.WordCount, p.Slug, p.Title)
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown output format: %s", format)
+	}
+}
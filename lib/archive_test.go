@@ -0,0 +1,135 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestWritePostSummaries(t *testing.T) {
+	posts := []PostSummary{
+		{Id: 1, Slug: "first", Title: `First, "quoted"`, PostDate: "2024-01-02T10:00:00.000Z", Audience: "everyone", Type: "newsletter", WordCount: 1200, CanonicalUrl: "https://a.substack.com/p/first"},
+		{Id: 22, Slug: "ep", Title: "Episode", PostDate: "2024-02", Audience: "only_paid", Type: "podcast", WordCount: 5, CanonicalUrl: "https://a.substack.com/p/ep"},
+	}
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"ndjson", `{"id":1,"slug":"first","title":"First, \"quoted\"","post_date":"2024-01-02T10:00:00.000Z","audience":"everyone","type":"newsletter","wordcount":1200,"canonical_url":"https://a.substack.com/p/first"}` + "\n" +
+			`{"id":22,"slug":"ep","title":"Episode","post_date":"2024-02","audience":"only_paid","type":"podcast","wordcount":5,"canonical_url":"https://a.substack.com/p/ep"}` + "\n"},
+		{"csv", "id,slug,title,post_date,audience,type,wordcount,canonical_url\n" +
+			`1,first,"First, ""quoted""",2024-01-02T10:00:00.000Z,everyone,newsletter,1200,https://a.substack.com/p/first` + "\n" +
+			"22,ep,Episode,2024-02,only_paid,podcast,5,https://a.substack.com/p/ep\n"},
+		{"table", "ID  DATE        TYPE        AUDIENCE   WORDS  SLUG   TITLE\n" +
+			"1   2024-01-02  newsletter  everyone   1200   first  First, \"quoted\"\n" +
+			"22  2024-02     podcast     only_paid  5      ep     Episode\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var sb strings.Builder
+			if err := WritePostSummaries(&sb, posts, tt.format); err != nil {
+				t.Fatal(err)
+			}
+			if got := sb.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("json", func(t *testing.T) {
+		var sb strings.Builder
+		if err := WritePostSummaries(&sb, posts, "json"); err != nil {
+			t.Fatal(err)
+		}
+		var decoded []PostSummary
+		if err := json.Unmarshal([]byte(sb.String()), &decoded); err != nil || !reflect.DeepEqual(decoded, posts) {
+			t.Errorf("got %s, %v, want the posts", sb.String(), err)
+		}
+		if !strings.HasPrefix(sb.String(), "[\n  {") {
+			t.Errorf("got %q, want indented json", sb.String())
+		}
+	})
+
+	if err := WritePostSummaries(&strings.Builder{}, posts, "xml"); err == nil {
+		t.Errorf("got no error for an unknown format")
+	}
+}
+
+// newArchiveServer serves an archive API of n posts, newest first, numbered from n down to 1 and published a day apart
+// from 2024-01-01, odd ones being podcasts. It records the offsets requested.
+func newArchiveServer(t *testing.T, n int, offsets *[]int) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/archive" || r.URL.Query().Get("sort") != "new" {
+			http.NotFound(w, r)
+			return
+		}
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		*offsets = append(*offsets, offset)
+		page := []PostSummary{}
+		for i := n - offset; i > 0 && i > n-offset-limit; i-- {
+			typ := PostTypeNewsletter
+			if i%2 == 1 {
+				typ = PostTypePodcast
+			}
+			page = append(page, PostSummary{
+				Id:           i,
+				Slug:         fmt.Sprintf("post-%d", i),
+				Type:         typ,
+				PostDate:     fmt.Sprintf("2024-%02d-%02dT10:00:00.000Z", 1+(i-1)/28, 1+(i-1)%28),
+				CanonicalUrl: fmt.Sprintf("http://%s/p/post-%d", r.Host, i),
+			})
+		}
+		json.NewEncoder(w).Encode(page)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestGetArchive(t *testing.T) {
+	var offsets []int
+	srv := newArchiveServer(t, archivePageSize+10, &offsets)
+	e := newTestExtractor(t, filepath.Join(t.TempDir(), "downloaded_posts.log"))
+
+	posts, err := e.GetArchive(context.Background(), srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != archivePageSize+10 || posts[0].Id != archivePageSize+10 || posts[len(posts)-1].Id != 1 {
+		t.Errorf("got %d posts, want %d, newest first", len(posts), archivePageSize+10)
+	}
+	if want := []int{0, archivePageSize}; !reflect.DeepEqual(offsets, want) {
+		t.Errorf("got offsets %v, want %v", offsets, want)
+	}
+
+	// the date filter applies to the publication date of the posts
+	posts, err = e.GetArchive(context.Background(), srv.URL, func(date string) bool { return date < "2024-01-03" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 2 || posts[0].Id != 2 || posts[1].Id != 1 {
+		t.Errorf("got %+v, want posts 2 and 1", posts)
+	}
+}
+
+func TestGetPostsURLs(t *testing.T) {
+	var offsets []int
+	srv := newArchiveServer(t, 6, &offsets)
+	e := newTestExtractor(t, filepath.Join(t.TempDir(), "downloaded_posts.log"))
+
+	got, err := e.GetPostsURLs(context.Background(), srv.URL, PostFilter{Types: []string{PostTypePodcast}, Oldest: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{srv.URL + "/p/post-1", srv.URL + "/p/post-3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,183 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// fileEmbedSelector matches the file attachment widgets of a post body.
+const fileEmbedSelector = ".file-embed-wrapper"
+
+// downloadAttachments downloads the files attached to the post through file embeds into postFolder,
+// or the MediaStore s if not nil, and replaces each embed widget with a plain link to the local file.
+// It returns the downloaded files and the errors of the attachments that couldn't be downloaded, keyed by URL.
+// Widgets whose attachment couldn't be downloaded are left untouched.
+func downloadAttachments(ctx context.Context, f *Fetcher, s *MediaStore, p *Post, postFolder string) (map[string]MediaFile, MediaErrors) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(p.BodyHTML))
+	if err != nil {
+		return nil, MediaErrors{p.CanonicalUrl: err}
+	}
+
+	files := make(map[string]MediaFile)
+	attachmentErrs := make(MediaErrors)
+	usedNames := make(map[string]struct{})
+	names := make(map[string]string)
+	doc.Find(fileEmbedSelector).Each(func(i int, sel *goquery.Selection) {
+		fileUrl, ok := sel.Find("a.file-embed-button[href]").Attr("href")
+		if !ok || fileUrl == "" {
+			return
+		}
+		title := strings.TrimSpace(sel.Find(".file-embed-details-h1").First().Text())
+		details := strings.TrimSpace(sel.Find(".file-embed-details-h2").First().Text())
+
+		file, ok := files[fileUrl]
+		if !ok {
+			if _, failed := attachmentErrs[fileUrl]; failed {
+				return
+			}
+			file, err = s.save(fileUrl, postFolder, func(folder string) (MediaFile, error) {
+				downloaded, err := downloadAttachment(ctx, f, fileUrl, title, folder, usedNames)
+				names[fileUrl] = downloaded.Path
+				return downloaded, err
+			})
+			if err != nil {
+				attachmentErrs[fileUrl] = err
+				return
+			}
+			files[fileUrl] = file
+		}
+
+		if title == "" && s != nil {
+			// files in the store are named after their hash, so the link is labelled with the original name
+			title = names[fileUrl]
+			if title == "" {
+				title = attachmentFileName(fileUrl, "", "", file.MimeType)
+			}
+		}
+		sel.ReplaceWithHtml(attachmentLinkHTML(file.Path, title, details))
+	})
+
+	if len(files) > 0 {
+		body, err := doc.Find("body").Html()
+		if err != nil {
+			attachmentErrs[p.CanonicalUrl] = err
+		} else {
+			p.BodyHTML = body
+		}
+	}
+
+	if len(attachmentErrs) == 0 {
+		return files, nil
+	}
+	return files, attachmentErrs
+}
+
+// downloadAttachment atomically downloads the attachment at fileUrl into outputFolder, under its original file name:
+// the one given by the server in Content-Disposition, falling back to the title of the embed and the name in the URL.
+// Names already in usedNames get a numeric suffix, so two attachments never overwrite each other.
+func downloadAttachment(ctx context.Context, f *Fetcher, fileUrl string, title string, outputFolder string, usedNames map[string]struct{}) (MediaFile, error) {
+	body, header, err := f.FetchURLWithHeader(ctx, fileUrl)
+	if err != nil {
+		return MediaFile{}, fmt.Errorf("failed to download attachment: %w", err)
+	}
+	defer body.Close()
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(body, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return MediaFile{}, fmt.Errorf("failed to download attachment: %w", err)
+	}
+	head = head[:n]
+	mimeType := detectMediaType(header.Get("Content-Type"), head)
+
+	fileName := uniqueFileName(attachmentFileName(fileUrl, title, header.Get("Content-Disposition"), mimeType), usedNames)
+	err = WriteFileAtomic(filepath.Join(outputFolder, fileName), func(w io.Writer) error {
+		if _, err := w.Write(head); err != nil {
+			return err
+		}
+		_, err := io.Copy(w, body)
+		return err
+	})
+	if err != nil {
+		return MediaFile{}, fmt.Errorf("failed to save attachment: %w", err)
+	}
+	return MediaFile{Path: fileName, MimeType: mimeType}, nil
+}
+
+// attachmentFileName returns the name to save the attachment at fileUrl as.
+// Unlike media files, attachments keep their original extension (e.g. .docx rather than the .zip it is detected as),
+// which is only derived from mimeType when the name has none.
+func attachmentFileName(fileUrl string, title string, contentDisposition string, mimeType string) string {
+	urlName := ""
+	if u, err := url.Parse(fileUrl); err == nil {
+		urlName = path.Base(u.Path)
+		if unescaped, err := url.PathUnescape(urlName); err == nil {
+			urlName = unescaped
+		}
+		if urlName == "." || urlName == "/" {
+			urlName = ""
+		}
+	}
+
+	name := ""
+	if _, params, err := mime.ParseMediaType(contentDisposition); err == nil {
+		name = path.Base(strings.ReplaceAll(params["filename"], `\`, "/"))
+	}
+	if name == "" || name == "." || name == "/" {
+		name = title
+		if name != "" && path.Ext(name) == "" {
+			name += path.Ext(urlName)
+		}
+	}
+	if name == "" {
+		name = urlName
+	}
+
+	name = sanitizeFileName(name)
+	ext := path.Ext(name)
+	if ext == "" {
+		ext = extensionForType(mimeType)
+	}
+	base := strings.TrimSuffix(name, path.Ext(name))
+	if base == "" {
+		base = "attachment"
+	}
+	return base + ext
+}
+
+// uniqueFileName returns name, or name with a numeric suffix if it is already in usedNames, and marks it as used.
+func uniqueFileName(name string, usedNames map[string]struct{}) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	unique := name
+	for i := 2; ; i++ {
+		if _, used := usedNames[strings.ToLower(unique)]; !used {
+			break
+		}
+		unique = base + "-" + strconv.Itoa(i) + ext
+	}
+	usedNames[strings.ToLower(unique)] = struct{}{}
+	return unique
+}
+
+// attachmentLinkHTML returns the HTML of a plain link to the attachment saved as filePath.
+func attachmentLinkHTML(filePath string, title string, details string) string {
+	if title == "" {
+		title = filePath
+	}
+	link := fmt.Sprintf("<a href=\"%s\">%s</a>", html.EscapeString(localURL(filePath)), html.EscapeString(title))
+	if details != "" {
+		link += " (" + html.EscapeString(details) + ")"
+	}
+	return "<p class=\"file-attachment\">Attachment: " + link + "</p>"
+}
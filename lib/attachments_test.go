@@ -0,0 +1,163 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAttachmentFileName(t *testing.T) {
+	tests := []struct {
+		name               string
+		fileUrl            string
+		title              string
+		contentDisposition string
+		mimeType           string
+		want               string
+	}{
+		{"content disposition", "https://example.com/api/v1/file/abc.bin", "Report", `attachment; filename="Q1 report.docx"`, "application/zip", "Q1 report.docx"},
+		{"content disposition with path", "https://example.com/f", "", `attachment; filename="..\\..\\evil.pdf"`, "application/pdf", "evil.pdf"},
+		{"utf-8 content disposition", "https://example.com/f", "", `attachment; filename*=UTF-8''r%C3%A9sum%C3%A9.pdf`, "application/pdf", "résumé.pdf"},
+		{"title with the extension of the url", "https://example.com/api/v1/file/abc-123.pdf", "My Report", "", "application/pdf", "My Report.pdf"},
+		{"title with an extension", "https://example.com/api/v1/file/abc-123.pdf", "notes.txt", "", "application/pdf", "notes.txt"},
+		{"title with the extension of the type", "https://example.com/api/v1/file/abc", "Slides", "", "application/pdf", "Slides.pdf"},
+		{"escaped url name", "https://example.com/files/my%20data.csv", "", "", "text/csv", "my data.csv"},
+		{"sanitized title", "https://example.com/f.pdf", `a/b:c?`, "", "application/pdf", "a_b_c_.pdf"},
+		{"no name", "https://example.com/", "", "", "", "attachment"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := attachmentFileName(tt.fileUrl, tt.title, tt.contentDisposition, tt.mimeType); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUniqueFileName(t *testing.T) {
+	used := make(map[string]struct{})
+	names := []struct {
+		name string
+		want string
+	}{
+		{"report.pdf", "report.pdf"},
+		{"Report.PDF", "Report-2.PDF"},
+		{"report.pdf", "report-3.pdf"},
+		{"report-2.pdf", "report-2-2.pdf"},
+		{"notes", "notes"},
+		{"notes", "notes-2"},
+	}
+	for _, n := range names {
+		if got := uniqueFileName(n.name, used); got != n.want {
+			t.Errorf("uniqueFileName(%q) = %q, want %q", n.name, got, n.want)
+		}
+	}
+}
+
+func TestAttachmentLinkHTML(t *testing.T) {
+	tests := []struct {
+		path, title, details string
+		want                 string
+	}{
+		{"My Report.pdf", "My <Report>", "PDF, 2 MB",
+			`<p class="file-attachment">Attachment: <a href="My%20Report.pdf">My &lt;Report&gt;</a> (PDF, 2 MB)</p>`},
+		{"data.csv", "", "",
+			`<p class="file-attachment">Attachment: <a href="data.csv">data.csv</a></p>`},
+	}
+	for _, tt := range tests {
+		if got := attachmentLinkHTML(tt.path, tt.title, tt.details); got != tt.want {
+			t.Errorf("attachmentLinkHTML(%q, %q, %q) = %s, want %s", tt.path, tt.title, tt.details, got, tt.want)
+		}
+	}
+}
+
+// fileEmbedHTML returns the HTML of the attachment widget of the file at fileUrl.
+func fileEmbedHTML(fileUrl string, title string) string {
+	return fmt.Sprintf(`<div class="file-embed-wrapper"><div class="file-embed-container">`+
+		`<img class="file-embed-thumbnail" src="thumb.png"/>`+
+		`<div class="file-embed-details"><div class="file-embed-details-h1">%s</div><div class="file-embed-details-h2">PDF</div></div>`+
+		`<a class="file-embed-button" href="%s">Download</a></div></div>`, title, fileUrl)
+}
+
+func TestDownloadAttachments(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/file/a.pdf":
+			fmt.Fprint(w, "%PDF-1.7 a")
+		case "/file/b":
+			w.Header().Set("Content-Disposition", `attachment; filename="data.csv"`)
+			fmt.Fprint(w, "x,y\n1,2\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	f := NewFetcher(WithRatePerSecond(1000))
+
+	body := fileEmbedHTML(srv.URL+"/file/a.pdf", "Report") +
+		fileEmbedHTML(srv.URL+"/file/b", "") +
+		fileEmbedHTML(srv.URL+"/file/a.pdf", "Report again") +
+		fileEmbedHTML(srv.URL+"/file/missing.pdf", "Missing")
+
+	t.Run("post folder", func(t *testing.T) {
+		dir := t.TempDir()
+		p := Post{BodyHTML: body, CanonicalUrl: srv.URL + "/p/post"}
+		files, errs := downloadAttachments(context.Background(), f, nil, &p, dir)
+
+		if len(errs) != 1 || errs[srv.URL+"/file/missing.pdf"] == nil {
+			t.Errorf("got errors %v, want one for the missing file", errs)
+		}
+		want := map[string]MediaFile{
+			srv.URL + "/file/a.pdf": {Path: "Report.pdf", MimeType: "application/pdf"},
+			srv.URL + "/file/b":     {Path: "data.csv", MimeType: "text/plain"},
+		}
+		for u, w := range want {
+			if got := files[u]; got.Path != w.Path {
+				t.Errorf("%s: got %+v, want %+v", u, got, w)
+			}
+			if _, err := os.Stat(filepath.Join(dir, w.Path)); err != nil {
+				t.Errorf("%s not saved: %v", w.Path, err)
+			}
+		}
+		for _, link := range []string{
+			`Attachment: <a href="Report.pdf">Report</a> (PDF)`,
+			`Attachment: <a href="data.csv">data.csv</a> (PDF)`,
+			`Attachment: <a href="Report.pdf">Report again</a> (PDF)`,
+		} {
+			if !strings.Contains(p.BodyHTML, link) {
+				t.Errorf("body %s doesn't contain %s", p.BodyHTML, link)
+			}
+		}
+		if strings.Count(p.BodyHTML, "file-embed-wrapper") != 1 || strings.Count(p.BodyHTML, "thumb.png") != 1 {
+			t.Errorf("got body %s, want only the widget of the missing file left", p.BodyHTML)
+		}
+	})
+
+	t.Run("media store", func(t *testing.T) {
+		dir := t.TempDir()
+		manifest, err := LoadManifest(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		postFolder := filepath.Join(dir, "post")
+		p := Post{BodyHTML: body, CanonicalUrl: srv.URL + "/p/post"}
+		files, _ := downloadAttachments(context.Background(), f, NewMediaStore(manifest), &p, postFolder)
+
+		file := files[srv.URL+"/file/b"]
+		if !strings.HasPrefix(file.Path, "../"+MediaStoreFolder+"/") || !strings.HasSuffix(file.Path, ".csv") {
+			t.Errorf("got %+v, want a csv file in the media store", file)
+		}
+		if _, err := os.Stat(filepath.Join(postFolder, file.Path)); err != nil {
+			t.Errorf("%s not saved: %v", file.Path, err)
+		}
+		// files in the store are named after their hash, so the link keeps the original name
+		if link := `>data.csv</a>`; !strings.Contains(p.BodyHTML, link) {
+			t.Errorf("body %s doesn't contain %s", p.BodyHTML, link)
+		}
+	})
+}
@@ -0,0 +1,195 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CommentsFileName is the name of the file the comments of a post are saved to, in the folder of the post.
+const CommentsFileName = "comments.json"
+
+// Comment is a comment on a post, with its replies.
+type Comment struct {
+	Id            int       `json:"id"`
+	Body          string    `json:"body"`
+	Date          string    `json:"date"`
+	EditedAt      string    `json:"edited_at,omitempty"`
+	Name          string    `json:"name"`
+	Handle        string    `json:"handle,omitempty"`
+	UserId        int       `json:"user_id"`
+	ReactionCount int       `json:"reaction_count"`
+	Deleted       bool      `json:"deleted,omitempty"`
+	Children      []Comment `json:"children,omitempty"`
+}
+
+// commentsResponse is the response of the comments API.
+type commentsResponse struct {
+	Comments []Comment `json:"comments"`
+}
+
+// commentsURL returns the URL of the comments API for the post, listing all its comments threaded, oldest first.
+func (p *Post) commentsURL() (string, error) {
+	u, err := url.Parse(p.CanonicalUrl)
+	if err != nil {
+		return "", err
+	}
+	apiUrl := url.URL{
+		Scheme: u.Scheme,
+		Host:   u.Host,
+		Path:   "/api/v1/post/" + strconv.Itoa(p.Id) + "/comments",
+		RawQuery: url.Values{
+			"all_comments": {"true"},
+			"sort":         {"oldest_first"},
+		}.Encode(),
+	}
+	return apiUrl.String(), nil
+}
+
+// downloadComments fetches the comments of the post with their replies and saves them to CommentsFileName in postFolder.
+func downloadComments(ctx context.Context, f *Fetcher, commentsUrl string, postFolder string) ([]Comment, error) {
+	body, err := f.FetchURL(ctx, commentsUrl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download comments: %w", err)
+	}
+	defer body.Close()
+
+	var resp commentsResponse
+	if err := json.NewDecoder(body).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to decode comments: %w", err)
+	}
+	if resp.Comments == nil {
+		resp.Comments = []Comment{}
+	}
+
+	data, err := json.MarshalIndent(resp.Comments, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	err = WriteFileAtomic(filepath.Join(postFolder, CommentsFileName), func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save comments: %w", err)
+	}
+	return resp.Comments, nil
+}
+
+// CountComments returns the number of comments, including all the replies.
+func CountComments(comments []Comment) int {
+	n := len(comments)
+	for _, c := range comments {
+		n += CountComments(c.Children)
+	}
+	return n
+}
+
+// meta returns the line introducing the comment: its author, date and number of likes.
+func (c Comment) meta() (author string, details string) {
+	author = c.Name
+	if c.Deleted || author == "" {
+		author = "[deleted]"
+	}
+	fields := []string{formatCommentDate(c.Date)}
+	if c.EditedAt != "" {
+		fields = append(fields, "edited")
+	}
+	switch c.ReactionCount {
+	case 0:
+	case 1:
+		fields = append(fields, "1 like")
+	default:
+		fields = append(fields, strconv.Itoa(c.ReactionCount)+" likes")
+	}
+	return author, strings.Join(fields, " · ")
+}
+
+// paragraphs returns the paragraphs of the comment body, which is plain text.
+func (c Comment) paragraphs() []string {
+	var paragraphs []string
+	for _, para := range strings.Split(strings.ReplaceAll(c.Body, "\r\n", "\n"), "\n\n") {
+		if para = strings.TrimSpace(para); para != "" {
+			paragraphs = append(paragraphs, para)
+		}
+	}
+	return paragraphs
+}
+
+// bodyHTML returns the comment body as HTML paragraphs.
+func (c Comment) bodyHTML() string {
+	var sb strings.Builder
+	for _, para := range c.paragraphs() {
+		sb.WriteString("<p>" + strings.ReplaceAll(html.EscapeString(para), "\n", "<br>") + "</p>\n")
+	}
+	return sb.String()
+}
+
+// formatCommentDate formats the RFC 3339 date of a comment as a UTC date and time, or returns it as-is if it can't be parsed.
+func formatCommentDate(date string) string {
+	t, err := time.Parse(time.RFC3339, date)
+	if err != nil {
+		return date
+	}
+	return t.UTC().Format("2006-01-02 15:04 UTC")
+}
+
+// commentsHTML returns the HTML section rendering the comments as a thread, replies being nested in block quotes.
+func commentsHTML(comments []Comment) string {
+	if len(comments) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("\n<div class=\"comments\">\n<h2>Comments</h2>\n")
+	writeCommentsHTML(&sb, comments)
+	sb.WriteString("</div>\n")
+	return sb.String()
+}
+
+// writeCommentsHTML writes the HTML of comments and their replies to sb.
+func writeCommentsHTML(sb *strings.Builder, comments []Comment) {
+	for _, c := range comments {
+		author, details := c.meta()
+		fmt.Fprintf(sb, "<div class=\"comment\" id=\"comment-%d\">\n", c.Id)
+		fmt.Fprintf(sb, "<p class=\"comment-meta\"><strong>%s</strong> · %s</p>\n", html.EscapeString(author), html.EscapeString(details))
+		sb.WriteString(c.bodyHTML())
+		if len(c.Children) > 0 {
+			sb.WriteString("<blockquote class=\"comment-replies\">\n")
+			writeCommentsHTML(sb, c.Children)
+			sb.WriteString("</blockquote>\n")
+		}
+		sb.WriteString("</div>\n")
+	}
+}
+
+// commentsText returns the comments as plain text, replies being indented under the comment they answer.
+func commentsText(comments []Comment) string {
+	if len(comments) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("\n\nComments\n")
+	writeCommentsText(&sb, comments, "")
+	return sb.String()
+}
+
+// writeCommentsText writes comments and their replies to sb, each line prefixed with indent.
+func writeCommentsText(sb *strings.Builder, comments []Comment, indent string) {
+	for _, c := range comments {
+		author, details := c.meta()
+		sb.WriteString("\n" + indent + author + " · " + details + "\n")
+		for _, para := range c.paragraphs() {
+			for _, line := range strings.Split(para, "\n") {
+				sb.WriteString(indent + line + "\n")
+			}
+		}
+		writeCommentsText(sb, c.Children, indent+"    ")
+	}
+}
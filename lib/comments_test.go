@@ -0,0 +1,148 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testComments is a thread of two comments, the first one with a reply.
+var testComments = []Comment{
+	{Id: 1, Name: "Jane", Body: "First <b>line</b>\nsecond line\r\n\r\nNext paragraph", Date: "2024-01-02T10:30:00.000Z", ReactionCount: 2,
+		Children: []Comment{{Id: 2, Name: "John", Body: "Reply", Date: "2024-01-02T12:00:00+02:00", EditedAt: "2024-01-03T00:00:00Z", ReactionCount: 1}}},
+	{Id: 3, Deleted: true, Body: "", Date: "not a date"},
+}
+
+func TestCommentsURL(t *testing.T) {
+	p := Post{Id: 42, CanonicalUrl: "https://example.substack.com/p/post?utm_source=x"}
+	got, err := p.commentsURL()
+	if want := "https://example.substack.com/api/v1/post/42/comments?all_comments=true&sort=oldest_first"; err != nil || got != want {
+		t.Errorf("got %q, %v, want %q", got, err, want)
+	}
+}
+
+func TestCountComments(t *testing.T) {
+	if got := CountComments(testComments); got != 3 {
+		t.Errorf("got %d, want 3", got)
+	}
+	if got := CountComments(nil); got != 0 {
+		t.Errorf("got %d for no comments, want 0", got)
+	}
+}
+
+func TestCommentMeta(t *testing.T) {
+	tests := []struct {
+		comment Comment
+		author  string
+		details string
+	}{
+		{testComments[0], "Jane", "2024-01-02 10:30 UTC · 2 likes"},
+		{testComments[0].Children[0], "John", "2024-01-02 10:00 UTC · edited · 1 like"},
+		{testComments[1], "[deleted]", "not a date"},
+		{Comment{Name: "Jane", Deleted: true}, "[deleted]", ""},
+	}
+	for _, tt := range tests {
+		author, details := tt.comment.meta()
+		if author != tt.author || details != tt.details {
+			t.Errorf("meta() of comment %d = %q, %q, want %q, %q", tt.comment.Id, author, details, tt.author, tt.details)
+		}
+	}
+}
+
+func TestCommentParagraphs(t *testing.T) {
+	want := []string{"First <b>line</b>\nsecond line", "Next paragraph"}
+	if got := testComments[0].paragraphs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := testComments[1].paragraphs(); got != nil {
+		t.Errorf("got %q for an empty body, want none", got)
+	}
+}
+
+func TestCommentsHTML(t *testing.T) {
+	got := commentsHTML(testComments)
+	for _, want := range []string{
+		`<h2>Comments</h2>`,
+		`<div class="comment" id="comment-1">`,
+		`<p class="comment-meta"><strong>Jane</strong> · 2024-01-02 10:30 UTC · 2 likes</p>`,
+		`<p>First &lt;b&gt;line&lt;/b&gt;<br>second line</p>` + "\n" + `<p>Next paragraph</p>`,
+		`<blockquote class="comment-replies">` + "\n" + `<div class="comment" id="comment-2">`,
+		`<strong>[deleted]</strong>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("got %s, want it to contain %s", got, want)
+		}
+	}
+	if got := commentsHTML(nil); got != "" {
+		t.Errorf("got %q for no comments, want nothing", got)
+	}
+}
+
+func TestCommentsText(t *testing.T) {
+	want := "\n\nComments\n" +
+		"\nJane · 2024-01-02 10:30 UTC · 2 likes\nFirst <b>line</b>\nsecond line\nNext paragraph\n" +
+		"\n    John · 2024-01-02 10:00 UTC · edited · 1 like\n    Reply\n" +
+		"\n[deleted] · not a date\n"
+	if got := commentsText(testComments); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := commentsText(nil); got != "" {
+		t.Errorf("got %q for no comments, want nothing", got)
+	}
+}
+
+func TestDownloadComments(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/post/1/comments":
+			json.NewEncoder(w).Encode(commentsResponse{Comments: testComments})
+		case "/api/v1/post/2/comments":
+			fmt.Fprint(w, `{"comments":null}`)
+		default:
+			fmt.Fprint(w, "<html>")
+		}
+	}))
+	defer srv.Close()
+	f := NewFetcher(WithRatePerSecond(1000))
+
+	tests := []struct {
+		name    string
+		path    string
+		want    []Comment
+		wantErr bool
+	}{
+		{"comments", "/api/v1/post/1/comments", testComments, false},
+		{"no comments", "/api/v1/post/2/comments", []Comment{}, false},
+		{"invalid response", "/api/v1/post/3/comments", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			got, err := downloadComments(context.Background(), f, srv.URL+tt.path, dir)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			data, err := os.ReadFile(filepath.Join(dir, CommentsFileName))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("comments saved despite the error")
+				}
+				return
+			}
+			var saved []Comment
+			if err != nil || json.Unmarshal(data, &saved) != nil || !reflect.DeepEqual(saved, tt.want) {
+				t.Errorf("got saved comments %s, %v, want %+v", data, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package lib
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LastRun is the date expression referring to the time of the previous run.
+const LastRun = "last-run"
+
+// dateLayout is the layout of dates without a time.
+const dateLayout = "2006-01-02"
+
+// DateFilter selects posts by publication date: it keeps the posts published at or after After, and before Before.
+// A zero bound leaves that side open.
+type DateFilter struct {
+	After  time.Time
+	Before time.Time
+}
+
+// DateContext holds what date expressions are resolved against.
+type DateContext struct {
+	// Now is the time relative expressions count back from.
+	Now time.Time
+	// LastRun is the time of the previous run, which LastRun refers to. It is zero if there was none,
+	// in which case LastRun leaves its side of the filter open.
+	LastRun time.Time
+	// Location is the time zone of dates without a time. It defaults to the local time zone.
+	Location *time.Location
+}
+
+// ParseDateFilter returns the filter keeping the posts published after the after expression and before the before one.
+// An empty expression leaves its side open. Each expression is one of:
+//   - a date (2006-01-02), standing for that whole day in dc.Location;
+//   - an RFC 3339 timestamp (2006-01-02T15:04:05Z07:00);
+//   - a period back from dc.Now, in days (30d), weeks (2w) or any unit understood by time.ParseDuration (12h);
+//   - LastRun, the time of the previous run, or no bound if there was none.
+//
+// Bounds are exclusive unless inclusive is set: after 2024-01-01 starts on January 2nd, and before 2024-01-31
+// ends on January 30th, while with inclusive bounds both days are kept.
+func ParseDateFilter(after string, before string, inclusive bool, dc DateContext) (DateFilter, error) {
+	var f DateFilter
+	if after != "" {
+		t, wholeDay, err := parseDateExpr(after, dc)
+		if err != nil {
+			return DateFilter{}, err
+		}
+		// the filter keeps the posts at or after f.After
+		if !inclusive && !t.IsZero() {
+			t = boundEnd(t, wholeDay)
+		}
+		f.After = t
+	}
+	if before != "" {
+		t, wholeDay, err := parseDateExpr(before, dc)
+		if err != nil {
+			return DateFilter{}, err
+		}
+		// the filter keeps the posts strictly before f.Before
+		if inclusive && !t.IsZero() {
+			t = boundEnd(t, wholeDay)
+		}
+		f.Before = t
+	}
+	if !f.After.IsZero() && !f.Before.IsZero() && !f.After.Before(f.Before) {
+		return DateFilter{}, fmt.Errorf("the after date (%s) must be earlier than the before date (%s)", after, before)
+	}
+	return f, nil
+}
+
+// boundEnd returns the first instant after t: the next day for a whole day, the next nanosecond otherwise.
+func boundEnd(t time.Time, wholeDay bool) time.Time {
+	if wholeDay {
+		return t.AddDate(0, 0, 1)
+	}
+	return t.Add(time.Nanosecond)
+}
+
+// parseDateExpr returns the instant the date expression refers to (see ParseDateFilter),
+// and whether it stands for a whole day starting at that instant.
+// It is the zero time for LastRun without a previous run.
+func parseDateExpr(expr string, dc DateContext) (time.Time, bool, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == LastRun {
+		return dc.LastRun, false, nil
+	}
+
+	loc := dc.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	if t, err := time.ParseInLocation(dateLayout, expr, loc); err == nil {
+		return t, true, nil
+	}
+	if t, err := time.Parse(time.RFC3339, expr); err == nil {
+		return t, false, nil
+	}
+	if d, err := parsePeriod(expr); err == nil {
+		now := dc.Now
+		if now.IsZero() {
+			now = time.Now()
+		}
+		return now.Add(-d), false, nil
+	}
+	return time.Time{}, false, fmt.Errorf("invalid date: %q (expected YYYY-MM-DD, an RFC 3339 timestamp, a period such as 30d, or %s)", expr, LastRun)
+}
+
+// parsePeriod parses a positive period in days (30d), weeks (2w) or any unit understood by time.ParseDuration.
+func parsePeriod(s string) (time.Duration, error) {
+	var d time.Duration
+	if strings.HasSuffix(s, "d") || strings.HasSuffix(s, "w") {
+		n, err := strconv.Atoi(s[:len(s)-1])
+		if err != nil {
+			return 0, err
+		}
+		unit := 24 * time.Hour
+		if strings.HasSuffix(s, "w") {
+			unit *= 7
+		}
+		d = time.Duration(n) * unit
+	} else {
+		var err error
+		if d, err = time.ParseDuration(s); err != nil {
+			return 0, err
+		}
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("period must be positive: %s", s)
+	}
+	return d, nil
+}
+
+// IsZero reports whether the filter keeps posts of any date.
+func (f DateFilter) IsZero() bool {
+	return f.After.IsZero() && f.Before.IsZero()
+}
+
+// Match reports whether a post published at t is kept by the filter.
+func (f DateFilter) Match(t time.Time) bool {
+	return (f.After.IsZero() || !t.Before(f.After)) && (f.Before.IsZero() || t.Before(f.Before))
+}
+
+// MatchDate reports whether a post published at date, an RFC 3339 timestamp or a date in UTC, is kept by the filter.
+// Dates that can't be parsed are only kept by a zero filter.
+func (f DateFilter) MatchDate(date string) bool {
+	if f.IsZero() {
+		return true
+	}
+	t, err := time.Parse(time.RFC3339, date)
+	if err != nil {
+		if t, err = time.Parse(dateLayout, date); err != nil {
+			return false
+		}
+	}
+	return f.Match(t)
+}
+
+// Func returns the filter as a DateFilterFunc, or nil if it is zero.
+func (f DateFilter) Func() DateFilterFunc {
+	if f.IsZero() {
+		return nil
+	}
+	return f.MatchDate
+}
+
+// MakeDateFilterFunc creates a date filter function keeping the dates strictly between afterDate and beforeDate,
+// given in any of the forms understood by ParseDateFilter. It returns an error if a date is invalid.
+// The function is nil if both dates are empty.
+//
+// Deprecated: use ParseDateFilter, which also supports inclusive bounds.
+func MakeDateFilterFunc(beforeDate string, afterDate string) (DateFilterFunc, error) {
+	f, err := ParseDateFilter(afterDate, beforeDate, false, DateContext{})
+	if err != nil {
+		return nil, err
+	}
+	return f.Func(), nil
+}
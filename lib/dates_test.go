@@ -0,0 +1,154 @@
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDateFilter(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skip("no time zone database")
+	}
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	lastRun := time.Date(2024, 6, 1, 8, 30, 0, 0, time.UTC)
+	dc := DateContext{Now: now, LastRun: lastRun, Location: time.UTC}
+	day := func(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, 0, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name      string
+		after     string
+		before    string
+		inclusive bool
+		dc        DateContext
+		want      DateFilter
+	}{
+		{"empty", "", "", false, dc, DateFilter{}},
+		{"exclusive dates", "2024-01-01", "2024-01-31", false, dc, DateFilter{After: day(2024, 1, 2), Before: day(2024, 1, 31)}},
+		{"inclusive dates", "2024-01-01", "2024-01-31", true, dc, DateFilter{After: day(2024, 1, 1), Before: day(2024, 2, 1)}},
+		{"timestamps", "2024-01-01T10:00:00Z", "2024-01-02T10:00:00+02:00", false, dc, DateFilter{
+			After:  time.Date(2024, 1, 1, 10, 0, 0, 1, time.UTC),
+			Before: time.Date(2024, 1, 2, 8, 0, 0, 0, time.UTC),
+		}},
+		{"periods", "30d", "12h", true, dc, DateFilter{After: now.AddDate(0, 0, -30), Before: now.Add(-12*time.Hour + time.Nanosecond)}},
+		{"weeks", "2w", "", false, dc, DateFilter{After: now.AddDate(0, 0, -14).Add(time.Nanosecond)}},
+		{"last run", LastRun, "", false, dc, DateFilter{After: lastRun.Add(time.Nanosecond)}},
+		{"no last run", LastRun, " 2024-01-01 ", false, DateContext{Location: time.UTC}, DateFilter{Before: day(2024, 1, 1)}},
+		{"location", "2024-01-01", "", true, DateContext{Location: paris}, DateFilter{After: time.Date(2024, 1, 1, 0, 0, 0, 0, paris)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDateFilter(tt.after, tt.before, tt.inclusive, tt.dc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.After.Equal(tt.want.After) || !got.Before.Equal(tt.want.Before) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDateFilterErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		after, before string
+		inclusive     bool
+	}{
+		{"invalid after", "yesterday", "", false},
+		{"invalid before", "", "2024-13-01", false},
+		{"negative period", "-3d", "", false},
+		{"after the before date", "2024-02-01", "2024-01-01", false},
+		{"same exclusive day", "2024-01-01", "2024-01-02", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if f, err := ParseDateFilter(tt.after, tt.before, tt.inclusive, DateContext{Location: time.UTC}); err == nil {
+				t.Errorf("got %v, want an error", f)
+			}
+		})
+	}
+	// a single day is a valid range with inclusive bounds
+	if _, err := ParseDateFilter("2024-01-01", "2024-01-01", true, DateContext{}); err != nil {
+		t.Errorf("got error %v for a single inclusive day", err)
+	}
+}
+
+func TestParsePeriod(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"30d", 30 * 24 * time.Hour, false},
+		{"2w", 14 * 24 * time.Hour, false},
+		{"90m", 90 * time.Minute, false},
+		{"1h30m", 90 * time.Minute, false},
+		{"0d", 0, true},
+		{"-1h", 0, true},
+		{"d", 0, true},
+		{"3 days", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parsePeriod(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parsePeriod(%q) = %v, %v, want %v (error: %v)", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestDateFilterMatchDate(t *testing.T) {
+	f := DateFilter{
+		After:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Before: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+	}
+	tests := []struct {
+		date string
+		want bool
+	}{
+		{"2024-01-01T00:00:00.000Z", true},
+		{"2024-01-15", true},
+		{"2024-01-31T23:59:59Z", true},
+		{"2024-02-01T00:00:00Z", false},
+		{"2023-12-31T23:59:59Z", false},
+		{"2024-01-01T00:30:00+01:00", false},
+		{"not a date", false},
+	}
+	for _, tt := range tests {
+		if got := f.MatchDate(tt.date); got != tt.want {
+			t.Errorf("MatchDate(%q) = %v, want %v", tt.date, got, tt.want)
+		}
+	}
+	if !(DateFilter{}).MatchDate("not a date") {
+		t.Errorf("zero filter: MatchDate(%q) = false, want true", "not a date")
+	}
+	if (DateFilter{}).Func() != nil {
+		t.Errorf("zero filter: Func() isn't nil")
+	}
+}
+
+func TestMakeDateFilterFunc(t *testing.T) {
+	if f, err := MakeDateFilterFunc("", ""); err != nil || f != nil {
+		t.Errorf("got %v, want a nil function", err)
+	}
+	if _, err := MakeDateFilterFunc("2024-01-01", "2024-02-01"); err == nil {
+		t.Errorf("got no error for an after date later than the before date")
+	}
+	if _, err := MakeDateFilterFunc("soon", ""); err == nil {
+		t.Errorf("got no error for an invalid date")
+	}
+
+	f, err := MakeDateFilterFunc("2024-01-31T00:00:00Z", "2024-01-01T00:00:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for date, want := range map[string]bool{
+		"2024-01-01T00:00:00Z": false,
+		"2024-01-15T00:00:00Z": true,
+		"2024-01-31T00:00:00Z": false,
+	} {
+		if got := f(date); got != want {
+			t.Errorf("f(%q) = %v, want %v", date, got, want)
+		}
+	}
+}
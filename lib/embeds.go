@@ -0,0 +1,30 @@
+package lib
+
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/alexferrari88/sbstck-dl/plugin"
+)
+
+// ArchiveEmbeds replaces the external embeds of the Post's HTML body (tweets, Instagram posts, Spotify players, ...)
+// with a static copy of their text, preview image and link, readable offline in every format.
+// The preview images are then downloaded along with the other images of the post.
+func (p *Post) ArchiveEmbeds() error {
+	body, err := replaceEmbeds(p.BodyHTML)
+	if err != nil {
+		return err
+	}
+	p.BodyHTML = body
+	return nil
+}
+
+// replaceEmbeds returns bodyHTML with its embeds replaced by their static representation.
+func replaceEmbeds(bodyHTML string) (string, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(bodyHTML))
+	if err != nil {
+		return "", err
+	}
+	plugin.ReplaceEmbeds(doc.Selection)
+	return doc.Find("body").Html()
+}
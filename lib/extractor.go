@@ -6,13 +6,15 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"net/http"
+	"log/slog"
 	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	md "github.com/JohannesKaufmann/html-to-markdown"
 	"github.com/PuerkitoBio/goquery"
@@ -33,7 +35,9 @@ func (r *RawPost) ToPost() (Post, error) {
 		return Post{}, err
 
 	}
-	return wrapper.Post, nil
+	post := wrapper.Post
+	post.PublicationName = wrapper.Pub.Name
+	return post, nil
 }
 
 // Post represents a structured Substack post with various fields.
@@ -52,19 +56,79 @@ type Post struct {
 	//PostTags         []string `json:"postTags"`
 	Title    string `json:"title"`
 	BodyHTML string `json:"body_html"`
+
+	// podcast episode metadata, set on podcast posts only
+	PodcastUrl             string  `json:"podcast_url,omitempty"`
+	PodcastDuration        float64 `json:"podcast_duration,omitempty"`
+	PodcastEpisodeNumber   int     `json:"podcast_episode_number,omitempty"`
+	PodcastSeasonNumber    int     `json:"podcast_season_number,omitempty"`
+	PodcastEpisodeType     string  `json:"podcast_episode_type,omitempty"`
+	PodcastEpisodeImageUrl string  `json:"podcast_episode_image_url,omitempty"`
+
+	// Substack-hosted video, set on video posts only
+	VideoUpload   *VideoUpload `json:"videoUpload,omitempty"`
+	VideoUploadId string       `json:"video_upload_id,omitempty"`
+
+	// PublicationName is the name of the publication the post belongs to.
+	PublicationName string `json:"publication_name,omitempty"`
+
+	// Media holds the media files downloaded for the post, keyed by their original URL.
+	Media map[string]MediaFile `json:"-"`
+	// MediaErrors holds the media files that failed to download, if any.
+	// Their original URLs are left untouched in BodyHTML.
+	MediaErrors MediaErrors `json:"-"`
+	// Comments holds the comments of the post, if they were downloaded. They are rendered after the body.
+	Comments []Comment `json:"-"`
+	// CommentsErr is the error of the comments that failed to download, if any.
+	// Unlike media errors, it doesn't fail the post with WithStrictMedia nor make it incomplete.
+	CommentsErr error `json:"-"`
+}
+
+// OutputFormats are the formats posts can be written in.
+var OutputFormats = []string{"html", "md", "txt"}
+
+// OutputOptions holds configurable options for converting posts to the output formats.
+type OutputOptions struct {
+	DisabledMarkdownRules []string
+	Logger                *slog.Logger
+}
+
+// OutputOption defines a function that applies a specific option to OutputOptions.
+type OutputOption func(*OutputOptions)
+
+// WithoutMarkdownRules disables the given Substack-specific Markdown rules (see plugin.SubstackRules).
+func WithoutMarkdownRules(names ...string) OutputOption {
+	return func(o *OutputOptions) {
+		o.DisabledMarkdownRules = append(o.DisabledMarkdownRules, names...)
+	}
+}
+
+// WithOutputLogger sets the logger of the conversions, which log the Substack constructs they convert.
+// By default, nothing is logged.
+func WithOutputLogger(logger *slog.Logger) OutputOption {
+	return func(o *OutputOptions) {
+		o.Logger = logger
+	}
 }
 
 // ToMD converts the Post's HTML body to Markdown format.
-func (p *Post) ToMD(withTitle bool) (string, error) {
+// Substack-specific constructs (captions, footnotes, buttons, ...) are converted by the rules of the plugin package.
+func (p *Post) ToMD(withTitle bool, opts ...OutputOption) (string, error) {
+	var options OutputOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
 	var title string
 	if withTitle {
 		title = fmt.Sprintf("# %s\n\n", p.Title)
 	}
 	converter := md.NewConverter("", true, nil)
 
-	converter.Use(plugin.YoutubeEmbed())
+	converter.Use(plugin.YoutubeEmbed(plugin.WithLogger(options.Logger)))
+	converter.Use(plugin.Substack(options.DisabledMarkdownRules, plugin.WithLogger(options.Logger))...)
 
-	body, err := converter.ConvertString(p.BodyHTML)
+	body, err := converter.ConvertString(p.BodyHTML + commentsHTML(p.Comments))
 	if err != nil {
 		return "", err
 	}
@@ -72,19 +136,38 @@ func (p *Post) ToMD(withTitle bool) (string, error) {
 }
 
 // ToText converts the Post's HTML body to plain text format.
+// External embeds are rendered as their text and a link to the original, and footnotes as numbered endnotes.
 func (p *Post) ToText(withTitle bool) string {
+	body, err := p.textBodyHTML()
+	if err != nil {
+		body = p.BodyHTML
+	}
+	text := html2text.HTML2Text(body) + commentsText(p.Comments)
 	if withTitle {
-		return p.Title + "\n\n" + html2text.HTML2Text(p.BodyHTML)
+		return p.Title + "\n\n" + text
+	}
+	return text
+}
+
+// textBodyHTML returns the Post's HTML body prepared for the conversion to plain text.
+func (p *Post) textBodyHTML() (string, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(p.BodyHTML))
+	if err != nil {
+		return "", err
 	}
-	return html2text.HTML2Text(p.BodyHTML)
+	plugin.ReplaceEmbeds(doc.Selection)
+	doc.Find("blockquote.embed p:has(img)").Remove() // the preview image of an embed is a bare link in text
+	plugin.FootnotesToEndnotes(doc.Selection)
+	return doc.Find("body").Html()
 }
 
-// ToHTML returns the Post's HTML body as-is or with an optional title header.
+// ToHTML returns the Post's HTML body as-is or with an optional title header, followed by its comments if any.
 func (p *Post) ToHTML(withTitle bool) string {
+	body := p.BodyHTML + commentsHTML(p.Comments)
 	if withTitle {
-		return fmt.Sprintf("<h1>%s</h1>\n\n%s", p.Title, p.BodyHTML)
+		return fmt.Sprintf("<h1>%s</h1>\n\n%s", p.Title, body)
 	}
-	return p.BodyHTML
+	return body
 }
 
 // ToJSON converts the Post to a JSON string.
@@ -97,22 +180,15 @@ func (p *Post) ToJSON() (string, error) {
 }
 
 // WriteToFile writes the Post's content to a file in the specified format (html, md, or txt).
-func (p *Post) WriteToFile(path string, format string) error {
-	err := os.MkdirAll(filepath.Dir(path), 0755)
-	if err != nil {
-		return err
-	}
-	f, err := os.Create(path)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
+// The file is written atomically, so a crash never leaves a truncated post behind.
+func (p *Post) WriteToFile(path string, format string, opts ...OutputOption) error {
 	var content string
+	var err error
 	switch format {
 	case "html":
 		content = p.ToHTML(true)
 	case "md":
-		content, err = p.ToMD(true)
+		content, err = p.ToMD(true, opts...)
 		if err != nil {
 			return err
 		}
@@ -121,39 +197,215 @@ func (p *Post) WriteToFile(path string, format string) error {
 	default:
 		return fmt.Errorf("unknown format: %s", format)
 	}
-	_, err = f.WriteString(content)
-	if err != nil {
-		return err
-	}
 
-	err = f.Sync()
-	if err != nil {
+	return WriteFileAtomic(path, func(w io.Writer) error {
+		_, err := io.WriteString(w, content)
 		return err
-	}
-
-	return nil
+	})
 }
 
 // PostWrapper wraps a Post object for JSON unmarshaling.
 type PostWrapper struct {
 	Post Post `json:"post"`
+	Pub  struct {
+		Name string `json:"name"`
+	} `json:"pub"`
 }
 
 // Extractor is a utility for extracting Substack posts from URLs.
 type Extractor struct {
-	fetcher         *Fetcher
-	downloadedPosts map[string]struct{}
-	logFile         string
+	fetcher          *Fetcher
+	mediaFetcher     *Fetcher
+	downloadedPosts  map[string]struct{}
+	logFile          string
+	concurrency      int
+	orderedResults   bool
+	mediaConcurrency int
+	strictMedia      bool
+	mediaStore       *MediaStore
+	imagePolicy      ImagePolicy
+	skipPodcasts     bool
+	skipVideos       bool
+	archiveEmbeds    bool
+	comments         bool
+	logger           *slog.Logger
+	mu               sync.Mutex // guards downloadedPosts and the log file
+}
+
+// DefaultConcurrency defines the default number of posts extracted concurrently by ExtractAllPosts.
+const DefaultConcurrency = 4
+
+// ExtractorOptions holds configurable options for Extractor.
+type ExtractorOptions struct {
+	Concurrency      int
+	OrderedResults   bool
+	MediaFetcher     *Fetcher
+	MediaConcurrency int
+	StrictMedia      bool
+	MediaStore       *MediaStore
+	ImagePolicy      ImagePolicy
+	SkipPodcasts     bool
+	SkipVideos       bool
+	ArchiveEmbeds    bool
+	Comments         bool
+	Logger           *slog.Logger
 }
 
-// If the Fetcher is nil, a default Fetcher will be used.
-// NewExtractor creates a new Extractor with the provided Fetcher and log file.
-func NewExtractor(f *Fetcher, logFile string) (*Extractor, error) {
+// ExtractorOption defines a function that applies a specific option to ExtractorOptions.
+type ExtractorOption func(*ExtractorOptions)
+
+// WithConcurrency sets the maximum number of posts extracted concurrently by ExtractAllPosts.
+func WithConcurrency(n int) ExtractorOption {
+	return func(o *ExtractorOptions) {
+		if n > 0 {
+			o.Concurrency = n
+		}
+	}
+}
+
+// WithOrderedResults makes ExtractAllPosts deliver results in the same order as the given URLs.
+// By default, results are delivered as soon as each post is extracted.
+func WithOrderedResults(ordered bool) ExtractorOption {
+	return func(o *ExtractorOptions) {
+		o.OrderedResults = ordered
+	}
+}
+
+// WithMediaFetcher sets a dedicated Fetcher for media files, e.g. with its own rate limit.
+// By default, media files are downloaded through the same Fetcher as posts.
+func WithMediaFetcher(f *Fetcher) ExtractorOption {
+	return func(o *ExtractorOptions) {
+		if f != nil {
+			o.MediaFetcher = f
+		}
+	}
+}
+
+// WithMediaConcurrency sets the maximum number of media files of a post downloaded concurrently.
+func WithMediaConcurrency(n int) ExtractorOption {
+	return func(o *ExtractorOptions) {
+		if n > 0 {
+			o.MediaConcurrency = n
+		}
+	}
+}
+
+// WithStrictMedia makes a post fail when any of its media files can't be downloaded.
+// By default, failed media files are recorded in Post.MediaErrors and the post is kept.
+func WithStrictMedia(strict bool) ExtractorOption {
+	return func(o *ExtractorOptions) {
+		o.StrictMedia = strict
+	}
+}
+
+// WithMediaStore saves media files in a shared, content-addressed MediaStore instead of each post folder.
+func WithMediaStore(s *MediaStore) ExtractorOption {
+	return func(o *ExtractorOptions) {
+		o.MediaStore = s
+	}
+}
+
+// WithImagePolicy sets which version of each image is downloaded. By default, the original asset is downloaded.
+func WithImagePolicy(policy ImagePolicy) ExtractorOption {
+	return func(o *ExtractorOptions) {
+		o.ImagePolicy = policy
+	}
+}
+
+// WithSkipPodcasts disables downloading the audio of podcast episodes.
+func WithSkipPodcasts(skip bool) ExtractorOption {
+	return func(o *ExtractorOptions) {
+		o.SkipPodcasts = skip
+	}
+}
+
+// WithSkipVideos disables downloading Substack-hosted videos.
+func WithSkipVideos(skip bool) ExtractorOption {
+	return func(o *ExtractorOptions) {
+		o.SkipVideos = skip
+	}
+}
+
+// WithArchiveEmbeds replaces external embeds with a static copy of their text and preview image,
+// which is downloaded with the other images of the post.
+func WithArchiveEmbeds(archive bool) ExtractorOption {
+	return func(o *ExtractorOptions) {
+		o.ArchiveEmbeds = archive
+	}
+}
+
+// WithComments enables downloading the comments of each post, which are saved to CommentsFileName
+// in the folder of the post and rendered as a threaded section after its body.
+func WithComments(comments bool) ExtractorOption {
+	return func(o *ExtractorOptions) {
+		o.Comments = comments
+	}
+}
+
+// WithExtractorLogger sets the logger of the Extractor, which logs the progress of extractions.
+// By default, nothing is logged.
+func WithExtractorLogger(logger *slog.Logger) ExtractorOption {
+	return func(o *ExtractorOptions) {
+		if logger != nil {
+			o.Logger = logger
+		}
+	}
+}
+
+// NewExtractor creates a new Extractor with the provided Fetcher, log file and options.
+func NewExtractor(f *Fetcher, logFile string, opts ...ExtractorOption) (*Extractor, error) {
+	options := ExtractorOptions{
+		Concurrency:      DefaultConcurrency,
+		MediaFetcher:     f,
+		MediaConcurrency: DefaultMediaConcurrency,
+		ImagePolicy:      ImagePolicy{Mode: ImageOriginal},
+		Logger:           discardLogger(),
+	}
+
+	for _, opt := range opts {
+		opt(&options)
+	}
+
 	downloadedPosts, err := ReadLogFile(logFile)
 	if err != nil {
 		return nil, err
 	}
-	return &Extractor{fetcher: f, downloadedPosts: downloadedPosts, logFile: logFile}, nil
+	return &Extractor{
+		fetcher:          f,
+		downloadedPosts:  downloadedPosts,
+		logFile:          logFile,
+		concurrency:      options.Concurrency,
+		orderedResults:   options.OrderedResults,
+		mediaFetcher:     options.MediaFetcher,
+		mediaConcurrency: options.MediaConcurrency,
+		strictMedia:      options.StrictMedia,
+		mediaStore:       options.MediaStore,
+		imagePolicy:      options.ImagePolicy,
+		skipPodcasts:     options.SkipPodcasts,
+		skipVideos:       options.SkipVideos,
+		archiveEmbeds:    options.ArchiveEmbeds,
+		comments:         options.Comments,
+		logger:           options.Logger,
+	}, nil
+}
+
+// allowCookie lets the fetchers of the Extractor send their cookie to the host of pageUrl, a page of the publication.
+func (e *Extractor) allowCookie(pageUrl string) {
+	u, err := url.Parse(pageUrl)
+	if err != nil || u.Hostname() == "" {
+		return
+	}
+	e.fetcher.AllowCookieHost(u.Hostname())
+	e.mediaFetcher.AllowCookieHost(u.Hostname())
+}
+
+// isDownloaded reports whether the post with the given ID has already been downloaded.
+func (e *Extractor) isDownloaded(postID string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_, exists := e.downloadedPosts[postID]
+	return exists
 }
 
 // findScriptContent finds the content of the <script> tag containing JSON data.
@@ -180,6 +432,26 @@ func extractJSONString(scriptContent string) (string, error) {
 	return scriptContent[start+len("JSON.parse(\"") : end], nil
 }
 
+// preloadsJSON returns the JSON data Substack embeds in its pages through window._preloads.
+func preloadsJSON(doc *goquery.Document) (string, error) {
+	scriptContent := findScriptContent(doc)
+	if scriptContent == "" {
+		return "", errors.New("script content not found")
+	}
+
+	jsonString, err := extractJSONString(scriptContent)
+	if err != nil {
+		return "", err
+	}
+
+	// jsonString is a stringified JSON string. Convert it to a normal JSON string
+	var str string
+	if err := json.Unmarshal([]byte("\""+jsonString+"\""), &str); err != nil {
+		return "", err
+	}
+	return str, nil
+}
+
 //	func (e *Extractor) ExtractPost(ctx context.Context, pageUrl string) (Post, error) {
 //		// fetch page HTML content
 //		body, err := e.fetcher.FetchURL(ctx, pageUrl)
@@ -223,13 +495,16 @@ func extractJSONString(scriptContent string) (string, error) {
 //
 // Modificar la función ExtractPost para incluir la extracción de archivos multimedia
 // ExtractPost extracts a post from a given URL and downloads associated media files if necessary.
+// The post is not recorded as downloaded until MarkDownloaded is called, once its content has been written.
 func (e *Extractor) ExtractPost(ctx context.Context, pageUrl string, outputFolder string, force bool) (Post, error) {
 	postID := extractPostID(pageUrl)
-	if _, exists := e.downloadedPosts[postID]; exists && !force {
-		fmt.Printf("Post %s already downloaded. Skipping...\n", postID)
+	if e.isDownloaded(postID) && !force {
+		e.logger.Info("post already downloaded, skipping", "post", postID)
 		return Post{}, nil
 	}
+	e.logger.Debug("extracting post", "url", pageUrl)
 
+	e.allowCookie(pageUrl)
 	body, err := e.fetcher.FetchURL(ctx, pageUrl)
 	if err != nil {
 		return Post{}, fmt.Errorf("failed to fetch page: %s", err)
@@ -241,18 +516,8 @@ func (e *Extractor) ExtractPost(ctx context.Context, pageUrl string, outputFolde
 		return Post{}, fmt.Errorf("failed to fetch page: %s", err)
 	}
 
-	scriptContent := findScriptContent(doc)
-	if scriptContent == "" {
-		return Post{}, fmt.Errorf("failed to fetch page: script content not found")
-	}
-
-	jsonString, err := extractJSONString(scriptContent)
-	if err != nil {
-		return Post{}, fmt.Errorf("failed to fetch page: %s", err)
-	}
-
 	var rawJSON RawPost
-	err = json.Unmarshal([]byte("\""+jsonString+"\""), &rawJSON.str)
+	rawJSON.str, err = preloadsJSON(doc)
 	if err != nil {
 		return Post{}, fmt.Errorf("failed to fetch page: %s", err)
 	}
@@ -262,29 +527,125 @@ func (e *Extractor) ExtractPost(ctx context.Context, pageUrl string, outputFolde
 		return Post{}, fmt.Errorf("failed to fetch page: %s", err)
 	}
 
+	if err := p.SelectImageSources(e.imagePolicy); err != nil {
+		return Post{}, fmt.Errorf("failed to select images: %s", err)
+	}
+
+	if err := p.NormalizeFootnotes(); err != nil {
+		return Post{}, fmt.Errorf("failed to normalize footnotes: %s", err)
+	}
+
+	postFolder := filepath.Join(outputFolder, p.Slug)
+	if err := os.MkdirAll(postFolder, 0755); err != nil {
+		return Post{}, fmt.Errorf("failed to create post folder: %w", err)
+	}
+
+	// attachment widgets are replaced before extracting media, so their thumbnails aren't downloaded
+	attachments, attachmentErrs := downloadAttachments(ctx, e.mediaFetcher, e.mediaStore, &p, postFolder)
+
+	if e.archiveEmbeds {
+		if err := p.ArchiveEmbeds(); err != nil {
+			return Post{}, fmt.Errorf("failed to archive embeds: %s", err)
+		}
+	}
+
 	mediaUrls, err := p.ExtractMedia()
 	if err != nil {
 		return Post{}, fmt.Errorf("failed to extract media: %s", err)
 	}
 
-	postFolder := filepath.Join(outputFolder, p.Slug)
-	os.MkdirAll(postFolder, 0755)
+	var downloadedFiles map[string]MediaFile
+	var mediaErrs MediaErrors
+	if e.mediaStore != nil {
+		downloadedFiles, mediaErrs = e.mediaStore.DownloadMedia(ctx, e.mediaFetcher, mediaUrls, postFolder, e.mediaConcurrency)
+	} else {
+		downloadedFiles, mediaErrs = DownloadMedia(ctx, e.mediaFetcher, mediaUrls, postFolder, e.mediaConcurrency)
+	}
+	if err := p.ReplaceMediaURLs(downloadedFiles); err != nil {
+		return Post{}, fmt.Errorf("failed to replace media urls: %s", err)
+	}
 
-	downloadedFiles, err := DownloadMedia(mediaUrls, postFolder)
-	if err != nil {
-		return Post{}, fmt.Errorf("failed to download media: %s", err)
+	for fileUrl, attachment := range attachments {
+		downloadedFiles[fileUrl] = attachment
+	}
+	for fileUrl, err := range attachmentErrs {
+		if mediaErrs == nil {
+			mediaErrs = make(MediaErrors)
+		}
+		mediaErrs[fileUrl] = err
 	}
 
-	p.ReplaceMediaURLs(downloadedFiles)
-	e.downloadedPosts[postID] = struct{}{}
-	WriteLogFile(e.logFile, []string{postID})
+	if p.IsPodcast() && !e.skipPodcasts {
+		audio, err := e.mediaStore.save(p.PodcastUrl, postFolder, func(folder string) (MediaFile, error) {
+			return downloadPodcast(ctx, e.mediaFetcher, &p, folder)
+		})
+		if err != nil {
+			if mediaErrs == nil {
+				mediaErrs = make(MediaErrors)
+			}
+			mediaErrs[p.PodcastUrl] = err
+		} else {
+			downloadedFiles[p.PodcastUrl] = audio
+			p.BodyHTML = p.podcastPlayerHTML(audio.Path) + p.BodyHTML
+		}
+	}
+
+	if !e.skipVideos {
+		videos, videoErrs := downloadVideos(ctx, e.mediaFetcher, e.mediaStore, &p, postFolder)
+		for playlistUrl, video := range videos {
+			downloadedFiles[playlistUrl] = video
+		}
+		for playlistUrl, err := range videoErrs {
+			if mediaErrs == nil {
+				mediaErrs = make(MediaErrors)
+			}
+			mediaErrs[playlistUrl] = err
+		}
+	}
+
+	if e.comments {
+		commentsUrl, err := p.commentsURL()
+		if err == nil {
+			p.Comments, err = downloadComments(ctx, e.fetcher, commentsUrl, postFolder)
+		}
+		if err != nil {
+			e.logger.Warn("failed to download comments", "post", p.Slug, "error", err)
+			p.CommentsErr = err
+		} else {
+			e.logger.Debug("downloaded comments", "post", p.Slug, "count", CountComments(p.Comments))
+		}
+	}
+
+	for mediaUrl, err := range mediaErrs {
+		e.logger.Warn("failed to download media", "post", p.Slug, "url", mediaUrl, "error", err)
+	}
+	if len(mediaErrs) > 0 {
+		if e.strictMedia || ctx.Err() != nil {
+			return Post{}, fmt.Errorf("failed to download media: %w", mediaErrs)
+		}
+		p.MediaErrors = mediaErrs
+	}
+	p.Media = downloadedFiles
 
 	return p, nil
 }
 
-//type DateFilterFunc func(string) bool
-
+// GetAllPostsURLs returns the urls of all the posts of the publication at pubUrl, read from its sitemap.
+// If f is not nil, only the posts whose publication date complies with it are returned. The sitemap only gives
+// the time posts were last modified, so their publication dates are then read from the archive of the publication.
 func (e *Extractor) GetAllPostsURLs(ctx context.Context, pubUrl string, f DateFilterFunc) ([]string, error) {
+	if f != nil {
+		posts, err := e.GetArchive(ctx, pubUrl, f)
+		if err != nil {
+			return nil, err
+		}
+		urls := []string{}
+		for _, p := range posts {
+			urls = append(urls, p.CanonicalUrl)
+		}
+		return urls, nil
+	}
+
 	u, err := url.Parse(pubUrl)
 	if err != nil {
 		return nil, err
@@ -296,6 +657,7 @@ func (e *Extractor) GetAllPostsURLs(ctx context.Context, pubUrl string, f DateFi
 	}
 
 	// fetch the sitemap of the publication
+	e.allowCookie(pubUrl)
 	body, err := e.fetcher.FetchURL(ctx, u.String())
 	if err != nil {
 		return nil, err
@@ -317,16 +679,10 @@ func (e *Extractor) GetAllPostsURLs(ctx context.Context, pubUrl string, f DateFi
 		default:
 		}
 		urlSel := s.Find("loc")
-		lastmodSel := s.Find("lastmod")
 		url := urlSel.Text()
-		lastmod := lastmodSel.Text()
 		if !strings.Contains(url, "/p/") {
 			return true
 		}
-		// if the date filter function is not nil, check if the post date complies with the filter
-		if f != nil && !f(lastmod) {
-			return true
-		}
 		urls = append(urls, url)
 
 		return true
@@ -335,112 +691,138 @@ func (e *Extractor) GetAllPostsURLs(ctx context.Context, pubUrl string, f DateFi
 	return urls, nil
 }
 
+// ExtractResult represents the result of extracting the post at Url.
 type ExtractResult struct {
+	Url  string
 	Post Post
 	Err  error
+	// Elapsed is the time it took to extract the post, including its media.
+	Elapsed time.Duration
 }
 
-// ExtractAllPosts extracts all posts from a given list of URLs.
-func (e *Extractor) ExtractAllPosts(ctx context.Context, urls []string, outputFolder string, force bool) <-chan ExtractResult {
-	ch := make(chan ExtractResult, len(urls))
+// ExtractErrors aggregates the errors of the posts that failed to be extracted, keyed by post URL.
+type ExtractErrors map[string]error
 
-	go func() {
-		var wg sync.WaitGroup
-		wg.Add(len(urls))
-		newDownloads := []string{}
-		for _, u := range urls {
-			go func(url string) {
-				defer wg.Done()
-				postID := extractPostID(url)
-				if _, exists := e.downloadedPosts[postID]; exists && !force {
-					fmt.Printf("Post %s already downloaded. Skipping...\n", postID)
-					return
-				}
-				post, err := e.ExtractPost(ctx, url, outputFolder, force)
-				if err == nil && postID != "" {
-					e.downloadedPosts[postID] = struct{}{}
-					newDownloads = append(newDownloads, postID)
-				}
-				ch <- ExtractResult{Post: post, Err: err}
-			}(u)
-		}
-		wg.Wait()
-		close(ch)
-		WriteLogFile(e.logFile, newDownloads)
-	}()
+// Error returns a message listing every failed post with its error.
+func (e ExtractErrors) Error() string {
+	urls := make([]string, 0, len(e))
+	for u := range e {
+		urls = append(urls, u)
+	}
+	sort.Strings(urls)
 
-	return ch
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "failed to extract %d posts:", len(e))
+	for _, u := range urls {
+		fmt.Fprintf(&sb, "\n  %s: %s", u, e[u])
+	}
+	return sb.String()
 }
 
-// Añadir nuevas funciones para descargar archivos multimedia y extraer URLs de videos
-func (p *Post) ExtractMedia() ([]string, error) {
-	doc, err := goquery.NewDocumentFromReader(strings.NewReader(p.BodyHTML))
-	if err != nil {
-		return nil, err
+// indexedResult is an ExtractResult tagged with the position of its URL in the input list.
+type indexedResult struct {
+	index   int
+	result  ExtractResult
+	skipped bool
+}
+
+// ExtractAllPosts extracts all posts from a given list of URLs using a bounded pool of workers
+// (see WithConcurrency). Posts already downloaded are skipped unless force is set, and produce no result.
+// Results are delivered as they complete, or in input order if the Extractor was created WithOrderedResults.
+// Once ctx is cancelled, posts not yet started are skipped and reported with the context error.
+func (e *Extractor) ExtractAllPosts(ctx context.Context, urls []string, outputFolder string, force bool) <-chan ExtractResult {
+	ch := make(chan ExtractResult, len(urls))
+	jobs := make(chan int)
+	done := make(chan indexedResult, len(urls))
+
+	workers := e.concurrency
+	if workers <= 0 {
+		workers = DefaultConcurrency
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				done <- e.extractOne(ctx, idx, urls[idx], outputFolder, force)
+			}
+		}()
 	}
 
-	var mediaUrls []string
-	doc.Find("img").Each(func(i int, s *goquery.Selection) {
-		if src, exists := s.Attr("src"); exists {
-			mediaUrls = append(mediaUrls, src)
+	go func() {
+		defer close(jobs)
+		for idx := range urls {
+			jobs <- idx
 		}
-	})
+	}()
 
-	return mediaUrls, nil
-}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
 
-// New function to download media files
-func DownloadMedia(urls []string, outputFolder string) (map[string]string, error) {
-	downloadedFiles := make(map[string]string)
-	for _, mediaUrl := range urls {
-		resp, err := http.Get(mediaUrl)
-		if err != nil {
-			return nil, fmt.Errorf("failed to download media: %w", err)
+	go func() {
+		defer close(ch)
+		if !e.orderedResults {
+			for r := range done {
+				if !r.skipped {
+					ch <- r.result
+				}
+			}
+			return
 		}
-		defer resp.Body.Close()
-
-		fileName := cleanFileName(mediaUrl)
-		outputPath := filepath.Join(outputFolder, fileName)
-		out, err := os.Create(outputPath)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create media file: %w", err)
+		pending := make(map[int]indexedResult)
+		next := 0
+		for r := range done {
+			pending[r.index] = r
+			for {
+				p, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				next++
+				if !p.skipped {
+					ch <- p.result
+				}
+			}
 		}
-		defer out.Close()
+	}()
 
-		_, err = io.Copy(out, resp.Body)
-		if err != nil {
-			return nil, fmt.Errorf("failed to save media file: %w", err)
-		}
-		downloadedFiles[mediaUrl] = fileName
-	}
-	return downloadedFiles, nil
+	return ch
 }
 
-// cleanFileName extracts the filename from the URL and ensures it has a valid extension.
-func cleanFileName(mediaUrl string) string {
-	parsedUrl, err := url.PathUnescape(mediaUrl)
-	if err != nil {
-		parsedUrl = mediaUrl
+// extractOne extracts a single post for ExtractAllPosts.
+func (e *Extractor) extractOne(ctx context.Context, idx int, url string, outputFolder string, force bool) indexedResult {
+	if ctx.Err() != nil {
+		return indexedResult{index: idx, result: ExtractResult{Url: url, Err: ctx.Err()}}
 	}
-	segments := strings.Split(parsedUrl, "/")
-	fileName := segments[len(segments)-1]
-
-	// Ensure the file has a valid extension
-	validExtensions := []string{".jpeg", ".jpg", ".png", ".gif"}
-	for _, ext := range validExtensions {
-		if strings.HasSuffix(fileName, ext) {
-			return fileName
-		}
+	postID := extractPostID(url)
+	if e.isDownloaded(postID) && !force {
+		e.logger.Info("post already downloaded, skipping", "post", postID)
+		return indexedResult{index: idx, skipped: true}
 	}
-
-	// Default to .jpg if no valid extension is found
-	return fileName + ".jpg"
+	start := time.Now()
+	post, err := e.ExtractPost(ctx, url, outputFolder, force)
+	return indexedResult{index: idx, result: ExtractResult{Url: url, Post: post, Err: err, Elapsed: time.Since(start)}}
 }
 
-func (p *Post) ReplaceMediaURLs(downloadedFiles map[string]string) {
-	for originalURL, fileName := range downloadedFiles {
-		p.BodyHTML = strings.ReplaceAll(p.BodyHTML, originalURL, fileName)
+// MarkDownloaded records the post at postUrl as downloaded in the log file, so it is skipped by later runs.
+// It should only be called once all the files of the post, including its media files, have been written.
+// It is safe for concurrent use.
+func (e *Extractor) MarkDownloaded(postUrl string) error {
+	postID := extractPostID(postUrl)
+	if postID == "" {
+		return nil
 	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.downloadedPosts[postID] = struct{}{}
+	return WriteLogFile(e.logFile, []string{postID})
 }
 
 func extractPostID(url string) string {
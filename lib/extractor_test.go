@@ -0,0 +1,301 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// postServer serves minimal Substack post pages at /p/{slug}, calling handle (if not nil) before answering.
+// It counts the requests of each slug and the maximum number of requests served concurrently.
+type postServer struct {
+	*httptest.Server
+	handle func(w http.ResponseWriter, r *http.Request, slug string) bool
+
+	inFlight    atomic.Int32
+	maxInFlight atomic.Int32
+	mu          sync.Mutex
+	requests    map[string]int
+}
+
+func newPostServer(t *testing.T, handle func(w http.ResponseWriter, r *http.Request, slug string) bool) *postServer {
+	t.Helper()
+	s := &postServer{handle: handle, requests: make(map[string]int)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *postServer) serve(w http.ResponseWriter, r *http.Request) {
+	slug, ok := strings.CutPrefix(r.URL.Path, "/p/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	n := s.inFlight.Add(1)
+	defer s.inFlight.Add(-1)
+	for {
+		peak := s.maxInFlight.Load()
+		if n <= peak || s.maxInFlight.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	s.mu.Lock()
+	s.requests[slug]++
+	s.mu.Unlock()
+
+	if s.handle != nil && !s.handle(w, r, slug) {
+		return
+	}
+
+	preloads, _ := json.Marshal(map[string]any{
+		"post": map[string]any{
+			"id":            len(slug),
+			"slug":          slug,
+			"title":         "Post " + slug,
+			"canonical_url": s.URL + "/p/" + slug,
+			"body_html":     "<p>Body of " + slug + "</p>",
+		},
+		"pub": map[string]any{"name": "Test"},
+	})
+	quoted, _ := json.Marshal(string(preloads))
+	fmt.Fprintf(w, `<html><body><script>window._preloads = JSON.parse(%s)</script></body></html>`, quoted)
+}
+
+func (s *postServer) requestCount(slug string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[slug]
+}
+
+func (s *postServer) urls(n int) []string {
+	urls := make([]string, n)
+	for i := range urls {
+		urls[i] = fmt.Sprintf("%s/p/post-%02d", s.URL, i)
+	}
+	return urls
+}
+
+func newTestExtractor(t *testing.T, logFile string, opts ...ExtractorOption) *Extractor {
+	t.Helper()
+	f := NewFetcher(WithRatePerSecond(1000))
+	e, err := NewExtractor(f, logFile, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// collect drains the results of ExtractAllPosts, failing the test if the channel isn't closed in time.
+func collect(t *testing.T, ch <-chan ExtractResult) []ExtractResult {
+	t.Helper()
+	var results []ExtractResult
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case r, ok := <-ch:
+			if !ok {
+				return results
+			}
+			results = append(results, r)
+		case <-timeout:
+			t.Fatalf("results channel not closed, got %d results", len(results))
+		}
+	}
+}
+
+func TestExtractAllPostsConcurrencyLimit(t *testing.T) {
+	srv := newPostServer(t, func(w http.ResponseWriter, r *http.Request, slug string) bool {
+		time.Sleep(20 * time.Millisecond)
+		return true
+	})
+	dir := t.TempDir()
+	e := newTestExtractor(t, filepath.Join(dir, "log"), WithConcurrency(3))
+
+	urls := srv.urls(12)
+	results := collect(t, e.ExtractAllPosts(context.Background(), urls, dir, false))
+	if len(results) != len(urls) {
+		t.Fatalf("got %d results, want %d", len(results), len(urls))
+	}
+	for _, r := range results {
+		if r.Err != nil {
+			t.Errorf("%s: %v", r.Url, r.Err)
+		}
+	}
+	if peak := srv.maxInFlight.Load(); peak > 3 {
+		t.Errorf("%d posts fetched concurrently, want at most 3", peak)
+	} else if peak < 2 {
+		t.Errorf("posts were fetched one at a time, want up to 3 concurrently")
+	}
+}
+
+func TestExtractAllPostsOrder(t *testing.T) {
+	// earlier posts are slower, so they complete last
+	handle := func(w http.ResponseWriter, r *http.Request, slug string) bool {
+		var i int
+		fmt.Sscanf(slug, "post-%d", &i)
+		time.Sleep(time.Duration(8-i) * 10 * time.Millisecond)
+		return true
+	}
+
+	for _, ordered := range []bool{true, false} {
+		t.Run(fmt.Sprintf("ordered=%v", ordered), func(t *testing.T) {
+			srv := newPostServer(t, handle)
+			dir := t.TempDir()
+			e := newTestExtractor(t, filepath.Join(dir, "log"), WithConcurrency(8), WithOrderedResults(ordered))
+
+			urls := srv.urls(8)
+			results := collect(t, e.ExtractAllPosts(context.Background(), urls, dir, false))
+			if len(results) != len(urls) {
+				t.Fatalf("got %d results, want %d", len(results), len(urls))
+			}
+			got := make(map[string]bool)
+			inOrder := true
+			for i, r := range results {
+				if r.Err != nil {
+					t.Errorf("%s: %v", r.Url, r.Err)
+				}
+				if r.Post.CanonicalUrl != r.Url {
+					t.Errorf("result for %s holds the post %s", r.Url, r.Post.CanonicalUrl)
+				}
+				got[r.Url] = true
+				inOrder = inOrder && r.Url == urls[i]
+			}
+			for _, u := range urls {
+				if !got[u] {
+					t.Errorf("no result for %s", u)
+				}
+			}
+			if ordered && !inOrder {
+				t.Errorf("results not delivered in input order")
+			}
+			if !ordered && inOrder {
+				t.Errorf("results delivered in input order, want completion order")
+			}
+		})
+	}
+}
+
+func TestExtractAllPostsSkipsDownloaded(t *testing.T) {
+	srv := newPostServer(t, nil)
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "log")
+	urls := srv.urls(6)
+
+	e := newTestExtractor(t, logFile)
+	for _, u := range urls[:3] {
+		if err := e.MarkDownloaded(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a new extractor reads the downloaded posts from the log file
+	e = newTestExtractor(t, logFile)
+	results := collect(t, e.ExtractAllPosts(context.Background(), urls, dir, false))
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+	for _, r := range results {
+		if r.Err != nil {
+			t.Errorf("%s: %v", r.Url, r.Err)
+		}
+	}
+	for i, u := range urls {
+		slug := u[strings.LastIndex(u, "/")+1:]
+		want := 1
+		if i < 3 {
+			want = 0
+		}
+		if n := srv.requestCount(slug); n != want {
+			t.Errorf("%s requested %d times, want %d", slug, n, want)
+		}
+	}
+
+	results = collect(t, e.ExtractAllPosts(context.Background(), urls, dir, true))
+	if len(results) != len(urls) {
+		t.Errorf("forced extraction got %d results, want %d", len(results), len(urls))
+	}
+}
+
+func TestExtractAllPostsCancel(t *testing.T) {
+	started := make(chan struct{}, 10)
+	srv := newPostServer(t, func(w http.ResponseWriter, r *http.Request, slug string) bool {
+		started <- struct{}{}
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+		return false
+	})
+	dir := t.TempDir()
+	e := newTestExtractor(t, filepath.Join(dir, "log"), WithConcurrency(2))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	urls := srv.urls(10)
+	ch := e.ExtractAllPosts(ctx, urls, dir, false)
+	<-started
+	cancel()
+
+	results := collect(t, ch)
+	if len(results) != len(urls) {
+		t.Fatalf("got %d results, want %d", len(results), len(urls))
+	}
+	for _, r := range results {
+		if r.Err == nil {
+			t.Errorf("%s: extracted after cancellation", r.Url)
+		}
+	}
+	var requested int
+	for _, u := range urls {
+		requested += srv.requestCount(u[strings.LastIndex(u, "/")+1:])
+	}
+	if requested > 2 {
+		t.Errorf("%d posts requested, want at most the 2 in flight when cancelled", requested)
+	}
+}
+
+func TestMarkDownloadedConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "log")
+	e := newTestExtractor(t, logFile)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			postUrl := fmt.Sprintf("https://example.substack.com/p/post-%02d", i)
+			if err := e.MarkDownloaded(postUrl); err != nil {
+				t.Error(err)
+			}
+			e.isDownloaded(fmt.Sprintf("post-%02d", i))
+		}(i)
+	}
+	wg.Wait()
+
+	logged, err := ReadLogFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logged) != 50 {
+		t.Fatalf("log file holds %d posts, want 50", len(logged))
+	}
+	for i := 0; i < 50; i++ {
+		id := fmt.Sprintf("post-%02d", i)
+		if _, ok := logged[id]; !ok {
+			t.Errorf("%s missing from the log file", id)
+		}
+		if !e.isDownloaded(id) {
+			t.Errorf("%s not recorded as downloaded", id)
+		}
+	}
+}
@@ -2,11 +2,15 @@ package lib
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
@@ -38,6 +42,10 @@ type Fetcher struct {
 	RateLimiter *rate.Limiter
 	BackoffCfg  backoff.BackOff
 	Cookie      *http.Cookie
+	Logger      *slog.Logger
+
+	// cookieHosts are the hosts the Cookie is sent to besides substack.com, see AllowCookieHost.
+	cookieHosts sync.Map
 }
 
 // FetcherOptions holds configurable options for Fetcher.
@@ -46,6 +54,7 @@ type FetcherOptions struct {
 	ProxyURL      *url.URL
 	BackOffConfig backoff.BackOff
 	Cookie        *http.Cookie
+	Logger        *slog.Logger
 }
 
 // FetcherOption defines a function that applies a specific option to FetcherOptions.
@@ -73,6 +82,7 @@ func WithBackOffConfig(b backoff.BackOff) FetcherOption {
 }
 
 // WithCookie sets the cookie for the Fetcher.
+// It is only sent to substack.com and its subdomains, and to the hosts allowed with AllowCookieHost.
 func WithCookie(cookie *http.Cookie) FetcherOption {
 	return func(o *FetcherOptions) {
 		if cookie != nil {
@@ -81,6 +91,16 @@ func WithCookie(cookie *http.Cookie) FetcherOption {
 	}
 }
 
+// WithFetcherLogger sets the logger of the Fetcher, which logs requests and retries.
+// By default, nothing is logged.
+func WithFetcherLogger(logger *slog.Logger) FetcherOption {
+	return func(o *FetcherOptions) {
+		if logger != nil {
+			o.Logger = logger
+		}
+	}
+}
+
 // FetchResult represents the result of a URL fetch operation.
 type FetchResult struct {
 	Url   string
@@ -106,6 +126,7 @@ func NewFetcher(opts ...FetcherOption) *Fetcher {
 	options := FetcherOptions{
 		RatePerSecond: DefaultRatePerSecond,
 		BackOffConfig: makeDefaultBackoff(),
+		Logger:        discardLogger(),
 	}
 
 	for _, opt := range opts {
@@ -124,6 +145,7 @@ func NewFetcher(opts ...FetcherOption) *Fetcher {
 		RateLimiter: rate.NewLimiter(rate.Limit(options.RatePerSecond), 1),
 		BackoffCfg:  options.BackOffConfig,
 		Cookie:      options.Cookie,
+		Logger:      options.Logger,
 	}
 }
 
@@ -132,7 +154,6 @@ func NewFetcher(opts ...FetcherOption) *Fetcher {
 func (f *Fetcher) FetchURLs(ctx context.Context, urls []string) <-chan FetchResult {
 	results := make(chan FetchResult, len(urls))
 	ctx, ctxCancelFn := context.WithCancel(ctx)
-	defer ctxCancelFn()
 	var eg errgroup.Group
 
 	sem := make(chan struct{}, f.RateLimiter.Burst()) // worker pool
@@ -155,6 +176,7 @@ func (f *Fetcher) FetchURLs(ctx context.Context, urls []string) <-chan FetchResu
 
 	go func() {
 		eg.Wait()
+		ctxCancelFn()
 		close(results)
 	}()
 
@@ -163,9 +185,16 @@ func (f *Fetcher) FetchURLs(ctx context.Context, urls []string) <-chan FetchResu
 
 // FetchURL fetches the specified URL and returns the response body as io.ReadCloser and any encountered error.
 // It uses rate limiting and retry mechanisms to handle rate limits and transient failures.
+// Retries, including the waits between them, stop as soon as ctx is cancelled.
 func (f *Fetcher) FetchURL(ctx context.Context, url string) (io.ReadCloser, error) {
+	body, _, err := f.FetchURLWithHeader(ctx, url)
+	return body, err
+}
 
+// FetchURLWithHeader works like FetchURL, but also returns the response headers (e.g. to read the Content-Type).
+func (f *Fetcher) FetchURLWithHeader(ctx context.Context, url string) (io.ReadCloser, http.Header, error) {
 	var body io.ReadCloser
+	var header http.Header
 	var err error
 	var retryCounter int
 	var nextRetryWait time.Duration
@@ -176,14 +205,23 @@ func (f *Fetcher) FetchURL(ctx context.Context, url string) (io.ReadCloser, erro
 			return nil
 		}
 		if nextRetryWait > 0 {
-			time.Sleep(nextRetryWait)
+			select {
+			case <-ctx.Done():
+				err = ctx.Err()
+				return backoff.Permanent(err)
+			case <-time.After(nextRetryWait):
+			}
 		}
 		err = f.RateLimiter.Wait(ctx) // Use rate limiter
 		if err != nil {
-			return err // Could be a context cancellation or error in limiter
+			return backoff.Permanent(err) // Could be a context cancellation or error in limiter
 		}
-		body, err = f.fetch(ctx, url)
+		body, header, err = f.fetch(ctx, url)
 		if err != nil {
+			if ctx.Err() != nil {
+				err = ctx.Err()
+				return backoff.Permanent(err)
+			}
 			retryCounter++
 		}
 		return err
@@ -195,49 +233,108 @@ func (f *Fetcher) FetchURL(ctx context.Context, url string) (io.ReadCloser, erro
 			if retryCounter > 0 {
 				nextRetryWait *= time.Duration(retryCounter)
 			}
+			f.logger().Warn("rate limited, retrying", "url", url, "retry_after", nextRetryWait, "attempt", retryCounter)
+			return
 		}
+		f.logger().Warn("request failed, retrying", "url", url, "error", err, "backoff", d, "attempt", retryCounter)
 	}
 
-	backoff.RetryNotify(operation, f.BackoffCfg, notify)
+	// RetryNotify returns ctx.Err() when ctx is cancelled while waiting for the next attempt
+	if retryErr := backoff.RetryNotify(operation, backoff.WithContext(f.newBackOff(), ctx), notify); retryErr != nil {
+		err = retryErr
+	}
 
-	return body, err
+	var permanentErr *backoff.PermanentError
+	if errors.As(err, &permanentErr) {
+		err = permanentErr.Err
+	}
+	if err != nil {
+		f.logger().Debug("request failed", "url", url, "error", err)
+	} else {
+		f.logger().Debug("fetched", "url", url)
+	}
+
+	return body, header, err
+}
+
+// AllowCookieHost lets the Fetcher send its cookie to host, e.g. the custom domain of a publication.
+func (f *Fetcher) AllowCookieHost(host string) {
+	f.cookieHosts.Store(strings.ToLower(host), struct{}{})
+}
+
+// sendsCookieTo reports whether the cookie is sent with the requests to host.
+// The session cookie is only meant for Substack, not the third-party hosts of media files, attachments and embeds.
+func (f *Fetcher) sendsCookieTo(host string) bool {
+	host = strings.ToLower(host)
+	if host == "substack.com" || strings.HasSuffix(host, ".substack.com") {
+		return true
+	}
+	_, ok := f.cookieHosts.Load(host)
+	return ok
 }
 
-// fetch performs the actual HTTP GET request to the specified URL and returns the response body and any encountered error.
+// logger returns the logger of the Fetcher, which may have been created without NewFetcher.
+func (f *Fetcher) logger() *slog.Logger {
+	if f.Logger == nil {
+		return discardLogger()
+	}
+	return f.Logger
+}
+
+// fetch performs the actual HTTP GET request to the specified URL and returns the response body, headers and any encountered error.
 // It checks for too many requests (status code 429) and handles it by returning a FetchError.
-func (f *Fetcher) fetch(ctx context.Context, url string) (io.ReadCloser, error) {
+// Other client errors are returned as permanent errors, so they are not retried.
+func (f *Fetcher) fetch(ctx context.Context, url string) (io.ReadCloser, http.Header, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	req.Header.Set("User-Agent", userAgent)
 
-	// Add cookie to the request if it's not nil
-	if f.Cookie != nil {
+	// Add cookie to the request if it's not nil and the host is trusted with it
+	if f.Cookie != nil && f.sendsCookieTo(req.URL.Hostname()) {
 		req.AddCookie(f.Cookie)
 	}
 
 	res, err := f.Client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if res.StatusCode == http.StatusTooManyRequests {
+		res.Body.Close()
 		retryAfter := defaultRetryAfter
 		if retryAfterStr := res.Header.Get("Retry-After"); retryAfterStr != "" {
 			retryAfter, err = strconv.Atoi(retryAfterStr)
 			if err != nil {
-				return nil, fmt.Errorf("invalid Retry-After header: %v", err)
+				return nil, nil, fmt.Errorf("invalid Retry-After header: %v", err)
 			}
 		}
-		return nil, &FetchError{TooManyRequests: true, RetryAfter: retryAfter}
+		return nil, nil, &FetchError{TooManyRequests: true, RetryAfter: retryAfter}
 	}
 
 	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+		res.Body.Close()
+		err = fmt.Errorf("unexpected status code: %d", res.StatusCode)
+		if res.StatusCode >= 400 && res.StatusCode < 500 {
+			// client errors (e.g. 404) won't go away by retrying
+			return nil, nil, backoff.Permanent(err)
+		}
+		return nil, nil, err
 	}
 
-	return res.Body, nil
+	return res.Body, res.Header, nil
+}
+
+// newBackOff returns a fresh copy of the backoff configuration for a single fetch,
+// so that concurrent fetches don't share (and race on) the same retry state.
+func (f *Fetcher) newBackOff() backoff.BackOff {
+	if eb, ok := f.BackoffCfg.(*backoff.ExponentialBackOff); ok {
+		cp := *eb
+		cp.Reset()
+		return &cp
+	}
+	return f.BackoffCfg
 }
 
 // makeDefaultBackoff creates and returns the default exponential backoff configuration.
@@ -0,0 +1,134 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+func TestSendsCookieTo(t *testing.T) {
+	f := NewFetcher(WithCookie(&http.Cookie{Name: "substack.sid", Value: "secret"}))
+	f.AllowCookieHost("WWW.Example.com")
+
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"substack.com", true},
+		{"example.substack.com", true},
+		{"Example.Substack.com", true},
+		{"www.example.com", true},
+		{"example.com", false},
+		{"substackcdn.com", false},
+		{"evilsubstack.com", false},
+		{"substack.com.evil.com", false},
+	}
+	for _, tt := range tests {
+		if got := f.sendsCookieTo(tt.host); got != tt.want {
+			t.Errorf("sendsCookieTo(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestFetchCookie(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie("substack.sid"); err == nil {
+			io.WriteString(w, c.Value)
+		}
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+
+	f := NewFetcher(WithRatePerSecond(1000), WithCookie(&http.Cookie{Name: "substack.sid", Value: "secret"}))
+	fetch := func() string {
+		t.Helper()
+		body, err := f.FetchURL(context.Background(), srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer body.Close()
+		data, _ := io.ReadAll(body)
+		return string(data)
+	}
+
+	if got := fetch(); got != "" {
+		t.Errorf("cookie %q sent to an unknown host", got)
+	}
+	f.AllowCookieHost(u.Hostname())
+	if got := fetch(); got != "secret" {
+		t.Errorf("got cookie %q, want it sent to an allowed host", got)
+	}
+}
+
+func TestFetchURLRetries(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		switch r.URL.Path {
+		case "/flaky":
+			if n == 1 {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			io.WriteString(w, "ok")
+		case "/missing":
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	f := NewFetcher(WithRatePerSecond(1000), WithBackOffConfig(backoff.NewConstantBackOff(time.Millisecond)))
+
+	tests := []struct {
+		path     string
+		wantErr  bool
+		requests int32
+	}{
+		{"/flaky", false, 2},
+		// client errors aren't retried
+		{"/missing", true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			requests.Store(0)
+			body, err := f.FetchURL(context.Background(), srv.URL+tt.path)
+			if body != nil {
+				body.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error: %v", err, tt.wantErr)
+			}
+			if n := requests.Load(); n != tt.requests {
+				t.Errorf("got %d requests, want %d", n, tt.requests)
+			}
+		})
+	}
+}
+
+func TestFetchURLCancel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a long Retry-After, which the cancellation must not wait for
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+	f := NewFetcher(WithRatePerSecond(1000), WithBackOffConfig(backoff.NewConstantBackOff(time.Millisecond)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := f.FetchURL(ctx, srv.URL)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("cancelled fetch returned after %v", elapsed)
+	}
+}
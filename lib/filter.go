@@ -0,0 +1,226 @@
+package lib
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Post types, as set in the type of a post.
+const (
+	PostTypeNewsletter = "newsletter"
+	PostTypePodcast    = "podcast"
+	PostTypeThread     = "thread"
+	PostTypeVideo      = "video"
+)
+
+// Audiences a PostFilter can select posts by.
+const (
+	AudienceFree = "free"
+	AudiencePaid = "paid"
+)
+
+// PostFilter selects posts by their archive metadata.
+// Every criterion left to its zero value matches all posts; a post must match all the others.
+// Criteria taking a list match posts matching any of its items.
+type PostFilter struct {
+	// Types are the post types to keep (see PostTypeNewsletter and the other post types).
+	Types []string
+	// Audience keeps the posts free for everyone (AudienceFree) or restricted to paid subscribers (AudiencePaid).
+	Audience string
+	// Authors are the names or handles of the authors to keep, case-insensitively.
+	Authors []string
+	// Sections are the names or slugs of the sections to keep, case-insensitively.
+	Sections []string
+	// Tags are the names or slugs of the tags to keep, case-insensitively.
+	Tags []string
+	// Pattern keeps the posts whose title or slug it matches.
+	Pattern *regexp.Regexp
+	// MinWordCount keeps the posts with at least this many words.
+	MinWordCount int
+	// Slugs are the slugs of the posts to keep.
+	Slugs []string
+	// Published keeps the posts published within its bounds.
+	Published DateFilter
+	// Newest keeps the N most recent matching posts, Oldest the N oldest ones.
+	// At most one of them can be set.
+	Newest int
+	Oldest int
+}
+
+// Validate reports whether the filter is consistent and only uses known post types and audiences.
+func (f PostFilter) Validate() error {
+	for _, t := range f.Types {
+		switch t {
+		case PostTypeNewsletter, PostTypePodcast, PostTypeThread, PostTypeVideo:
+		default:
+			return fmt.Errorf("unknown post type: %s (options: %s, %s, %s, %s)", t, PostTypeNewsletter, PostTypePodcast, PostTypeThread, PostTypeVideo)
+		}
+	}
+	switch f.Audience {
+	case "", AudienceFree, AudiencePaid:
+	default:
+		return fmt.Errorf("unknown audience: %s (options: %s, %s)", f.Audience, AudienceFree, AudiencePaid)
+	}
+	if f.MinWordCount < 0 || f.Newest < 0 || f.Oldest < 0 {
+		return fmt.Errorf("word count and post limits can't be negative")
+	}
+	if f.Newest > 0 && f.Oldest > 0 {
+		return fmt.Errorf("the newest and oldest limits can't be used together")
+	}
+	return nil
+}
+
+// IsZero reports whether the filter keeps every post.
+func (f PostFilter) IsZero() bool {
+	return len(f.Types) == 0 && f.Audience == "" && len(f.Authors) == 0 && len(f.Sections) == 0 && len(f.Tags) == 0 &&
+		f.Pattern == nil && f.MinWordCount == 0 && len(f.Slugs) == 0 && f.Published.IsZero() && f.Newest == 0 && f.Oldest == 0
+}
+
+// Override returns the filter with the criteria set in o replacing its own, e.g. to apply per-publication criteria
+// on top of global ones. Setting either the Newest or the Oldest limit in o replaces both.
+func (f PostFilter) Override(o PostFilter) PostFilter {
+	if len(o.Types) > 0 {
+		f.Types = o.Types
+	}
+	if o.Audience != "" {
+		f.Audience = o.Audience
+	}
+	if len(o.Authors) > 0 {
+		f.Authors = o.Authors
+	}
+	if len(o.Sections) > 0 {
+		f.Sections = o.Sections
+	}
+	if len(o.Tags) > 0 {
+		f.Tags = o.Tags
+	}
+	if o.Pattern != nil {
+		f.Pattern = o.Pattern
+	}
+	if o.MinWordCount > 0 {
+		f.MinWordCount = o.MinWordCount
+	}
+	if len(o.Slugs) > 0 {
+		f.Slugs = o.Slugs
+	}
+	if !o.Published.IsZero() {
+		f.Published = o.Published
+	}
+	if o.Newest > 0 || o.Oldest > 0 {
+		f.Newest, f.Oldest = o.Newest, o.Oldest
+	}
+	return f
+}
+
+// Match reports whether p matches every criterion of the filter, except the Newest and Oldest limits.
+func (f PostFilter) Match(p PostSummary) bool {
+	if len(f.Types) > 0 && !containsFold(f.Types, p.Type) {
+		return false
+	}
+	if f.Audience != "" && p.audience() != f.Audience {
+		return false
+	}
+	if len(f.Authors) > 0 && !anyMatch(f.Authors, p.authorNames()...) {
+		return false
+	}
+	if len(f.Sections) > 0 && !anyMatch(f.Sections, p.SectionName, p.SectionSlug) {
+		return false
+	}
+	if len(f.Tags) > 0 && !anyMatch(f.Tags, p.tagNames()...) {
+		return false
+	}
+	if f.Pattern != nil && !f.Pattern.MatchString(p.Title) && !f.Pattern.MatchString(p.Slug) {
+		return false
+	}
+	if p.WordCount < f.MinWordCount {
+		return false
+	}
+	if len(f.Slugs) > 0 && !containsFold(f.Slugs, p.Slug) {
+		return false
+	}
+	if !f.Published.MatchDate(p.PostDate) {
+		return false
+	}
+	return true
+}
+
+// Apply returns the posts matching the filter.
+// With a Newest or Oldest limit, they are sorted from the newest or oldest one and only the first N are returned;
+// otherwise they keep their original order.
+func (f PostFilter) Apply(posts []PostSummary) []PostSummary {
+	matching := []PostSummary{}
+	for _, p := range posts {
+		if f.Match(p) {
+			matching = append(matching, p)
+		}
+	}
+
+	limit := f.Newest
+	if f.Oldest > 0 {
+		limit = f.Oldest
+	}
+	if limit == 0 {
+		return matching
+	}
+	// post dates are RFC 3339 timestamps in UTC, so they sort chronologically as strings
+	sort.SliceStable(matching, func(i, j int) bool {
+		if f.Oldest > 0 {
+			return matching[i].PostDate < matching[j].PostDate
+		}
+		return matching[i].PostDate > matching[j].PostDate
+	})
+	if len(matching) > limit {
+		matching = matching[:limit]
+	}
+	return matching
+}
+
+// audience returns whether the post is free for everyone (AudienceFree) or restricted to paid subscribers (AudiencePaid).
+func (p PostSummary) audience() string {
+	switch p.Audience {
+	case "only_paid", "founding":
+		return AudiencePaid
+	default:
+		return AudienceFree
+	}
+}
+
+// authorNames returns the names and handles of the authors of the post.
+func (p PostSummary) authorNames() []string {
+	var names []string
+	for _, b := range p.Bylines {
+		names = append(names, b.Name, b.Handle)
+	}
+	return names
+}
+
+// tagNames returns the names and slugs of the tags of the post.
+func (p PostSummary) tagNames() []string {
+	var names []string
+	for _, t := range p.Tags {
+		names = append(names, t.Name, t.Slug)
+	}
+	return names
+}
+
+// containsFold reports whether list contains s, case-insensitively.
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(strings.TrimSpace(item), s) {
+			return true
+		}
+	}
+	return false
+}
+
+// anyMatch reports whether any of the non-empty values is in list, case-insensitively.
+func anyMatch(list []string, values ...string) bool {
+	for _, v := range values {
+		if v != "" && containsFold(list, v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+package lib
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// filterPosts are the posts the PostFilter tests select from, from the oldest to the newest.
+var filterPosts = []PostSummary{
+	{Slug: "first", Title: "First post", PostDate: "2024-01-01T10:00:00.000Z", Audience: "everyone", Type: PostTypeNewsletter, WordCount: 1200,
+		Bylines: []Byline{{Name: "Jane Doe", Handle: "jane"}}, SectionName: "Essays", SectionSlug: "essays", Tags: []PostTag{{Name: "Go", Slug: "go"}}},
+	{Slug: "episode-1", Title: "Episode 1", PostDate: "2024-02-01T10:00:00.000Z", Audience: "only_paid", Type: PostTypePodcast, WordCount: 100,
+		Bylines: []Byline{{Name: "John Roe", Handle: "john"}}},
+	{Slug: "weekly-thread", Title: "Weekly thread", PostDate: "2024-03-01T10:00:00.000Z", Audience: "founding", Type: PostTypeThread, WordCount: 50,
+		Bylines: []Byline{{Name: "Jane Doe", Handle: "jane"}}, SectionName: "Community", SectionSlug: "community"},
+	{Slug: "last", Title: "Last post", PostDate: "2024-04-01T10:00:00.000Z", Audience: "everyone", Type: PostTypeNewsletter, WordCount: 3000,
+		Tags: []PostTag{{Name: "Rust lang", Slug: "rust"}}},
+}
+
+// slugs returns the slugs of posts.
+func slugs(posts []PostSummary) []string {
+	s := []string{}
+	for _, p := range posts {
+		s = append(s, p.Slug)
+	}
+	return s
+}
+
+func TestPostFilterValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  PostFilter
+		wantErr bool
+	}{
+		{"zero", PostFilter{}, false},
+		{"known types and audience", PostFilter{Types: []string{PostTypePodcast, PostTypeVideo}, Audience: AudiencePaid}, false},
+		{"unknown type", PostFilter{Types: []string{"article"}}, true},
+		{"unknown audience", PostFilter{Audience: "everyone"}, true},
+		{"negative word count", PostFilter{MinWordCount: -1}, true},
+		{"negative limit", PostFilter{Oldest: -1}, true},
+		{"newest and oldest", PostFilter{Newest: 1, Oldest: 1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.filter.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPostFilterIsZero(t *testing.T) {
+	if !(PostFilter{}).IsZero() {
+		t.Errorf("zero filter: IsZero() = false, want true")
+	}
+	for _, f := range []PostFilter{
+		{Types: []string{PostTypePodcast}},
+		{Pattern: regexp.MustCompile("a")},
+		{Published: DateFilter{After: time.Now()}},
+		{Oldest: 1},
+	} {
+		if f.IsZero() {
+			t.Errorf("%+v: IsZero() = true, want false", f)
+		}
+	}
+}
+
+func TestPostFilterApply(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter PostFilter
+		want   []string
+	}{
+		{"zero", PostFilter{}, []string{"first", "episode-1", "weekly-thread", "last"}},
+		{"types", PostFilter{Types: []string{PostTypePodcast, PostTypeThread}}, []string{"episode-1", "weekly-thread"}},
+		{"free", PostFilter{Audience: AudienceFree}, []string{"first", "last"}},
+		{"paid", PostFilter{Audience: AudiencePaid}, []string{"episode-1", "weekly-thread"}},
+		{"author name", PostFilter{Authors: []string{"jane doe"}}, []string{"first", "weekly-thread"}},
+		{"author handle", PostFilter{Authors: []string{"JOHN"}}, []string{"episode-1"}},
+		{"section", PostFilter{Sections: []string{"Community", "missing"}}, []string{"weekly-thread"}},
+		{"tag", PostFilter{Tags: []string{" go ", "Rust Lang"}}, []string{"first", "last"}},
+		{"pattern on title", PostFilter{Pattern: regexp.MustCompile(`(?i)^episode`)}, []string{"episode-1"}},
+		{"pattern on slug", PostFilter{Pattern: regexp.MustCompile(`-thread$`)}, []string{"weekly-thread"}},
+		{"min word count", PostFilter{MinWordCount: 1200}, []string{"first", "last"}},
+		{"slugs", PostFilter{Slugs: []string{"last", "First"}}, []string{"first", "last"}},
+		{"published", PostFilter{Published: DateFilter{
+			After:  time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
+			Before: time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC),
+		}}, []string{"episode-1", "weekly-thread"}},
+		{"all criteria", PostFilter{Types: []string{PostTypeNewsletter}, Authors: []string{"jane"}, MinWordCount: 1000}, []string{"first"}},
+		{"newest", PostFilter{Newest: 2}, []string{"last", "weekly-thread"}},
+		{"oldest", PostFilter{Oldest: 3, Audience: AudiencePaid}, []string{"episode-1", "weekly-thread"}},
+		{"no match", PostFilter{Types: []string{PostTypeVideo}, Newest: 1}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := slugs(tt.filter.Apply(filterPosts)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPostFilterOverride(t *testing.T) {
+	global := PostFilter{Types: []string{PostTypePodcast}, Audience: AudienceFree, MinWordCount: 100, Newest: 5}
+	tests := []struct {
+		name     string
+		override PostFilter
+		want     PostFilter
+	}{
+		{"zero", PostFilter{}, global},
+		{"some criteria", PostFilter{Types: []string{PostTypeVideo}, Slugs: []string{"a"}},
+			PostFilter{Types: []string{PostTypeVideo}, Audience: AudienceFree, MinWordCount: 100, Slugs: []string{"a"}, Newest: 5}},
+		{"oldest replaces newest", PostFilter{Oldest: 2},
+			PostFilter{Types: []string{PostTypePodcast}, Audience: AudienceFree, MinWordCount: 100, Oldest: 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := global.Override(tt.override)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			if err := got.Validate(); err != nil {
+				t.Errorf("overridden filter invalid: %v", err)
+			}
+		})
+	}
+}
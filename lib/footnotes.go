@@ -0,0 +1,27 @@
+package lib
+
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/alexferrari88/sbstck-dl/plugin"
+)
+
+// NormalizeFootnotes makes the footnote links of the Post's HTML body local and bidirectional,
+// so that footnotes work in the downloaded copy: each reference links to its footnote and back.
+func (p *Post) NormalizeFootnotes() error {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(p.BodyHTML))
+	if err != nil {
+		return err
+	}
+	if doc.Find("a.footnote-anchor, div.footnote").Length() == 0 {
+		return nil
+	}
+	plugin.NormalizeFootnotes(doc.Selection)
+	body, err := doc.Find("body").Html()
+	if err != nil {
+		return err
+	}
+	p.BodyHTML = body
+	return nil
+}
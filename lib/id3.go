@@ -0,0 +1,117 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// ID3Tags holds the metadata written into an MP3 file as an ID3v2.4 tag.
+// Empty fields are omitted from the tag.
+type ID3Tags struct {
+	Title        string // TIT2
+	Artist       string // TPE1
+	Album        string // TALB
+	Date         string // TDRC, e.g. 2024-01-02
+	Track        string // TRCK
+	Genre        string // TCON
+	Comment      string // COMM
+	CoverArt     []byte // APIC, front cover
+	CoverArtMIME string
+}
+
+// id3TextEncodingUTF8 is the ID3v2.4 text encoding byte for UTF-8.
+const id3TextEncodingUTF8 = 0x03
+
+// WriteID3 writes tags followed by the MP3 audio read from r to w.
+// Any ID3v2 tag already present at the start of the audio is replaced.
+func WriteID3(w io.Writer, r io.Reader, tags ID3Tags) error {
+	br := bufio.NewReader(r)
+	if err := skipID3v2(br); err != nil {
+		return err
+	}
+
+	if _, err := w.Write(tags.encode()); err != nil {
+		return err
+	}
+	_, err := io.Copy(w, br)
+	return err
+}
+
+// skipID3v2 discards the ID3v2 tag at the start of r, if any.
+func skipID3v2(r *bufio.Reader) error {
+	header, err := r.Peek(10)
+	if err != nil || !bytes.HasPrefix(header, []byte("ID3")) {
+		return nil // no tag (or audio shorter than a tag header)
+	}
+	size := int(syncsafeDecode(header[6:10])) + 10
+	if header[5]&0x10 != 0 { // footer present
+		size += 10
+	}
+	_, err = r.Discard(size)
+	return err
+}
+
+// encode returns the binary ID3v2.4 tag.
+func (t ID3Tags) encode() []byte {
+	var frames bytes.Buffer
+	for _, f := range []struct{ id, text string }{
+		{"TIT2", t.Title},
+		{"TPE1", t.Artist},
+		{"TALB", t.Album},
+		{"TDRC", t.Date},
+		{"TRCK", t.Track},
+		{"TCON", t.Genre},
+	} {
+		if f.text != "" {
+			writeID3Frame(&frames, f.id, append([]byte{id3TextEncodingUTF8}, f.text...))
+		}
+	}
+
+	if t.Comment != "" {
+		var data bytes.Buffer
+		data.WriteByte(id3TextEncodingUTF8)
+		data.WriteString("eng")
+		data.WriteByte(0) // empty short description
+		data.WriteString(t.Comment)
+		writeID3Frame(&frames, "COMM", data.Bytes())
+	}
+
+	if len(t.CoverArt) > 0 {
+		var data bytes.Buffer
+		data.WriteByte(id3TextEncodingUTF8)
+		data.WriteString(t.CoverArtMIME)
+		data.WriteByte(0)
+		data.WriteByte(0x03) // picture type: front cover
+		data.WriteByte(0)    // empty description
+		data.Write(t.CoverArt)
+		writeID3Frame(&frames, "APIC", data.Bytes())
+	}
+
+	var tag bytes.Buffer
+	tag.WriteString("ID3")
+	tag.Write([]byte{4, 0, 0}) // version 2.4.0, no flags
+	tag.Write(syncsafeEncode(uint32(frames.Len())))
+	tag.Write(frames.Bytes())
+	return tag.Bytes()
+}
+
+// writeID3Frame writes an ID3v2.4 frame with the given ID and data to buf.
+func writeID3Frame(buf *bytes.Buffer, id string, data []byte) {
+	buf.WriteString(id)
+	buf.Write(syncsafeEncode(uint32(len(data))))
+	buf.Write([]byte{0, 0}) // no flags
+	buf.Write(data)
+}
+
+// syncsafeEncode encodes n as a 4-byte syncsafe integer (7 bits per byte), as used by ID3v2.4 sizes.
+func syncsafeEncode(n uint32) []byte {
+	return []byte{byte(n >> 21 & 0x7f), byte(n >> 14 & 0x7f), byte(n >> 7 & 0x7f), byte(n & 0x7f)}
+}
+
+// syncsafeDecode decodes a 4-byte syncsafe integer.
+func syncsafeDecode(b []byte) uint32 {
+	n := binary.BigEndian.Uint32(b)
+	return n&0x7f | (n>>8&0x7f)<<7 | (n>>16&0x7f)<<14 | (n>>24&0x7f)<<21
+}
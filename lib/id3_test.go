@@ -0,0 +1,145 @@
+package lib
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// id3Frame is a frame read back from an encoded ID3v2.4 tag.
+type id3Frame struct {
+	id   string
+	data string
+}
+
+// readID3 parses the ID3v2.4 tag at the start of data, returning its frames and the bytes following it.
+func readID3(t *testing.T, data []byte) ([]id3Frame, []byte) {
+	t.Helper()
+	if len(data) < 10 || !bytes.HasPrefix(data, []byte("ID3\x04\x00\x00")) {
+		t.Fatalf("no ID3v2.4 header in %q", data)
+	}
+	size := int(syncsafeDecode(data[6:10]))
+	if len(data) < 10+size {
+		t.Fatalf("tag of %d bytes in %d bytes", size, len(data))
+	}
+	body := data[10 : 10+size]
+	var frames []id3Frame
+	for len(body) > 0 {
+		if len(body) < 10 {
+			t.Fatalf("truncated frame header %q", body)
+		}
+		id, frameSize := string(body[:4]), int(syncsafeDecode(body[4:8]))
+		if len(body) < 10+frameSize {
+			t.Fatalf("frame %s of %d bytes in %d bytes", id, frameSize, len(body)-10)
+		}
+		frames = append(frames, id3Frame{id, string(body[10 : 10+frameSize])})
+		body = body[10+frameSize:]
+	}
+	return frames, data[10+size:]
+}
+
+func TestSyncsafe(t *testing.T) {
+	tests := []struct {
+		n       uint32
+		encoded []byte
+	}{
+		{0, []byte{0, 0, 0, 0}},
+		{127, []byte{0, 0, 0, 127}},
+		{128, []byte{0, 0, 1, 0}},
+		{255, []byte{0, 0, 1, 127}},
+		{1<<28 - 1, []byte{127, 127, 127, 127}},
+	}
+	for _, tt := range tests {
+		if got := syncsafeEncode(tt.n); !bytes.Equal(got, tt.encoded) {
+			t.Errorf("syncsafeEncode(%d) = %v, want %v", tt.n, got, tt.encoded)
+		}
+		if got := syncsafeDecode(tt.encoded); got != tt.n {
+			t.Errorf("syncsafeDecode(%v) = %d, want %d", tt.encoded, got, tt.n)
+		}
+	}
+}
+
+func TestID3TagsEncode(t *testing.T) {
+	tests := []struct {
+		name string
+		tags ID3Tags
+		want []id3Frame
+	}{
+		{
+			name: "empty",
+			tags: ID3Tags{},
+			want: nil,
+		},
+		{
+			name: "text frames",
+			tags: ID3Tags{Title: "Épisode 1", Artist: "Pub", Album: "Pub", Date: "2024-01-02", Track: "1", Genre: "Podcast"},
+			want: []id3Frame{
+				{"TIT2", "\x03Épisode 1"},
+				{"TPE1", "\x03Pub"},
+				{"TALB", "\x03Pub"},
+				{"TDRC", "\x032024-01-02"},
+				{"TRCK", "\x031"},
+				{"TCON", "\x03Podcast"},
+			},
+		},
+		{
+			name: "comment and cover art",
+			tags: ID3Tags{Comment: "About this episode", CoverArt: []byte("\x89PNG"), CoverArtMIME: "image/png"},
+			want: []id3Frame{
+				{"COMM", "\x03eng\x00About this episode"},
+				{"APIC", "\x03image/png\x00\x03\x00\x89PNG"},
+			},
+		},
+		{
+			name: "large frame",
+			tags: ID3Tags{Comment: strings.Repeat("a", 300)},
+			want: []id3Frame{{"COMM", "\x03eng\x00" + strings.Repeat("a", 300)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames, rest := readID3(t, tt.tags.encode())
+			if !reflect.DeepEqual(frames, tt.want) {
+				t.Errorf("got frames %q, want %q", frames, tt.want)
+			}
+			if len(rest) > 0 {
+				t.Errorf("got %d bytes after the tag", len(rest))
+			}
+		})
+	}
+}
+
+func TestWriteID3(t *testing.T) {
+	audio := "\xff\xfb\x90\x00audio frames"
+	oldTag := ID3Tags{Title: "Old title", Comment: "old"}.encode()
+	// an ID3v2.4 tag with the footer flag, followed by its 10-byte footer
+	footerTag := append([]byte("ID3\x04\x00\x10\x00\x00\x00\x00"), []byte("3DI\x04\x00\x10\x00\x00\x00\x00")...)
+
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"untagged audio", audio},
+		{"tagged audio", string(oldTag) + audio},
+		{"tag with footer", string(footerTag) + audio},
+		{"short audio", "\xff\xfb"},
+		{"no audio", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := WriteID3(&out, strings.NewReader(tt.input), ID3Tags{Title: "New title"}); err != nil {
+				t.Fatal(err)
+			}
+			frames, rest := readID3(t, out.Bytes())
+			if want := []id3Frame{{"TIT2", "\x03New title"}}; !reflect.DeepEqual(frames, want) {
+				t.Errorf("got frames %q, want %q", frames, want)
+			}
+			wantAudio := strings.TrimPrefix(strings.TrimPrefix(tt.input, string(oldTag)), string(footerTag))
+			if string(rest) != wantAudio {
+				t.Errorf("got audio %q, want %q", rest, wantAudio)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package lib
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const (
+	// ImageOriginal downloads the original asset behind Substack's resizing CDN.
+	ImageOriginal = "original"
+	// ImageLargest downloads the largest version of an image offered by the post.
+	ImageLargest = "largest"
+	// ImageWidth downloads an image resized to a specific width.
+	ImageWidth = "width"
+)
+
+// ImagePolicy selects which version of an image is downloaded.
+type ImagePolicy struct {
+	Mode  string // ImageOriginal, ImageLargest or ImageWidth
+	Width int    // the requested width, with ImageWidth
+}
+
+// ParseImagePolicy parses an image policy: "original", "largest", or a width in pixels.
+func ParseImagePolicy(s string) (ImagePolicy, error) {
+	switch s {
+	case ImageOriginal, ImageLargest:
+		return ImagePolicy{Mode: s}, nil
+	}
+	width, err := strconv.Atoi(s)
+	if err != nil || width <= 0 {
+		return ImagePolicy{}, fmt.Errorf("invalid image quality %q: must be \"original\", \"largest\" or a width in pixels", s)
+	}
+	return ImagePolicy{Mode: ImageWidth, Width: width}, nil
+}
+
+// substackCDNURL matches Substack's image proxy URLs, capturing the transformations and the (escaped) original URL,
+// e.g. https://substackcdn.com/image/fetch/w_1456,c_limit,f_auto/https%3A%2F%2Fsubstack-post-media.s3.amazonaws.com%2Fimage.png
+var substackCDNURL = regexp.MustCompile(`^(https?://substackcdn\.com/image/fetch/)([^/]+)/(.+)$`)
+
+// cdnWidth matches the width transformation of a Substack CDN URL.
+var cdnWidth = regexp.MustCompile(`(^|,)w_\d+`)
+
+// substackOriginalURL returns the original asset URL behind a Substack CDN URL.
+func substackOriginalURL(imageUrl string) (string, bool) {
+	match := substackCDNURL.FindStringSubmatch(imageUrl)
+	if match == nil {
+		return "", false
+	}
+	original, err := url.PathUnescape(match[3])
+	if err != nil || !strings.HasPrefix(original, "http") {
+		return "", false
+	}
+	return original, true
+}
+
+// substackResizedURL returns a Substack CDN URL serving the image resized to the given width.
+func substackResizedURL(imageUrl string, width int) (string, bool) {
+	match := substackCDNURL.FindStringSubmatch(imageUrl)
+	if match == nil {
+		return "", false
+	}
+	transforms := match[2]
+	if cdnWidth.MatchString(transforms) {
+		transforms = cdnWidth.ReplaceAllString(transforms, fmt.Sprintf("${1}w_%d", width))
+	} else {
+		transforms = fmt.Sprintf("w_%d,%s", width, transforms)
+	}
+	return match[1] + transforms + "/" + match[3], true
+}
+
+// cdnURLWidth returns the width requested by a Substack CDN URL, or 0 if unknown.
+func cdnURLWidth(imageUrl string) int {
+	match := substackCDNURL.FindStringSubmatch(imageUrl)
+	if match == nil {
+		return 0
+	}
+	w := cdnWidth.FindString(match[2])
+	width, _ := strconv.Atoi(strings.TrimLeft(w, ",w_"))
+	return width
+}
+
+// imageCandidate is a version of an image offered by a post, with its width if known (0 otherwise).
+type imageCandidate struct {
+	url   string
+	width int
+}
+
+// srcsetCandidate is an image candidate of a srcset attribute, as written: its URL and its descriptors.
+type srcsetCandidate struct {
+	url        string
+	descriptor string
+}
+
+// splitSrcset splits the value of a srcset attribute into its candidates.
+// Image URLs may themselves contain commas (as Substack CDN URLs do), so candidates are split
+// following the HTML specification: a URL runs until whitespace, its descriptors until the next comma.
+func splitSrcset(srcset string) []srcsetCandidate {
+	var candidates []srcsetCandidate
+	s := srcset
+	for {
+		s = strings.TrimLeft(s, " \t\n\r\f,")
+		if s == "" {
+			return candidates
+		}
+		end := strings.IndexAny(s, " \t\n\r\f")
+		if end == -1 {
+			end = len(s)
+		}
+		candidateUrl := strings.TrimRight(s[:end], ",")
+		hadTrailingComma := len(candidateUrl) < end
+		s = s[end:]
+
+		var descriptor string
+		if !hadTrailingComma {
+			if comma := strings.IndexByte(s, ','); comma != -1 {
+				descriptor, s = s[:comma], s[comma+1:]
+			} else {
+				descriptor, s = s, ""
+			}
+		}
+		candidates = append(candidates, srcsetCandidate{url: candidateUrl, descriptor: strings.TrimSpace(descriptor)})
+	}
+}
+
+// joinSrcset returns the value of a srcset attribute made of the given candidates.
+func joinSrcset(candidates []srcsetCandidate) string {
+	parts := make([]string, len(candidates))
+	for i, c := range candidates {
+		parts[i] = strings.TrimSpace(c.url + " " + c.descriptor)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// parseSrcset parses the value of a srcset attribute into image candidates with their width.
+func parseSrcset(srcset string) []imageCandidate {
+	var candidates []imageCandidate
+	for _, c := range splitSrcset(srcset) {
+		candidate := imageCandidate{url: c.url}
+		for _, d := range strings.Fields(c.descriptor) {
+			if strings.HasSuffix(d, "w") {
+				candidate.width, _ = strconv.Atoi(strings.TrimSuffix(d, "w"))
+			}
+		}
+		if candidate.width == 0 {
+			candidate.width = cdnURLWidth(c.url)
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates
+}
+
+// imageCandidates returns all the versions of the image offered by an <img> element:
+// its src, its srcset, and the srcsets of the <source> elements of its enclosing <picture>.
+func imageCandidates(img *goquery.Selection) []imageCandidate {
+	var candidates []imageCandidate
+	if src, exists := img.Attr("src"); exists && src != "" {
+		candidates = append(candidates, imageCandidate{url: src, width: cdnURLWidth(src)})
+	}
+	candidates = append(candidates, parseSrcset(img.AttrOr("srcset", ""))...)
+	img.Closest("picture").Find("source").Each(func(i int, s *goquery.Selection) {
+		candidates = append(candidates, parseSrcset(s.AttrOr("srcset", ""))...)
+	})
+	return candidates
+}
+
+// selectImage returns the URL of the version of an image to download according to policy.
+func selectImage(candidates []imageCandidate, policy ImagePolicy) string {
+	if len(candidates) == 0 {
+		return ""
+	}
+
+	if policy.Mode == ImageOriginal {
+		for _, c := range candidates {
+			if original, ok := substackOriginalURL(c.url); ok {
+				return original
+			}
+		}
+	}
+
+	if policy.Mode == ImageWidth {
+		for _, c := range candidates {
+			if resized, ok := substackResizedURL(c.url, policy.Width); ok {
+				return resized
+			}
+		}
+		// no CDN URL to resize: pick the smallest candidate at least as wide as requested
+		var best *imageCandidate
+		for i, c := range candidates {
+			if c.width >= policy.Width && (best == nil || c.width < best.width) {
+				best = &candidates[i]
+			}
+		}
+		if best != nil {
+			return best.url
+		}
+	}
+
+	largest := candidates[0]
+	for _, c := range candidates[1:] {
+		if c.width > largest.width {
+			largest = c
+		}
+	}
+	return largest.url
+}
+
+// SelectImageSources rewrites every image of the Post's HTML body to reference a single version,
+// chosen according to policy among its src, srcset and <picture> sources.
+// The srcset attributes and <source> elements are removed, so that the downloaded copy is the one displayed.
+func (p *Post) SelectImageSources(policy ImagePolicy) error {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(p.BodyHTML))
+	if err != nil {
+		return err
+	}
+
+	doc.Find("img").Each(func(i int, img *goquery.Selection) {
+		if selected := selectImage(imageCandidates(img), policy); selected != "" {
+			img.SetAttr("src", selected)
+		}
+		img.RemoveAttr("srcset")
+		img.RemoveAttr("sizes")
+	})
+	doc.Find("picture source").Remove()
+
+	body, err := doc.Find("body").Html()
+	if err != nil {
+		return err
+	}
+	p.BodyHTML = body
+	return nil
+}
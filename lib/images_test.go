@@ -0,0 +1,159 @@
+package lib
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	cdnOriginal = "https://substack-post-media.s3.amazonaws.com/public/images/a.png"
+	cdnEscaped  = "https%3A%2F%2Fsubstack-post-media.s3.amazonaws.com%2Fpublic%2Fimages%2Fa.png"
+)
+
+func cdnURL(transforms string) string {
+	return "https://substackcdn.com/image/fetch/" + transforms + "/" + cdnEscaped
+}
+
+func TestSplitSrcset(t *testing.T) {
+	tests := []struct {
+		name   string
+		srcset string
+		want   []srcsetCandidate
+	}{
+		{
+			name:   "widths",
+			srcset: "a.png 424w, b.png 848w",
+			want:   []srcsetCandidate{{"a.png", "424w"}, {"b.png", "848w"}},
+		},
+		{
+			name:   "commas in urls",
+			srcset: cdnURL("w_424,c_limit,f_webp") + " 424w, " + cdnURL("w_848,c_limit,f_webp") + " 848w",
+			want: []srcsetCandidate{
+				{cdnURL("w_424,c_limit,f_webp"), "424w"},
+				{cdnURL("w_848,c_limit,f_webp"), "848w"},
+			},
+		},
+		{
+			name:   "no descriptors",
+			srcset: "a.png, b.png",
+			want:   []srcsetCandidate{{"a.png", ""}, {"b.png", ""}},
+		},
+		{
+			name:   "density and extra whitespace",
+			srcset: "\n  a.png   1x ,\tb.png 2x  ",
+			want:   []srcsetCandidate{{"a.png", "1x"}, {"b.png", "2x"}},
+		},
+		{
+			name:   "empty",
+			srcset: " , ",
+			want:   nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitSrcset(tt.srcset); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJoinSrcset(t *testing.T) {
+	srcset := cdnURL("w_424,c_limit") + " 424w, b.png, c.png 2x"
+	if got := joinSrcset(splitSrcset(srcset)); got != srcset {
+		t.Errorf("got %q, want %q", got, srcset)
+	}
+}
+
+func TestParseImagePolicy(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    ImagePolicy
+		wantErr bool
+	}{
+		{"original", ImagePolicy{Mode: ImageOriginal}, false},
+		{"largest", ImagePolicy{Mode: ImageLargest}, false},
+		{"800", ImagePolicy{Mode: ImageWidth, Width: 800}, false},
+		{"0", ImagePolicy{}, true},
+		{"-1", ImagePolicy{}, true},
+		{"best", ImagePolicy{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseImagePolicy(tt.s)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseImagePolicy(%q) = %+v, %v, want %+v (error: %v)", tt.s, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestSubstackCDNURLs(t *testing.T) {
+	tests := []struct {
+		url      string
+		original string
+		resized  string
+		width    int
+		isCDN    bool
+	}{
+		{cdnURL("w_1456,c_limit,f_auto"), cdnOriginal, cdnURL("w_800,c_limit,f_auto"), 1456, true},
+		{cdnURL("c_limit,w_424"), cdnOriginal, cdnURL("c_limit,w_800"), 424, true},
+		{cdnURL("f_auto,q_auto:good"), cdnOriginal, cdnURL("w_800,f_auto,q_auto:good"), 0, true},
+		{"https://example.com/a.png", "", "", 0, false},
+	}
+	for _, tt := range tests {
+		original, ok := substackOriginalURL(tt.url)
+		if ok != tt.isCDN || original != tt.original {
+			t.Errorf("substackOriginalURL(%q) = %q, %v, want %q", tt.url, original, ok, tt.original)
+		}
+		resized, ok := substackResizedURL(tt.url, 800)
+		if ok != tt.isCDN || resized != tt.resized {
+			t.Errorf("substackResizedURL(%q) = %q, %v, want %q", tt.url, resized, ok, tt.resized)
+		}
+		if width := cdnURLWidth(tt.url); width != tt.width {
+			t.Errorf("cdnURLWidth(%q) = %d, want %d", tt.url, width, tt.width)
+		}
+	}
+}
+
+func TestSelectImage(t *testing.T) {
+	plain := []imageCandidate{{"small.png", 400}, {"large.png", 1600}, {"medium.png", 800}}
+	cdn := []imageCandidate{{cdnURL("w_424,c_limit"), 424}, {cdnURL("w_1456,c_limit"), 1456}}
+
+	tests := []struct {
+		name       string
+		candidates []imageCandidate
+		policy     ImagePolicy
+		want       string
+	}{
+		{"original from cdn", cdn, ImagePolicy{Mode: ImageOriginal}, cdnOriginal},
+		{"original without cdn falls back to largest", plain, ImagePolicy{Mode: ImageOriginal}, "large.png"},
+		{"largest", cdn, ImagePolicy{Mode: ImageLargest}, cdnURL("w_1456,c_limit")},
+		{"width resizes cdn", cdn, ImagePolicy{Mode: ImageWidth, Width: 800}, cdnURL("w_800,c_limit")},
+		{"width picks smallest wide enough", plain, ImagePolicy{Mode: ImageWidth, Width: 600}, "medium.png"},
+		{"width larger than all", plain, ImagePolicy{Mode: ImageWidth, Width: 2000}, "large.png"},
+		{"unknown widths keep the first", []imageCandidate{{"a.png", 0}, {"b.png", 0}}, ImagePolicy{Mode: ImageLargest}, "a.png"},
+		{"no candidates", nil, ImagePolicy{Mode: ImageLargest}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := selectImage(tt.candidates, tt.policy); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectImageSources(t *testing.T) {
+	p := Post{BodyHTML: `<picture><source type="image/webp" srcset="a-400.webp 400w, a-1200.webp 1200w"/>` +
+		`<img src="a-200.png" srcset="a-200.png 200w, a-800.png 800w" sizes="100vw"/></picture>`}
+	if err := p.SelectImageSources(ImagePolicy{Mode: ImageLargest}); err != nil {
+		t.Fatal(err)
+	}
+	want := `<picture><img src="a-1200.webp"/></picture>`
+	if p.BodyHTML != want {
+		t.Errorf("got %s, want %s", p.BodyHTML, want)
+	}
+	if strings.Contains(p.BodyHTML, "srcset") {
+		t.Errorf("srcset left in %s", p.BodyHTML)
+	}
+}
@@ -0,0 +1,251 @@
+package lib
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// opmlOutline represents an <outline> element of an OPML file. Outlines can be nested in folders.
+type opmlOutline struct {
+	XMLUrl   string        `xml:"xmlUrl,attr"`
+	HTMLUrl  string        `xml:"htmlUrl,attr"`
+	Outlines []opmlOutline `xml:"outline"`
+}
+
+// opmlDocument represents an OPML file as exported by feed readers.
+type opmlDocument struct {
+	Body struct {
+		Outlines []opmlOutline `xml:"outline"`
+	} `xml:"body"`
+}
+
+// ImportOPML reads an OPML file and returns a subscription for every Substack feed it contains.
+// Feeds on a substack.com host are accepted directly. Feeds served at /feed on other domains, as Substack does
+// on custom domains, are only accepted once confirmed through f to be Substack publications (see resolvePublication).
+// Publications are resolved to their custom domain when they have one.
+func ImportOPML(ctx context.Context, f *Fetcher, r io.Reader) ([]Subscription, error) {
+	var doc opmlDocument
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to parse OPML: %w", err)
+	}
+
+	var subs []Subscription
+	var walk func(outlines []opmlOutline)
+	walk = func(outlines []opmlOutline) {
+		for _, o := range outlines {
+			walk(o.Outlines)
+			if o.XMLUrl == "" || !isSubstackFeed(o.XMLUrl) {
+				continue
+			}
+			// prefer the website url, which points to the custom domain when there is one
+			baseUrl, err := publicationBaseURL(o.HTMLUrl)
+			if err != nil {
+				baseUrl, err = publicationBaseURL(o.XMLUrl)
+				if err != nil {
+					continue
+				}
+			}
+			baseUrl, err = resolvePublication(ctx, f, baseUrl)
+			if err != nil {
+				f.logger().Info("skipping feed, not a Substack publication", "url", o.XMLUrl, "error", err)
+				continue
+			}
+			subs = append(subs, Subscription{Url: baseUrl})
+		}
+	}
+	walk(doc.Body.Outlines)
+
+	return dedupeSubscriptions(subs), nil
+}
+
+// ImportSubstackExport reads the subscriptions from a Substack account data export.
+// r can be either the export zip archive or the subscriptions CSV file it contains.
+// The CSV is expected to have a header row; the columns custom_domain, subdomain and
+// publication_url (or url) are used, in this order of preference, to resolve the publication.
+// Publications given by their substack.com subdomain are resolved to their custom domain through f when they have one,
+// and other domains are only kept once confirmed to be Substack publications (see resolvePublication).
+func ImportSubstackExport(ctx context.Context, f *Fetcher, r io.Reader) ([]Subscription, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		data, err = findSubscriptionsCSV(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse subscriptions CSV: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	field := func(record []string, names ...string) string {
+		for _, name := range names {
+			if i, ok := columns[name]; ok && i < len(record) && strings.TrimSpace(record[i]) != "" {
+				return strings.TrimSpace(record[i])
+			}
+		}
+		return ""
+	}
+
+	var subs []Subscription
+	for _, record := range records[1:] {
+		var raw string
+		if domain := field(record, "custom_domain"); domain != "" {
+			raw = domain
+		} else if subdomain := field(record, "subdomain"); subdomain != "" {
+			raw = subdomain + ".substack.com"
+		} else {
+			raw = field(record, "publication_url", "url")
+		}
+		if raw == "" {
+			continue
+		}
+		baseUrl, err := publicationBaseURL(raw)
+		if err != nil {
+			continue
+		}
+		baseUrl, err = resolvePublication(ctx, f, baseUrl)
+		if err != nil {
+			f.logger().Info("skipping entry, not a Substack publication", "url", raw, "error", err)
+			continue
+		}
+		subs = append(subs, Subscription{Url: baseUrl})
+	}
+
+	return dedupeSubscriptions(subs), nil
+}
+
+// findSubscriptionsCSV returns the content of the subscriptions CSV inside a Substack export zip archive.
+func findSubscriptionsCSV(data []byte) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open export archive: %w", err)
+	}
+	for _, f := range zr.File {
+		name := strings.ToLower(path.Base(f.Name))
+		if !strings.HasSuffix(name, ".csv") || !strings.Contains(name, "subscription") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return io.ReadAll(rc)
+	}
+	return nil, errors.New("no subscriptions CSV found in export archive")
+}
+
+// isSubstackFeed reports whether feedUrl may be the RSS feed of a Substack publication:
+// it is on a substack.com host, or at the /feed path Substack uses on custom domains, as many other blogs do.
+func isSubstackFeed(feedUrl string) bool {
+	u, err := url.Parse(feedUrl)
+	if err != nil {
+		return false
+	}
+	return isSubstackHost(u.Hostname()) || strings.TrimSuffix(u.Path, "/") == "/feed"
+}
+
+// isSubstackHost reports whether host is the substack.com subdomain of a publication.
+func isSubstackHost(host string) bool {
+	return strings.HasSuffix(strings.ToLower(host), ".substack.com")
+}
+
+// resolvePublication returns the base url of the publication at baseUrl, on its custom domain when it has one.
+// Its latest post is requested from its archive API through f: publications on custom domains are only
+// confirmed to be hosted by Substack that way, while the ones on substack.com are accepted even if the
+// request fails. The custom domain is taken from the url of the post.
+// The hosts probed may have nothing to do with Substack, so f shouldn't carry a session cookie and should retry briefly.
+func resolvePublication(ctx context.Context, f *Fetcher, baseUrl string) (string, error) {
+	u, err := url.Parse(baseUrl)
+	if err != nil {
+		return "", err
+	}
+	onSubstack := isSubstackHost(u.Hostname())
+
+	posts, err := fetchLatestPosts(ctx, f, baseUrl)
+	if err != nil {
+		if onSubstack {
+			return baseUrl, nil
+		}
+		return "", err
+	}
+	if !onSubstack || len(posts) == 0 {
+		return baseUrl, nil
+	}
+	canonical, err := url.Parse(posts[0].CanonicalUrl)
+	if err != nil || canonical.Host == "" || isSubstackHost(canonical.Hostname()) {
+		return baseUrl, nil
+	}
+	return publicationBaseURL(canonical.Scheme + "://" + canonical.Host)
+}
+
+// fetchLatestPosts requests the latest post of the publication at baseUrl from its archive API.
+func fetchLatestPosts(ctx context.Context, f *Fetcher, baseUrl string) ([]PostSummary, error) {
+	body, err := f.FetchURL(ctx, baseUrl+"/api/v1/archive?sort=new&offset=0&limit=1")
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var posts []PostSummary
+	if err := json.NewDecoder(body).Decode(&posts); err != nil {
+		return nil, fmt.Errorf("failed to decode archive: %w", err)
+	}
+	return posts, nil
+}
+
+// publicationBaseURL resolves any url of a publication (feed, post, bare domain) to its base url,
+// e.g. example.substack.com/feed -> https://example.substack.com
+func publicationBaseURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", errors.New("empty url")
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid publication url: %s", raw)
+	}
+	return fmt.Sprintf("%s://%s", u.Scheme, strings.ToLower(u.Host)), nil
+}
+
+// dedupeSubscriptions removes subscriptions with duplicated urls, keeping the first occurrence.
+func dedupeSubscriptions(subs []Subscription) []Subscription {
+	seen := make(map[string]struct{})
+	var deduped []Subscription
+	for _, sub := range subs {
+		if _, exists := seen[sub.Url]; exists {
+			continue
+		}
+		seen[sub.Url] = struct{}{}
+		deduped = append(deduped, sub)
+	}
+	return deduped
+}
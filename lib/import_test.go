@@ -0,0 +1,201 @@
+package lib
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// roundTripFunc is an http.RoundTripper calling itself.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (fn roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return fn(r)
+}
+
+// importFetcher returns a Fetcher answering every request, whatever its host, with the archive of a publication:
+// the latest post of the publications in latest is at the given url, and requests to the other hosts fail
+// with the status in statuses, or 404. Failed requests are not retried.
+func importFetcher(t *testing.T, latest map[string]string, statuses map[string]int) *Fetcher {
+	t.Helper()
+	f := NewFetcher(WithRatePerSecond(1000), WithBackOffConfig(&backoff.StopBackOff{}))
+	f.Client = &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		rec := httptest.NewRecorder()
+		postUrl, ok := latest[r.URL.Host]
+		switch {
+		case r.URL.Path != "/api/v1/archive":
+			http.NotFound(rec, r)
+		case ok:
+			fmt.Fprintf(rec, `[{"id":1,"slug":"latest","canonical_url":%q}]`, postUrl)
+		case statuses[r.URL.Host] != 0:
+			rec.WriteHeader(statuses[r.URL.Host])
+		default:
+			http.NotFound(rec, r)
+		}
+		return rec.Result(), nil
+	})}
+	return f
+}
+
+func TestImportOPML(t *testing.T) {
+	f := importFetcher(t, map[string]string{
+		"a.substack.com": "https://a.substack.com/p/latest",
+		"b.substack.com": "https://www.bee.com/p/latest",
+		"custom.com":     "https://custom.com/p/latest",
+	}, map[string]int{"c.substack.com": http.StatusInternalServerError})
+
+	opml := `<?xml version="1.0" encoding="UTF-8"?>
+<opml version="2.0">
+  <body>
+    <outline text="Newsletters">
+      <outline type="rss" xmlUrl="https://a.substack.com/feed" htmlUrl="https://a.substack.com"/>
+      <outline type="rss" xmlUrl="https://b.substack.com/feed"/>
+    </outline>
+    <outline type="rss" xmlUrl="https://c.substack.com/feed" htmlUrl="https://c.substack.com/"/>
+    <outline type="rss" xmlUrl="https://custom.com/feed" htmlUrl="https://Custom.com/about"/>
+    <outline type="rss" xmlUrl="https://blog.example.com/feed"/>
+    <outline type="rss" xmlUrl="https://other.example.com/rss.xml"/>
+    <outline type="rss" xmlUrl="https://a.substack.com/feed?sectionId=2"/>
+  </body>
+</opml>`
+
+	got, err := ImportOPML(context.Background(), f, strings.NewReader(opml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Subscription{
+		{Url: "https://a.substack.com"},
+		{Url: "https://www.bee.com"},
+		{Url: "https://c.substack.com"},
+		{Url: "https://custom.com"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestImportOPMLInvalid(t *testing.T) {
+	_, err := ImportOPML(context.Background(), importFetcher(t, nil, nil), strings.NewReader("<opml><body>"))
+	if err == nil || !strings.HasPrefix(err.Error(), "failed to parse OPML") {
+		t.Errorf("got error %v, want failed to parse OPML", err)
+	}
+}
+
+func TestImportSubstackExport(t *testing.T) {
+	f := importFetcher(t, map[string]string{
+		"a.substack.com":   "https://a.substack.com/p/latest",
+		"b.substack.com":   "https://www.bee.com/p/latest",
+		"news.example.com": "https://news.example.com/p/latest",
+	}, nil)
+
+	csvData := "Publication_URL,Subdomain,Custom_Domain,Email_Disabled\n" +
+		"https://a.substack.com/,a,,false\n" +
+		",b,,false\n" +
+		"https://ignored.substack.com,ignored,news.example.com,false\n" +
+		"https://blog.example.com,,,false\n" +
+		",,,false\n" +
+		"https://a.substack.com,a,,true\n"
+	want := []Subscription{
+		{Url: "https://a.substack.com"},
+		{Url: "https://www.bee.com"},
+		{Url: "https://news.example.com"},
+	}
+
+	var zipData bytes.Buffer
+	zw := zip.NewWriter(&zipData)
+	for name, content := range map[string]string{
+		"export/posts.csv":                         "post_id,title\n1,Hello\n",
+		"export/email_list.subscriptions.2024.csv": csvData,
+	} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"csv", []byte(csvData)},
+		{"zip", zipData.Bytes()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ImportSubstackExport(context.Background(), f, bytes.NewReader(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestImportSubstackExportWithoutCSV(t *testing.T) {
+	var zipData bytes.Buffer
+	zw := zip.NewWriter(&zipData)
+	w, _ := zw.Create("export/posts.csv")
+	w.Write([]byte("post_id\n1\n"))
+	zw.Close()
+
+	_, err := ImportSubstackExport(context.Background(), importFetcher(t, nil, nil), &zipData)
+	if err == nil || !strings.Contains(err.Error(), "no subscriptions CSV") {
+		t.Errorf("got error %v, want no subscriptions CSV", err)
+	}
+}
+
+func TestIsSubstackFeed(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://example.substack.com/feed", true},
+		{"https://example.substack.com/podcast.xml", true},
+		{"https://EXAMPLE.Substack.com/feed", true},
+		{"https://www.example.com/feed", true},
+		{"https://www.example.com/feed/", true},
+		{"https://www.example.com/rss.xml", false},
+		{"https://substack.com.example.com/rss", false},
+		{"://bad", false},
+	}
+	for _, tt := range tests {
+		if got := isSubstackFeed(tt.url); got != tt.want {
+			t.Errorf("isSubstackFeed(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestPublicationBaseURL(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{"https://example.substack.com/feed", "https://example.substack.com", false},
+		{"example.substack.com", "https://example.substack.com", false},
+		{"  http://Example.com/p/post?x=1  ", "http://example.com", false},
+		{"https://example.com:8443/", "https://example.com:8443", false},
+		{"", "", true},
+		{"https://", "", true},
+	}
+	for _, tt := range tests {
+		got, err := publicationBaseURL(tt.raw)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("publicationBaseURL(%q) = %q, %v, want %q (error: %v)", tt.raw, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
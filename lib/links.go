@@ -0,0 +1,197 @@
+package lib
+
+import (
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// postLinkURL matches the absolute URLs in Markdown and text files, up to the end of a Markdown link,
+// along with the angle brackets around Markdown autolinks.
+var postLinkURL = regexp.MustCompile(`<?https?://[^\s()<>"]+>?`)
+
+// LinkResolver resolves links to the posts of an archive to the local files they were downloaded to,
+// whether they use the substack.com subdomain or the custom domain of the publication.
+type LinkResolver struct {
+	dir string
+	// hosts maps the domains of a publication to the one identifying it.
+	hosts map[string]string
+	// posts maps the keys of the posts (see postKey) to their paths, relative to dir.
+	posts map[string]string
+	// paths are the paths of the post files, relative to dir.
+	paths []string
+}
+
+// NewLinkResolver returns the resolver of the links to the posts recorded in m.
+// The domains of each publication are the ones recorded with RecordPublication, besides the one of its url.
+func NewLinkResolver(m *Manifest) *LinkResolver {
+	r := &LinkResolver{
+		dir:   m.Dir(),
+		hosts: make(map[string]string),
+		posts: make(map[string]string),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, state := range m.Publications {
+		if len(state.Hosts) == 0 {
+			continue
+		}
+		hosts := slices.Clone(state.Hosts)
+		if u, err := url.Parse(state.Url); err == nil && u.Hostname() != "" {
+			hosts = append(hosts, u.Hostname())
+		}
+		for _, host := range hosts {
+			r.hosts[normalizeHost(host)] = normalizeHost(state.Hosts[0])
+		}
+	}
+	for postUrl, rec := range m.Posts {
+		if rec.Path == "" {
+			continue
+		}
+		if key, _, ok := r.postKey(postUrl); ok {
+			r.posts[key] = rec.Path
+			r.paths = append(r.paths, rec.Path)
+		}
+	}
+	return r
+}
+
+// normalizeHost returns host in lower case, without a www. prefix.
+func normalizeHost(host string) string {
+	return strings.TrimPrefix(strings.ToLower(host), "www.")
+}
+
+// postKey returns the key identifying the post link points to, made of the main domain of its publication and its slug,
+// and the fragment of the link. Links to open.substack.com/pub/name/p/slug are resolved to the name.substack.com domain.
+func (r *LinkResolver) postKey(link string) (key string, fragment string, ok bool) {
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", "", false
+	}
+	host := normalizeHost(u.Hostname())
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if host == "open.substack.com" && len(parts) == 4 && parts[0] == "pub" {
+		host = strings.ToLower(parts[1]) + ".substack.com"
+		parts = parts[2:]
+	}
+	if len(parts) != 2 || parts[0] != "p" || parts[1] == "" {
+		return "", "", false
+	}
+	if main, ok := r.hosts[host]; ok {
+		host = main
+	}
+	return host + "/" + parts[1], u.EscapedFragment(), true
+}
+
+// Resolve returns the relative URL of the local file of the post link points to, from the folder fromDir,
+// keeping the fragment of the link. It returns false if the post isn't archived.
+func (r *LinkResolver) Resolve(link string, fromDir string) (string, bool) {
+	key, fragment, ok := r.postKey(link)
+	if !ok {
+		return "", false
+	}
+	path, ok := r.posts[key]
+	if !ok {
+		return "", false
+	}
+	rel, err := filepath.Rel(fromDir, filepath.Join(r.dir, filepath.FromSlash(path)))
+	if err != nil {
+		return "", false
+	}
+	target := localURL(filepath.ToSlash(rel))
+	if fragment != "" {
+		target += "#" + fragment
+	}
+	return target, true
+}
+
+// RewriteLinks rewrites the links to archived posts in all the post files of the archive, in any format,
+// to relative paths of their local files. Other links are left untouched.
+// It returns the number of files changed.
+func (r *LinkResolver) RewriteLinks() (int, error) {
+	changed := 0
+	for _, path := range r.paths {
+		ok, err := r.RewriteFile(filepath.Join(r.dir, filepath.FromSlash(path)))
+		if err != nil {
+			return changed, err
+		}
+		if ok {
+			changed++
+		}
+	}
+	return changed, nil
+}
+
+// RewriteFile rewrites the links to archived posts in the post file at path, written in the format of its extension
+// (html, md, or txt). It reports whether the file was changed. Missing files are skipped.
+func (r *LinkResolver) RewriteFile(path string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	fromDir := filepath.Dir(path)
+
+	content := string(data)
+	changed := false
+	if filepath.Ext(path) == ".html" {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+		if err != nil {
+			return false, err
+		}
+		doc.Find("a[href]").Each(func(i int, s *goquery.Selection) {
+			if target, ok := r.Resolve(strings.TrimSpace(s.AttrOr("href", "")), fromDir); ok {
+				s.SetAttr("href", target)
+				changed = true
+			}
+		})
+		if changed {
+			if content, err = doc.Find("body").Html(); err != nil {
+				return false, err
+			}
+		}
+	} else {
+		markdown := filepath.Ext(path) == ".md"
+		content = postLinkURL.ReplaceAllStringFunc(content, func(match string) string {
+			prefix, link, suffix := "", match, ""
+			if strings.HasPrefix(link, "<") {
+				prefix, link = "<", link[1:]
+			}
+			if strings.HasSuffix(link, ">") {
+				link, suffix = link[:len(link)-1], ">"
+			} else {
+				// punctuation ending a sentence isn't part of a bare link
+				trimmed := strings.TrimRight(link, ".,;:!?)")
+				link, suffix = trimmed, link[len(trimmed):]
+			}
+			target, ok := r.Resolve(link, fromDir)
+			if !ok {
+				return match
+			}
+			changed = true
+			if markdown && prefix == "<" && suffix == ">" {
+				// relative paths can't be autolinks
+				return "[" + target + "](" + target + ")"
+			}
+			return prefix + target + suffix
+		})
+	}
+	if !changed {
+		return false, nil
+	}
+
+	return true, WriteFileAtomic(path, func(w io.Writer) error {
+		_, err := io.WriteString(w, content)
+		return err
+	})
+}
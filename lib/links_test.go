@@ -0,0 +1,119 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestResolver returns the resolver of an archive in a temporary folder holding the posts "first" (a.substack.com,
+// also served from www.example.com) and "second" (b.substack.com), in the a and b folders.
+func newTestResolver(t *testing.T) (*LinkResolver, string) {
+	t.Helper()
+	dir := t.TempDir()
+	m, err := LoadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.RecordPublication("https://www.example.com", Publication{Subdomain: "a", CustomDomain: "www.example.com"})
+	m.RecordPublication("https://b.substack.com", Publication{Subdomain: "b"})
+	m.RecordPost("https://www.example.com/p/first", Post{}, filepath.Join(dir, "a", "first post.html"))
+	m.RecordPost("https://b.substack.com/p/second", Post{}, filepath.Join(dir, "b", "second.md"))
+	return NewLinkResolver(m), dir
+}
+
+func TestLinkResolverResolve(t *testing.T) {
+	r, dir := newTestResolver(t)
+	fromDir := filepath.Join(dir, "b")
+
+	tests := []struct {
+		link string
+		want string
+		ok   bool
+	}{
+		{"https://www.example.com/p/first", "../a/first%20post.html", true},
+		{"https://example.com/p/first/", "../a/first%20post.html", true},
+		{"http://A.substack.com/p/first?utm_source=x#footnote-1", "../a/first%20post.html#footnote-1", true},
+		{"https://open.substack.com/pub/a/p/first", "../a/first%20post.html", true},
+		{"https://b.substack.com/p/second", "second.md", true},
+		{"https://b.substack.com/p/missing", "", false},
+		{"https://c.substack.com/p/first", "", false},
+		{"https://b.substack.com/archive", "", false},
+		{"mailto:someone@example.com", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := r.Resolve(tt.link, fromDir)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Resolve(%q) = %q, %v, want %q, %v", tt.link, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestLinkResolverRewriteFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    string
+	}{
+		{
+			name:    "html",
+			file:    "post.html",
+			content: `<p>See <a href="https://a.substack.com/p/first#x">the first post</a> and <a href="https://example.org/p/first">another</a>.</p>`,
+			want:    `<p>See <a href="../a/first%20post.html#x">the first post</a> and <a href="https://example.org/p/first">another</a>.</p>`,
+		},
+		{
+			name:    "markdown",
+			file:    "post.md",
+			content: "See [the first post](https://a.substack.com/p/first), <https://b.substack.com/p/second> and https://www.example.com/p/first.\n",
+			want:    "See [the first post](../a/first%20post.html), [../b/second.md](../b/second.md) and ../a/first%20post.html.\n",
+		},
+		{
+			name:    "text with punctuation",
+			file:    "post.txt",
+			content: "Read https://b.substack.com/p/second: it follows (https://a.substack.com/p/first)! Or https://b.substack.com/p/second?\n",
+			want:    "Read ../b/second.md: it follows (../a/first%20post.html)! Or ../b/second.md?\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, dir := newTestResolver(t)
+			path := filepath.Join(dir, "c", tt.file)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			changed, err := r.RewriteFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !changed {
+				t.Errorf("file not changed")
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("got %q, want %q", data, tt.want)
+			}
+		})
+	}
+
+	t.Run("no archived links", func(t *testing.T) {
+		r, dir := newTestResolver(t)
+		path := filepath.Join(dir, "note.md")
+		content := "See https://example.org/p/first.\n"
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if changed, err := r.RewriteFile(path); changed || err != nil {
+			t.Errorf("got %v, %v, want the file unchanged", changed, err)
+		}
+		if changed, err := r.RewriteFile(filepath.Join(dir, "missing.md")); changed || err != nil {
+			t.Errorf("missing file: got %v, %v, want it skipped", changed, err)
+		}
+	})
+}
@@ -0,0 +1,12 @@
+package lib
+
+import (
+	"io"
+	"log/slog"
+)
+
+// discardLogger returns a logger dropping every record, used by default:
+// the library never writes anything on its own, logs only go to the logger it is given.
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
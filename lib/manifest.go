@@ -0,0 +1,263 @@
+package lib
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ManifestFileName is the name of the manifest file kept at the root of an archive.
+const ManifestFileName = "manifest.json"
+
+// Manifest records the state of an archive: the publications it tracks and the posts downloaded into it.
+// It is safe for concurrent use.
+type Manifest struct {
+	Publications map[string]*PublicationState `json:"publications"`
+	Posts        map[string]*PostRecord       `json:"posts"`
+	MediaFiles   map[string]*MediaRecord      `json:"media,omitempty"`
+
+	path string
+	mu   sync.Mutex
+}
+
+// PublicationState holds the tracking state of a publication.
+type PublicationState struct {
+	Url         string    `json:"url"`
+	LastChecked time.Time `json:"last_checked"`
+	// Hosts are the domains the publication is served from, its substack.com subdomain and custom domain.
+	Hosts []string `json:"hosts,omitempty"`
+}
+
+// PostRecord describes a post that has been downloaded into the archive.
+type PostRecord struct {
+	Url          string               `json:"url"`
+	Slug         string               `json:"slug"`
+	Title        string               `json:"title"`
+	PostDate     string               `json:"post_date"`
+	Path         string               `json:"path"`
+	DownloadedAt time.Time            `json:"downloaded_at"`
+	Media        map[string]MediaFile `json:"media,omitempty"`
+	FailedMedia  []string             `json:"failed_media,omitempty"`
+	// Incomplete is set while some media files of the post, listed in FailedMedia, failed to download:
+	// the post file was written, but the post is downloaded again until all its media files are.
+	Incomplete bool `json:"incomplete,omitempty"`
+}
+
+// IsComplete reports whether the post was recorded with all its media files.
+func (r *PostRecord) IsComplete() bool {
+	return !r.Incomplete && len(r.FailedMedia) == 0
+}
+
+// MediaRecord describes a media file saved in the shared media store of the archive.
+type MediaRecord struct {
+	Path     string `json:"path"`
+	Hash     string `json:"sha256"`
+	MimeType string `json:"mime_type,omitempty"`
+}
+
+// LoadManifest reads the manifest stored in the given archive folder.
+// If no manifest exists yet, an empty one is returned.
+func LoadManifest(folder string) (*Manifest, error) {
+	m := &Manifest{
+		Publications: make(map[string]*PublicationState),
+		Posts:        make(map[string]*PostRecord),
+		MediaFiles:   make(map[string]*MediaRecord),
+		path:         filepath.Join(folder, ManifestFileName),
+	}
+
+	data, err := os.ReadFile(m.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	if m.Publications == nil {
+		m.Publications = make(map[string]*PublicationState)
+	}
+	if m.Posts == nil {
+		m.Posts = make(map[string]*PostRecord)
+	}
+	if m.MediaFiles == nil {
+		m.MediaFiles = make(map[string]*MediaRecord)
+	}
+
+	return m, nil
+}
+
+// Dir returns the archive folder the manifest belongs to.
+func (m *Manifest) Dir() string {
+	return filepath.Dir(m.path)
+}
+
+// Save atomically writes the manifest back to its archive folder.
+func (m *Manifest) Save() error {
+	m.mu.Lock()
+	data, err := json.MarshalIndent(m, "", "  ")
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return WriteFileAtomic(m.path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// MarkChecked records that the publication at pubUrl was checked for new posts at the given time,
+// which should be when listing its posts started. It should only be called once all its new posts
+// have been downloaded, so that a later check relative to it doesn't miss the ones that failed.
+func (m *Manifest) MarkChecked(pubUrl string, at time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.publication(pubUrl).LastChecked = at
+}
+
+// RecordPublication records the domains pub is served from, so that links to its posts can be resolved
+// whichever one they use.
+func (m *Manifest) RecordPublication(pubUrl string, pub Publication) {
+	var hosts []string
+	if pub.Subdomain != "" {
+		hosts = append(hosts, pub.Subdomain+".substack.com")
+	}
+	if pub.CustomDomain != "" {
+		hosts = append(hosts, pub.CustomDomain)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.publication(pubUrl).Hosts = hosts
+}
+
+// publication returns the state of the publication at pubUrl, adding it if needed. m.mu must be held.
+func (m *Manifest) publication(pubUrl string) *PublicationState {
+	state, ok := m.Publications[pubUrl]
+	if !ok {
+		state = &PublicationState{Url: pubUrl}
+		m.Publications[pubUrl] = state
+	}
+	return state
+}
+
+// LastChecked returns when the publication at pubUrl was last checked for new posts, or the zero time if never.
+func (m *Manifest) LastChecked(pubUrl string) time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if state, ok := m.Publications[pubUrl]; ok {
+		return state.LastChecked
+	}
+	return time.Time{}
+}
+
+// HasPost reports whether the post at postUrl has already been recorded.
+func (m *Manifest) HasPost(postUrl string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, exists := m.Posts[postUrl]
+	return exists
+}
+
+// HasCompletePost reports whether the post at postUrl has already been recorded with all its media files.
+func (m *Manifest) HasCompletePost(postUrl string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec, exists := m.Posts[postUrl]
+	return exists && rec.IsComplete()
+}
+
+// IncompletePosts returns the urls of the posts written in folder (or its subfolders) whose media files
+// failed to download, sorted. Recording them again once downloaded clears their failed media.
+func (m *Manifest) IncompletePosts(folder string) []string {
+	prefix := m.relPath(folder) + "/"
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var urls []string
+	for postUrl, rec := range m.Posts {
+		if !rec.IsComplete() && (prefix == "./" || strings.HasPrefix(rec.Path, prefix)) {
+			urls = append(urls, postUrl)
+		}
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+// RecordPost records that post has been written to path, along with its media files.
+// A post whose media files failed to download is recorded as incomplete, with the urls of the failed files.
+// Paths are stored relative to the archive folder when possible.
+func (m *Manifest) RecordPost(postUrl string, post Post, path string) {
+	var media map[string]MediaFile
+	if len(post.Media) > 0 {
+		media = make(map[string]MediaFile, len(post.Media))
+		for mediaUrl, file := range post.Media {
+			file.Path = m.relPath(filepath.Join(filepath.Dir(path), filepath.FromSlash(file.Path)))
+			media[mediaUrl] = file
+		}
+	}
+	path = m.relPath(path)
+
+	var failedMedia []string
+	for mediaUrl := range post.MediaErrors {
+		failedMedia = append(failedMedia, mediaUrl)
+	}
+	sort.Strings(failedMedia)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Posts[postUrl] = &PostRecord{
+		Url:          postUrl,
+		Slug:         post.Slug,
+		Title:        post.Title,
+		PostDate:     post.PostDate,
+		Path:         path,
+		DownloadedAt: time.Now(),
+		Media:        media,
+		FailedMedia:  failedMedia,
+		Incomplete:   len(failedMedia) > 0,
+	}
+}
+
+// relPath returns path relative to the archive folder, with forward slashes, when possible.
+func (m *Manifest) relPath(path string) string {
+	if rel, err := filepath.Rel(m.Dir(), path); err == nil {
+		path = rel
+	}
+	return filepath.ToSlash(path)
+}
+
+// Media returns the record of the media file downloaded from mediaUrl, if any.
+func (m *Manifest) Media(mediaUrl string) (MediaRecord, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec, exists := m.MediaFiles[mediaUrl]
+	if !exists {
+		return MediaRecord{}, false
+	}
+	return *rec, true
+}
+
+// RecordMedia records that the media file at mediaUrl has been saved in the shared media store.
+func (m *Manifest) RecordMedia(mediaUrl string, rec MediaRecord) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.MediaFiles[mediaUrl] = &rec
+}
@@ -0,0 +1,121 @@
+package lib
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestManifestSaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	m, err := LoadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Dir() != dir || len(m.Posts) != 0 || m.LastChecked("https://a.substack.com") != (time.Time{}) {
+		t.Fatalf("got %+v, want an empty manifest in %s", m, dir)
+	}
+
+	checked := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	m.MarkChecked("https://a.substack.com", checked)
+	m.RecordPublication("https://a.substack.com", Publication{Subdomain: "a", CustomDomain: "www.example.com"})
+	m.RecordPost("https://a.substack.com/p/first", Post{Slug: "first", Title: "First"}, filepath.Join(dir, "a", "first.html"))
+	m.RecordMedia("https://example.com/a.png", MediaRecord{Path: "media/ab/abc.png", Hash: "abc", MimeType: "image/png"})
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded.LastChecked("https://a.substack.com"); !got.Equal(checked) {
+		t.Errorf("got last checked %v, want %v", got, checked)
+	}
+	if got, want := loaded.Publications["https://a.substack.com"].Hosts, []string{"a.substack.com", "www.example.com"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got hosts %v, want %v", got, want)
+	}
+	if rec := loaded.Posts["https://a.substack.com/p/first"]; rec == nil || rec.Path != "a/first.html" || rec.Title != "First" {
+		t.Errorf("got post record %+v, want a/first.html", rec)
+	}
+	if rec, ok := loaded.Media("https://example.com/a.png"); !ok || rec.Hash != "abc" {
+		t.Errorf("got media record %+v, %v, want abc", rec, ok)
+	}
+	if _, ok := loaded.Media("https://example.com/b.png"); ok {
+		t.Errorf("got a record for a media file never stored")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, ManifestFileName), []byte("not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadManifest(dir); err == nil {
+		t.Errorf("got no error for an invalid manifest")
+	}
+}
+
+func TestManifestRecordPost(t *testing.T) {
+	dir := t.TempDir()
+	m, err := LoadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postUrl := "https://a.substack.com/p/first"
+	post := Post{
+		Media: map[string]MediaFile{"https://example.com/a.png": {Path: "first_files/a.png", MimeType: "image/png"}},
+		MediaErrors: MediaErrors{
+			"https://example.com/c.png": errors.New("not found"),
+			"https://example.com/b.png": errors.New("not found"),
+		},
+	}
+	m.RecordPost(postUrl, post, filepath.Join(dir, "a", "first.html"))
+
+	rec := m.Posts[postUrl]
+	if got, want := rec.Media["https://example.com/a.png"].Path, "a/first_files/a.png"; got != want {
+		t.Errorf("got media path %q, want %q", got, want)
+	}
+	if want := []string{"https://example.com/b.png", "https://example.com/c.png"}; !reflect.DeepEqual(rec.FailedMedia, want) {
+		t.Errorf("got failed media %v, want %v", rec.FailedMedia, want)
+	}
+	if !m.HasPost(postUrl) || m.HasCompletePost(postUrl) {
+		t.Errorf("got HasPost %v and HasCompletePost %v, want an incomplete post", m.HasPost(postUrl), m.HasCompletePost(postUrl))
+	}
+
+	// recording the post again once its media are downloaded completes it
+	m.RecordPost(postUrl, Post{}, filepath.Join(dir, "a", "first.html"))
+	if !m.HasCompletePost(postUrl) {
+		t.Errorf("post still incomplete after recording it again")
+	}
+	if m.HasPost("https://a.substack.com/p/other") {
+		t.Errorf("got HasPost for a post never recorded")
+	}
+}
+
+func TestManifestIncompletePosts(t *testing.T) {
+	dir := t.TempDir()
+	m, err := LoadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := Post{MediaErrors: MediaErrors{"https://example.com/a.png": errors.New("not found")}}
+	m.RecordPost("https://a.substack.com/p/2", failed, filepath.Join(dir, "a", "2.html"))
+	m.RecordPost("https://a.substack.com/p/1", failed, filepath.Join(dir, "a", "1.html"))
+	m.RecordPost("https://a.substack.com/p/3", Post{}, filepath.Join(dir, "a", "3.html"))
+	m.RecordPost("https://ab.substack.com/p/1", failed, filepath.Join(dir, "ab", "1.html"))
+
+	tests := []struct {
+		folder string
+		want   []string
+	}{
+		{dir, []string{"https://a.substack.com/p/1", "https://a.substack.com/p/2", "https://ab.substack.com/p/1"}},
+		{filepath.Join(dir, "a"), []string{"https://a.substack.com/p/1", "https://a.substack.com/p/2"}},
+		{filepath.Join(dir, "c"), nil},
+	}
+	for _, tt := range tests {
+		if got := m.IncompletePosts(tt.folder); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("IncompletePosts(%q) = %v, want %v", tt.folder, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package lib
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// DefaultMediaConcurrency defines the default number of media files of a post downloaded concurrently.
+const DefaultMediaConcurrency = 2
+
+// MediaFile describes a downloaded media file.
+type MediaFile struct {
+	Path     string `json:"path"`
+	MimeType string `json:"mime_type,omitempty"`
+}
+
+// MediaErrors aggregates the errors of the media files that failed to download, keyed by media URL.
+type MediaErrors map[string]error
+
+// Error returns a message listing every failed media file with its error.
+func (e MediaErrors) Error() string {
+	urls := make([]string, 0, len(e))
+	for u := range e {
+		urls = append(urls, u)
+	}
+	sort.Strings(urls)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "failed to download %d media files:", len(e))
+	for _, u := range urls {
+		fmt.Fprintf(&sb, "\n  %s: %s", u, e[u])
+	}
+	return sb.String()
+}
+
+// ExtractMedia returns the URLs of the media files referenced in the Post's HTML body.
+func (p *Post) ExtractMedia() ([]string, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(p.BodyHTML))
+	if err != nil {
+		return nil, err
+	}
+
+	var mediaUrls []string
+	doc.Find("img").Each(func(i int, s *goquery.Selection) {
+		if src, exists := s.Attr("src"); exists {
+			mediaUrls = append(mediaUrls, src)
+		}
+	})
+
+	return mediaUrls, nil
+}
+
+// DownloadMedia downloads the media files at the given urls into outputFolder through the Fetcher f,
+// so they share its proxy, cookie, rate limit and retries. Up to concurrency files are downloaded at once.
+// It returns a map from each downloaded media url to its file (whose path is relative to outputFolder),
+// and the errors of the media files that couldn't be downloaded, if any.
+// Files whose URLs end with the same name (e.g. a/image.png and b/image.png) are told apart by a short hash of their URL.
+// If ctx is cancelled, the downloads in progress are aborted without leaving partial files.
+func DownloadMedia(ctx context.Context, f *Fetcher, urls []string, outputFolder string, concurrency int) (map[string]MediaFile, MediaErrors) {
+	suffixes := mediaNameSuffixes(urls)
+	return downloadAll(urls, concurrency, func(mediaUrl string) (MediaFile, error) {
+		return downloadMediaFile(ctx, f, mediaUrl, outputFolder, suffixes[mediaUrl])
+	})
+}
+
+// mediaNameSuffixes returns a suffix made of a short hash of the URL for each of the urls whose file name would be
+// the same as the one of another url, whatever their extensions, which depend on the MIME types of the files.
+// The suffixes don't depend on the order in which files are downloaded, so files keep their names across runs.
+func mediaNameSuffixes(urls []string) map[string]string {
+	byName := make(map[string][]string)
+	for _, mediaUrl := range urls {
+		name := strings.ToLower(mediaFileName(mediaUrl, ""))
+		name = strings.TrimSuffix(name, path.Ext(name))
+		if !slices.Contains(byName[name], mediaUrl) {
+			byName[name] = append(byName[name], mediaUrl)
+		}
+	}
+
+	suffixes := make(map[string]string)
+	for _, group := range byName {
+		if len(group) < 2 {
+			continue
+		}
+		for _, mediaUrl := range group {
+			sum := sha256.Sum256([]byte(mediaUrl))
+			suffixes[mediaUrl] = "-" + hex.EncodeToString(sum[:4])
+		}
+	}
+	return suffixes
+}
+
+// downloadAll calls download for every distinct url, with up to concurrency calls at once.
+// It returns a map from each url to the file returned by download and the errors of the failed calls, if any.
+func downloadAll(urls []string, concurrency int, download func(mediaUrl string) (MediaFile, error)) (map[string]MediaFile, MediaErrors) {
+	downloadedFiles := make(map[string]MediaFile)
+	mediaErrs := make(MediaErrors)
+	if concurrency <= 0 {
+		concurrency = DefaultMediaConcurrency
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	seen := make(map[string]struct{})
+	for _, mediaUrl := range urls {
+		if _, exists := seen[mediaUrl]; exists {
+			continue
+		}
+		seen[mediaUrl] = struct{}{}
+
+		wg.Add(1)
+		go func(mediaUrl string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			file, err := download(mediaUrl)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				mediaErrs[mediaUrl] = err
+				return
+			}
+			downloadedFiles[mediaUrl] = file
+		}(mediaUrl)
+	}
+	wg.Wait()
+
+	if len(mediaErrs) == 0 {
+		return downloadedFiles, nil
+	}
+	return downloadedFiles, mediaErrs
+}
+
+// downloadMediaFile atomically downloads the media file at mediaUrl into outputFolder, adding suffix to its name.
+// The file extension is determined from the content of the file and its Content-Type.
+func downloadMediaFile(ctx context.Context, f *Fetcher, mediaUrl string, outputFolder string, suffix string) (MediaFile, error) {
+	body, header, err := f.FetchURLWithHeader(ctx, mediaUrl)
+	if err != nil {
+		return MediaFile{}, fmt.Errorf("failed to download media: %w", err)
+	}
+	defer body.Close()
+
+	r := bufio.NewReaderSize(body, sniffLen)
+	head, _ := r.Peek(sniffLen) // a shorter head is fine for small files
+	mimeType := detectMediaType(header.Get("Content-Type"), head)
+	fileName := mediaFileName(mediaUrl, mimeType)
+	if suffix != "" {
+		ext := path.Ext(fileName)
+		fileName = strings.TrimSuffix(fileName, ext) + suffix + ext
+	}
+
+	err = WriteFileAtomic(filepath.Join(outputFolder, fileName), func(w io.Writer) error {
+		_, err := io.Copy(w, r)
+		return err
+	})
+	if err != nil {
+		return MediaFile{}, fmt.Errorf("failed to save media file: %w", err)
+	}
+	return MediaFile{Path: fileName, MimeType: mimeType}, nil
+}
+
+// cssURL matches the url() references of inline styles, capturing the (possibly quoted) URL.
+var cssURL = regexp.MustCompile(`url\(\s*(?:'([^']*)'|"([^"]*)"|([^'")\s]*))\s*\)`)
+
+// ReplaceMediaURLs replaces the original media URLs referenced in the Post's HTML body with the paths of the downloaded files.
+// The body is rewritten as an HTML tree, so URLs are matched whole, whatever their escaping in the source
+// (e.g. &amp; in query strings), in src, href, poster, srcset and inline style attributes alike.
+// Links to the original asset behind a downloaded Substack CDN image (as wrapped around images) point to the local file too.
+func (p *Post) ReplaceMediaURLs(downloadedFiles map[string]MediaFile) error {
+	if len(downloadedFiles) == 0 {
+		return nil
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(p.BodyHTML))
+	if err != nil {
+		return err
+	}
+
+	rewriteURLs(doc, func(mediaUrl string) (string, bool) {
+		if file, ok := downloadedFiles[mediaUrl]; ok {
+			return localURL(file.Path), true
+		}
+		if original, ok := substackOriginalURL(mediaUrl); ok {
+			if file, ok := downloadedFiles[original]; ok {
+				return localURL(file.Path), true
+			}
+		}
+		return "", false
+	})
+
+	body, err := doc.Find("body").Html()
+	if err != nil {
+		return err
+	}
+	p.BodyHTML = body
+	return nil
+}
+
+// rewriteURLs replaces every URL of the document found in src, href, poster, srcset and inline style attributes
+// for which rewrite returns true. Other URLs are left untouched.
+func rewriteURLs(doc *goquery.Document, rewrite func(string) (string, bool)) {
+	for _, attr := range []string{"src", "href", "poster"} {
+		doc.Find("[" + attr + "]").Each(func(i int, s *goquery.Selection) {
+			if replaced, ok := rewrite(strings.TrimSpace(s.AttrOr(attr, ""))); ok {
+				s.SetAttr(attr, replaced)
+			}
+		})
+	}
+
+	doc.Find("[srcset]").Each(func(i int, s *goquery.Selection) {
+		candidates := splitSrcset(s.AttrOr("srcset", ""))
+		changed := false
+		for i, c := range candidates {
+			if replaced, ok := rewrite(c.url); ok {
+				candidates[i].url = replaced
+				changed = true
+			}
+		}
+		if changed {
+			s.SetAttr("srcset", joinSrcset(candidates))
+		}
+	})
+
+	doc.Find("[style]").Each(func(i int, s *goquery.Selection) {
+		style := s.AttrOr("style", "")
+		rewritten := cssURL.ReplaceAllStringFunc(style, func(ref string) string {
+			m := cssURL.FindStringSubmatch(ref)
+			if replaced, ok := rewrite(m[1] + m[2] + m[3]); ok {
+				return "url('" + replaced + "')"
+			}
+			return ref
+		})
+		if rewritten != style {
+			s.SetAttr("style", rewritten)
+		}
+	})
+}
+
+// localURL returns the relative URL referencing the local file at filePath (a slash-separated path),
+// with the characters that aren't allowed in URLs, such as spaces, escaped.
+func localURL(filePath string) string {
+	return (&url.URL{Path: filePath}).String()
+}
@@ -0,0 +1,128 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestMediaNameSuffixes(t *testing.T) {
+	urls := []string{
+		"https://example.com/a/image.png",
+		"https://example.com/b/image.jpg",
+		"https://example.com/c/IMAGE",
+		"https://example.com/other.png",
+		"https://example.com/a/image.png",
+	}
+	suffixes := mediaNameSuffixes(urls)
+
+	hashSuffix := regexp.MustCompile(`^-[0-9a-f]{8}$`)
+	seen := make(map[string]bool)
+	for _, u := range urls[:3] {
+		s := suffixes[u]
+		if !hashSuffix.MatchString(s) {
+			t.Errorf("%s: got suffix %q, want a short hash", u, s)
+		}
+		if seen[s] {
+			t.Errorf("%s: suffix %q already used", u, s)
+		}
+		seen[s] = true
+	}
+	if s, ok := suffixes[urls[3]]; ok {
+		t.Errorf("%s: got suffix %q, want none", urls[3], s)
+	}
+
+	// the suffix of a url doesn't depend on the other urls or their order
+	again := mediaNameSuffixes([]string{urls[1], urls[0]})
+	if again[urls[0]] != suffixes[urls[0]] || again[urls[1]] != suffixes[urls[1]] {
+		t.Errorf("suffixes changed with the order of urls: %v, then %v", suffixes, again)
+	}
+}
+
+func TestDownloadMediaSameName(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing.png" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(append(png, r.URL.Path...))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	urls := []string{srv.URL + "/a/image.png", srv.URL + "/b/image.png", srv.URL + "/photo", srv.URL + "/missing.png"}
+	files, errs := DownloadMedia(context.Background(), NewFetcher(WithRatePerSecond(1000)), urls, dir, 2)
+
+	if len(errs) != 1 || errs[urls[3]] == nil {
+		t.Errorf("got errors %v, want one for %s", errs, urls[3])
+	}
+	if len(files) != 3 {
+		t.Fatalf("got %d files, want 3", len(files))
+	}
+	if files[urls[0]].Path == files[urls[1]].Path {
+		t.Errorf("%s and %s both saved as %s", urls[0], urls[1], files[urls[0]].Path)
+	}
+	if got := files[urls[2]]; got != (MediaFile{Path: "photo.png", MimeType: "image/png"}) {
+		t.Errorf("got %+v, want photo.png", got)
+	}
+	for u, f := range files {
+		data, err := os.ReadFile(filepath.Join(dir, f.Path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := string(png) + u[len(srv.URL):]; string(data) != want {
+			t.Errorf("%s: got content %q, want %q", f.Path, data, want)
+		}
+	}
+}
+
+func TestReplaceMediaURLs(t *testing.T) {
+	files := map[string]MediaFile{
+		"https://example.com/a.png":         {Path: "a.png", MimeType: "image/png"},
+		"https://example.com/b.png?x=1&y=2": {Path: "b.png", MimeType: "image/png"},
+		"https://example.com/c d.jpg":       {Path: "../media/ab/c d.jpg", MimeType: "image/jpeg"},
+		"https://example.com/v.mp4":         {Path: "v.mp4", MimeType: "video/mp4"},
+		cdnOriginal:                         {Path: "orig.png", MimeType: "image/png"},
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"src", `<img src="https://example.com/a.png"/>`, `<img src="a.png"/>`},
+		{"escaped query", `<img src="https://example.com/b.png?x=1&amp;y=2"/>`, `<img src="b.png"/>`},
+		{"relative path with a space", `<img src="https://example.com/c d.jpg"/>`, `<img src="../media/ab/c%20d.jpg"/>`},
+		{"href and poster", `<a href="https://example.com/a.png"><video poster="https://example.com/a.png" src="https://example.com/v.mp4"></video></a>`,
+			`<a href="a.png"><video poster="a.png" src="v.mp4"></video></a>`},
+		{"srcset", `<img srcset="https://example.com/a.png 1x, https://example.com/other.png 2x"/>`, `<img srcset="a.png 1x, https://example.com/other.png 2x"/>`},
+		{"style", `<div style="background: url(&#34;https://example.com/a.png&#34;) no-repeat"></div>`, `<div style="background: url(&#39;a.png&#39;) no-repeat"></div>`},
+		{"substack cdn", `<a href="` + cdnURL("w_1456,c_limit") + `"><img src="` + cdnOriginal + `"/></a>`, `<a href="orig.png"><img src="orig.png"/></a>`},
+		{"prefix of a url", `<img src="https://example.com/a.png.bak"/>`, `<img src="https://example.com/a.png.bak"/>`},
+		{"text", `<p>https://example.com/a.png</p>`, `<p>https://example.com/a.png</p>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := Post{BodyHTML: tt.body}
+			if err := p.ReplaceMediaURLs(files); err != nil {
+				t.Fatal(err)
+			}
+			if p.BodyHTML != tt.want {
+				t.Errorf("got %s, want %s", p.BodyHTML, tt.want)
+			}
+		})
+	}
+
+	t.Run("no files", func(t *testing.T) {
+		body := `<img src="https://example.com/a.png">`
+		p := Post{BodyHTML: body}
+		if err := p.ReplaceMediaURLs(nil); err != nil || p.BodyHTML != body {
+			t.Errorf("got %s, %v, want the body unchanged", p.BodyHTML, err)
+		}
+	})
+}
@@ -0,0 +1,192 @@
+package lib
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// MediaStoreFolder is the name of the folder, at the root of an archive, holding the shared media store.
+const MediaStoreFolder = "media"
+
+// MediaStore is a content-addressed store for media files shared by all the posts of an archive.
+// Files are named after the SHA-256 hash of their content, so an image used by many posts is stored once
+// and two different images with the same name never overwrite each other.
+// The mapping from media URLs to stored files is kept in the archive manifest,
+// so a URL already in the store is not downloaded again.
+type MediaStore struct {
+	root     string
+	manifest *Manifest
+}
+
+// NewMediaStore creates a MediaStore in the archive folder of the given manifest.
+func NewMediaStore(m *Manifest) *MediaStore {
+	return &MediaStore{root: m.Dir(), manifest: m}
+}
+
+// DownloadMedia downloads the media files at the given urls into the store through the Fetcher f,
+// with up to concurrency files downloaded at once.
+// It returns a map from each media url to its stored file (whose path is relative to postFolder),
+// and the errors of the media files that couldn't be downloaded, if any.
+func (s *MediaStore) DownloadMedia(ctx context.Context, f *Fetcher, urls []string, postFolder string, concurrency int) (map[string]MediaFile, MediaErrors) {
+	return downloadAll(urls, concurrency, func(mediaUrl string) (MediaFile, error) {
+		rec, err := s.store(ctx, f, mediaUrl)
+		if err != nil {
+			return MediaFile{}, err
+		}
+		return s.relative(rec, postFolder)
+	})
+}
+
+// save saves the media file at mediaUrl for the post in postFolder with download, which saves it into the given folder
+// and returns it with a path relative to that folder. It is used for the files downloaded by their own means,
+// such as podcast episodes, videos and attachments.
+// Without a store (s is nil), the file is saved into postFolder. Otherwise, a file already in the store is not downloaded again,
+// and a new one is downloaded into a temporary folder, then moved into the store.
+// The returned file has a path relative to postFolder.
+func (s *MediaStore) save(mediaUrl string, postFolder string, download func(folder string) (MediaFile, error)) (MediaFile, error) {
+	if s == nil {
+		return download(postFolder)
+	}
+	if rec, ok := s.lookup(mediaUrl); ok {
+		return s.relative(rec, postFolder)
+	}
+
+	storeDir := filepath.Join(s.root, MediaStoreFolder)
+	if err := os.MkdirAll(storeDir, 0755); err != nil {
+		return MediaFile{}, err
+	}
+	tmpDir, err := os.MkdirTemp(storeDir, ".download.*.tmp")
+	if err != nil {
+		return MediaFile{}, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	file, err := download(tmpDir)
+	if err != nil {
+		return MediaFile{}, err
+	}
+	rec, err := s.add(mediaUrl, filepath.Join(tmpDir, filepath.FromSlash(file.Path)), file.MimeType)
+	if err != nil {
+		return MediaFile{}, fmt.Errorf("failed to save media file: %w", err)
+	}
+	return s.relative(rec, postFolder)
+}
+
+// lookup returns the record of the media file at mediaUrl, if it is in the store.
+func (s *MediaStore) lookup(mediaUrl string) (MediaRecord, bool) {
+	rec, ok := s.manifest.Media(mediaUrl)
+	if !ok {
+		return MediaRecord{}, false
+	}
+	if _, err := os.Stat(filepath.Join(s.root, filepath.FromSlash(rec.Path))); err != nil {
+		return MediaRecord{}, false
+	}
+	return rec, true
+}
+
+// relative returns the stored file of rec, with a path relative to postFolder.
+func (s *MediaStore) relative(rec MediaRecord, postFolder string) (MediaFile, error) {
+	rel, err := filepath.Rel(postFolder, filepath.Join(s.root, filepath.FromSlash(rec.Path)))
+	if err != nil {
+		return MediaFile{}, err
+	}
+	return MediaFile{Path: filepath.ToSlash(rel), MimeType: rec.MimeType}, nil
+}
+
+// store downloads the media file at mediaUrl into the store, unless it is already there,
+// and returns its record, whose path is relative to the archive folder.
+func (s *MediaStore) store(ctx context.Context, f *Fetcher, mediaUrl string) (MediaRecord, error) {
+	if rec, ok := s.lookup(mediaUrl); ok {
+		return rec, nil
+	}
+
+	body, header, err := f.FetchURLWithHeader(ctx, mediaUrl)
+	if err != nil {
+		return MediaRecord{}, fmt.Errorf("failed to download media: %w", err)
+	}
+	defer body.Close()
+
+	r := bufio.NewReaderSize(body, sniffLen)
+	head, _ := r.Peek(sniffLen) // a shorter head is fine for small files
+	mimeType := detectMediaType(header.Get("Content-Type"), head)
+
+	storeDir := filepath.Join(s.root, MediaStoreFolder)
+	if err := os.MkdirAll(storeDir, 0755); err != nil {
+		return MediaRecord{}, err
+	}
+	tmp, err := os.CreateTemp(storeDir, ".media.*.tmp")
+	if err != nil {
+		return MediaRecord{}, err
+	}
+	defer os.Remove(tmp.Name()) // no-op once the file has been renamed
+
+	hasher := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hasher), r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return MediaRecord{}, fmt.Errorf("failed to save media file: %w", err)
+	}
+
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	return s.put(mediaUrl, tmp.Name(), hash, path.Ext(mediaFileName(mediaUrl, mimeType)), mimeType)
+}
+
+// add moves the file at filePath, saved on the same file system as the store, into the store
+// as the media file at mediaUrl, and returns its record.
+// Its extension is kept, e.g. the original extension of an attachment.
+func (s *MediaStore) add(mediaUrl string, filePath string, mimeType string) (MediaRecord, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return MediaRecord{}, err
+	}
+	hasher := sha256.New()
+	_, err = io.Copy(hasher, file)
+	file.Close()
+	if err != nil {
+		return MediaRecord{}, err
+	}
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	return s.put(mediaUrl, filePath, hash, strings.ToLower(filepath.Ext(filePath)), mimeType)
+}
+
+// put moves the file at tmpPath into the store under its hash and extension, unless the store already has it,
+// and records it as the media file at mediaUrl.
+func (s *MediaStore) put(mediaUrl string, tmpPath string, hash string, ext string, mimeType string) (MediaRecord, error) {
+	rec := MediaRecord{
+		Path:     path.Join(MediaStoreFolder, hash[:2], hash+ext),
+		Hash:     hash,
+		MimeType: mimeType,
+	}
+	finalPath := filepath.Join(s.root, filepath.FromSlash(rec.Path))
+
+	if _, err := os.Stat(finalPath); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(finalPath), 0755); err != nil {
+			return MediaRecord{}, err
+		}
+		if err := os.Chmod(tmpPath, 0644); err != nil {
+			return MediaRecord{}, err
+		}
+		if err := os.Rename(tmpPath, finalPath); err != nil {
+			return MediaRecord{}, err
+		}
+		if err := syncDir(filepath.Dir(finalPath)); err != nil {
+			return MediaRecord{}, err
+		}
+	}
+
+	s.manifest.RecordMedia(mediaUrl, rec)
+	return rec, nil
+}
@@ -0,0 +1,143 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMediaStoreDownloadMedia(t *testing.T) {
+	png := "\x89PNG\r\n\x1a\n"
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch r.URL.Path {
+		case "/a/image.png", "/copy.png":
+			w.Write([]byte(png + "a"))
+		case "/b/image.png":
+			w.Write([]byte(png + "b"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	f := NewFetcher(WithRatePerSecond(1000))
+
+	dir := t.TempDir()
+	manifest, err := LoadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewMediaStore(manifest)
+	postFolder := filepath.Join(dir, "pub", "post")
+	urls := []string{srv.URL + "/a/image.png", srv.URL + "/b/image.png", srv.URL + "/copy.png", srv.URL + "/missing.png"}
+
+	files, errs := s.DownloadMedia(context.Background(), f, urls, postFolder, 2)
+	if len(errs) != 1 || errs[urls[3]] == nil {
+		t.Errorf("got errors %v, want one for %s", errs, urls[3])
+	}
+	if files[urls[0]].Path == files[urls[1]].Path {
+		t.Errorf("%s and %s both saved as %s", urls[0], urls[1], files[urls[0]].Path)
+	}
+	if files[urls[0]] != files[urls[2]] {
+		t.Errorf("same content saved as %+v and %+v", files[urls[0]], files[urls[2]])
+	}
+	for _, u := range urls[:3] {
+		file := files[u]
+		if !strings.HasPrefix(file.Path, "../../"+MediaStoreFolder+"/") || !strings.HasSuffix(file.Path, ".png") || file.MimeType != "image/png" {
+			t.Errorf("%s: got %+v, want a png file in the media store", u, file)
+		}
+		if _, err := os.Stat(filepath.Join(postFolder, file.Path)); err != nil {
+			t.Errorf("%s: %v", u, err)
+		}
+		if _, ok := manifest.Media(u); !ok {
+			t.Errorf("%s not recorded in the manifest", u)
+		}
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(dir, MediaStoreFolder, ".*.tmp")); len(leftovers) > 0 {
+		t.Errorf("temporary files left in the store: %v", leftovers)
+	}
+
+	// files already in the store are not downloaded again
+	before := requests.Load()
+	again, _ := s.DownloadMedia(context.Background(), f, urls[:3], filepath.Join(dir, "pub"), 2)
+	if n := requests.Load() - before; n != 0 {
+		t.Errorf("got %d requests, want none", n)
+	}
+	if got, want := again[urls[0]].Path, strings.TrimPrefix(files[urls[0]].Path, "../"); got != want {
+		t.Errorf("got path %q, want %q", got, want)
+	}
+}
+
+func TestMediaStoreSave(t *testing.T) {
+	download := func(name string, content string) func(folder string) (MediaFile, error) {
+		return func(folder string) (MediaFile, error) {
+			if err := os.WriteFile(filepath.Join(folder, name), []byte(content), 0644); err != nil {
+				return MediaFile{}, err
+			}
+			return MediaFile{Path: name, MimeType: "application/pdf"}, nil
+		}
+	}
+
+	t.Run("without store", func(t *testing.T) {
+		dir := t.TempDir()
+		var s *MediaStore
+		got, err := s.save("https://example.com/a.pdf", dir, download("Report.pdf", "a"))
+		if err != nil || got.Path != "Report.pdf" {
+			t.Fatalf("got %+v, %v, want Report.pdf", got, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "Report.pdf")); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("store", func(t *testing.T) {
+		dir := t.TempDir()
+		manifest, err := LoadManifest(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := NewMediaStore(manifest)
+		postFolder := filepath.Join(dir, "post")
+
+		got, err := s.save("https://example.com/a.pdf", postFolder, download("Report.PDF", "a"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(got.Path, "../"+MediaStoreFolder+"/") || !strings.HasSuffix(got.Path, ".pdf") || got.MimeType != "application/pdf" {
+			t.Errorf("got %+v, want a pdf file in the media store", got)
+		}
+		if data, err := os.ReadFile(filepath.Join(postFolder, got.Path)); err != nil || string(data) != "a" {
+			t.Errorf("got %q, %v, want the downloaded file", data, err)
+		}
+
+		// a file already in the store is not downloaded again
+		again, err := s.save("https://example.com/a.pdf", postFolder, func(string) (MediaFile, error) {
+			t.Error("file downloaded again")
+			return MediaFile{}, errors.New("unexpected download")
+		})
+		if err != nil || again != got {
+			t.Errorf("got %+v, %v, want %+v", again, err, got)
+		}
+
+		// a failed download leaves nothing behind
+		if _, err := s.save("https://example.com/b.pdf", postFolder, func(folder string) (MediaFile, error) {
+			os.WriteFile(filepath.Join(folder, "partial.pdf"), []byte("b"), 0644)
+			return MediaFile{}, errors.New("download failed")
+		}); err == nil {
+			t.Error("got no error for a failed download")
+		}
+		if entries, _ := filepath.Glob(filepath.Join(dir, MediaStoreFolder, ".*")); len(entries) > 0 {
+			t.Errorf("temporary files left in the store: %v", entries)
+		}
+		if _, ok := manifest.Media("https://example.com/b.pdf"); ok {
+			t.Error("failed download recorded in the manifest")
+		}
+	})
+}
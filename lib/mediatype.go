@@ -0,0 +1,188 @@
+package lib
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// sniffLen is the number of bytes read from the start of a media file to detect its type.
+const sniffLen = 512
+
+// maxFileNameLen is the maximum length, in bytes, of the base name of a media file.
+const maxFileNameLen = 100
+
+// mediaExtensions maps the MIME types of common media files to their preferred extension.
+// Types not listed here fall back to the mime package.
+var mediaExtensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"image/svg+xml":   ".svg",
+	"image/avif":      ".avif",
+	"image/heic":      ".heic",
+	"image/heif":      ".heif",
+	"image/bmp":       ".bmp",
+	"image/x-icon":    ".ico",
+	"image/tiff":      ".tiff",
+	"application/pdf": ".pdf",
+	"audio/mpeg":      ".mp3",
+	"audio/mp4":       ".m4a",
+	"audio/ogg":       ".ogg",
+	"audio/wav":       ".wav",
+	"video/mp4":       ".mp4",
+	"video/webm":      ".webm",
+	"video/mp2t":      ".ts",
+}
+
+// isoBrands maps the major brands of ISO base media files (the "ftyp" box) to their MIME type.
+var isoBrands = map[string]string{
+	"avif": "image/avif",
+	"avis": "image/avif",
+	"heic": "image/heic",
+	"heix": "image/heic",
+	"hevc": "image/heic",
+	"hevx": "image/heic",
+	"mif1": "image/heif",
+	"msf1": "image/heif",
+	"M4A ": "audio/mp4",
+	"M4V ": "video/mp4",
+	"isom": "video/mp4",
+	"iso2": "video/mp4",
+	"mp41": "video/mp4",
+	"mp42": "video/mp4",
+	"avc1": "video/mp4",
+}
+
+// detectMediaType determines the MIME type of a media file from the first bytes of its content (head)
+// and the Content-Type header it was served with. The content wins over the header, which is often
+// generic (e.g. application/octet-stream) or wrong for files served by CDNs.
+func detectMediaType(contentType string, head []byte) string {
+	if sniffed := sniffMediaType(head); sniffed != "" {
+		return sniffed
+	}
+
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil && mediaType != "application/octet-stream" {
+		return mediaType
+	}
+
+	if len(head) > 0 {
+		if sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(head)); sniffed != "application/octet-stream" && !strings.HasPrefix(sniffed, "text/") {
+			return sniffed
+		}
+	}
+
+	return ""
+}
+
+// sniffMediaType recognizes the magic bytes of common image, audio and video formats,
+// including those http.DetectContentType doesn't know about (SVG, AVIF, HEIC, ...).
+func sniffMediaType(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return "image/png"
+	case bytes.HasPrefix(head, []byte("\xff\xd8\xff")):
+		return "image/jpeg"
+	case bytes.HasPrefix(head, []byte("GIF87a")), bytes.HasPrefix(head, []byte("GIF89a")):
+		return "image/gif"
+	case len(head) >= 12 && bytes.Equal(head[:4], []byte("RIFF")) && bytes.Equal(head[8:12], []byte("WEBP")):
+		return "image/webp"
+	case len(head) >= 12 && bytes.Equal(head[4:8], []byte("ftyp")):
+		return isoBrands[string(head[8:12])]
+	case bytes.HasPrefix(head, []byte("BM")):
+		return "image/bmp"
+	case bytes.HasPrefix(head, []byte("\x00\x00\x01\x00")):
+		return "image/x-icon"
+	case bytes.HasPrefix(head, []byte("II*\x00")), bytes.HasPrefix(head, []byte("MM\x00*")):
+		return "image/tiff"
+	case bytes.HasPrefix(head, []byte("%PDF-")):
+		return "application/pdf"
+	case bytes.HasPrefix(head, []byte("ID3")), bytes.HasPrefix(head, []byte("\xff\xfb")), bytes.HasPrefix(head, []byte("\xff\xf3")):
+		return "audio/mpeg"
+	case len(head) > 188 && head[0] == 0x47 && head[188] == 0x47: // two consecutive sync bytes of 188-byte packets
+		return "video/mp2t"
+	}
+
+	trimmed := bytes.TrimLeft(head, " \t\r\n\xef\xbb\xbf")
+	if (bytes.HasPrefix(trimmed, []byte("<svg")) || bytes.HasPrefix(trimmed, []byte("<?xml"))) && bytes.Contains(head, []byte("<svg")) {
+		return "image/svg+xml"
+	}
+
+	return ""
+}
+
+// extensionForType returns the file extension for the given MIME type, or "" if unknown.
+func extensionForType(mimeType string) string {
+	if ext, ok := mediaExtensions[mimeType]; ok {
+		return ext
+	}
+	if exts, err := mime.ExtensionsByType(mimeType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+// mediaFileName returns the name to save the media file at mediaUrl as, with the extension of its MIME type.
+// The name is taken from the last segment of the URL path (the query string is ignored) and sanitized.
+// If mimeType is unknown, the extension found in the URL, if any, is kept.
+func mediaFileName(mediaUrl string, mimeType string) string {
+	base := mediaUrl
+	if u, err := url.Parse(mediaUrl); err == nil {
+		base = u.Path
+	}
+	base = path.Base(base)
+	if base == "/" || base == "." {
+		base = "" // no name in the URL
+	}
+
+	ext := path.Ext(base)
+	base = strings.TrimSuffix(base, ext)
+	if detected := extensionForType(mimeType); detected != "" {
+		ext = detected
+	}
+
+	base = sanitizeFileName(base)
+	if base == "" {
+		base = "media"
+	}
+	return base + sanitizeFileName(strings.ToLower(ext))
+}
+
+// windowsReservedNames are file names that can't be used on Windows, whatever their extension.
+var windowsReservedNames = map[string]struct{}{
+	"CON": {}, "PRN": {}, "AUX": {}, "NUL": {},
+	"COM1": {}, "COM2": {}, "COM3": {}, "COM4": {}, "COM5": {}, "COM6": {}, "COM7": {}, "COM8": {}, "COM9": {},
+	"LPT1": {}, "LPT2": {}, "LPT3": {}, "LPT4": {}, "LPT5": {}, "LPT6": {}, "LPT7": {}, "LPT8": {}, "LPT9": {},
+}
+
+// sanitizeFileName makes name safe to use as a file name on all common filesystems:
+// reserved and control characters are replaced, trailing dots and spaces removed,
+// Windows reserved names escaped and the length limited.
+func sanitizeFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+
+	if len(name) > maxFileNameLen {
+		name = name[:maxFileNameLen]
+		for !utf8.ValidString(name) {
+			name = name[:len(name)-1]
+		}
+	}
+
+	name = strings.TrimRight(name, ". ")
+	// reserved names are reserved whatever their extension, e.g. aux.txt
+	stem, _, _ := strings.Cut(name, ".")
+	if _, reserved := windowsReservedNames[strings.ToUpper(strings.TrimRight(stem, " "))]; reserved {
+		name = "_" + name
+	}
+	return name
+}
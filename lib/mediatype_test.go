@@ -0,0 +1,129 @@
+package lib
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// tsHead returns the start of an MPEG-TS stream: two 188-byte packets starting with a sync byte.
+func tsHead() []byte {
+	head := make([]byte, 2*188)
+	head[0], head[188] = 0x47, 0x47
+	return head
+}
+
+func TestDetectMediaType(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		head        []byte
+		want        string
+	}{
+		{"png", "", []byte("\x89PNG\r\n\x1a\n\x00\x00"), "image/png"},
+		{"jpeg despite header", "image/png", []byte("\xff\xd8\xff\xe0\x00\x10JFIF"), "image/jpeg"},
+		{"gif", "application/octet-stream", []byte("GIF89a\x01\x00"), "image/gif"},
+		{"webp", "", []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), "image/webp"},
+		{"avif", "", []byte("\x00\x00\x00\x1cftypavif\x00\x00"), "image/avif"},
+		{"heic", "", []byte("\x00\x00\x00\x18ftypheic\x00\x00"), "image/heic"},
+		{"mp4", "", []byte("\x00\x00\x00\x20ftypisom\x00\x00"), "video/mp4"},
+		{"m4a", "", []byte("\x00\x00\x00\x20ftypM4A \x00\x00"), "audio/mp4"},
+		{"pdf", "", []byte("%PDF-1.7\n"), "application/pdf"},
+		{"mp3 with id3", "", []byte("ID3\x04\x00\x00"), "audio/mpeg"},
+		{"mp3 frame", "", []byte("\xff\xfb\x90\x00"), "audio/mpeg"},
+		{"mpeg-ts", "", tsHead(), "video/mp2t"},
+		{"svg", "text/plain", []byte("\xef\xbb\xbf\n<svg xmlns=\"http://www.w3.org/2000/svg\"></svg>"), "image/svg+xml"},
+		{"svg with xml declaration", "", []byte(`<?xml version="1.0"?><svg></svg>`), "image/svg+xml"},
+		{"xml without svg", "", []byte(`<?xml version="1.0"?><rss></rss>`), ""},
+		{"unknown content, header", "image/x-custom; charset=binary", []byte("????"), "image/x-custom"},
+		{"generic header", "application/octet-stream", []byte{0x01, 0x02, 0x03}, ""},
+		{"text content", "", []byte("hello world"), ""},
+		{"no content", "image/png", nil, "image/png"},
+		{"nothing", "", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectMediaType(tt.contentType, tt.head); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtensionForType(t *testing.T) {
+	tests := []struct {
+		mimeType string
+		want     string
+	}{
+		{"image/jpeg", ".jpg"},
+		{"image/svg+xml", ".svg"},
+		{"video/mp2t", ".ts"},
+		{"audio/mpeg", ".mp3"},
+		{"text/css", ".css"},
+		{"application/x-unknown", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := extensionForType(tt.mimeType); got != tt.want {
+			t.Errorf("extensionForType(%q) = %q, want %q", tt.mimeType, got, tt.want)
+		}
+	}
+}
+
+func TestMediaFileName(t *testing.T) {
+	tests := []struct {
+		name     string
+		mediaUrl string
+		mimeType string
+		want     string
+	}{
+		{"extension from type", "https://example.com/images/photo.jpg", "image/png", "photo.png"},
+		{"extension kept when type unknown", "https://example.com/images/photo.JPG", "", "photo.jpg"},
+		{"no extension", "https://example.com/images/photo", "image/webp", "photo.webp"},
+		{"query ignored", "https://example.com/photo.jpg?w=800&h=600", "image/jpeg", "photo.jpg"},
+		{"escaped original url", "https://substackcdn.com/image/fetch/w_1456/" + cdnEscaped, "image/png", "a.png"},
+		{"empty name", "https://example.com/", "image/png", "media.png"},
+		{"no path", "https://example.com", "image/png", "media.png"},
+		{"reserved characters", `https://example.com/a:b*c.png`, "image/png", "a_b_c.png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mediaFileName(tt.mediaUrl, tt.mimeType); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeFileName(t *testing.T) {
+	long := strings.Repeat("a", maxFileNameLen-1) + "é"
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "photo.png", "photo.png"},
+		{"reserved characters", `a<b>c:d"e/f\g|h?i*j`, "a_b_c_d_e_f_g_h_i_j"},
+		{"control characters", "a\x00b\nc\x7f", "a_b_c_"},
+		{"trailing dots and spaces", "name. . ", "name"},
+		{"windows reserved name", "con", "_con"},
+		{"windows reserved name with extension", "aux.txt", "_aux.txt"},
+		{"windows reserved name as a prefix", "console.log", "console.log"},
+		{"long name cut on a rune boundary", long, strings.Repeat("a", maxFileNameLen-1)},
+		{"unicode", "café ☕", "café ☕"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeFileName(tt.in); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSniffMediaTypeShortHead(t *testing.T) {
+	// heads shorter than the magic bytes they are checked against must not panic
+	for n := 0; n <= 12; n++ {
+		sniffMediaType(bytes.Repeat([]byte{0x47}, n))
+	}
+}
@@ -0,0 +1,487 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NotesFolder is the folder notes are archived into, inside the output directory, in a subfolder per source (see NotesSource.Folder).
+const NotesFolder = "notes"
+
+// NotesFileName is the name of the file holding the archived notes, in NotesFolder.
+const NotesFileName = "notes.json"
+
+// substackURL is the url of Substack itself, which serves the notes of authors.
+var substackURL = "https://substack.com"
+
+// Note is a Substack note: a short post by an author, possibly replying to other notes.
+type Note struct {
+	Comment
+	Attachments []NoteAttachment `json:"attachments,omitempty"`
+	// Context holds the notes this note replies to, from the start of the thread.
+	Context []Comment `json:"context,omitempty"`
+}
+
+// NoteAttachment is an image, post or link attached to a note.
+type NoteAttachment struct {
+	Type         string              `json:"type"`
+	ImageUrl     string              `json:"imageUrl,omitempty"`
+	Post         *NoteAttachmentPost `json:"post,omitempty"`
+	LinkMetadata *NoteAttachmentLink `json:"linkMetadata,omitempty"`
+}
+
+// NoteAttachmentPost is a post attached to a note.
+type NoteAttachmentPost struct {
+	Title        string `json:"title"`
+	CanonicalUrl string `json:"canonical_url"`
+}
+
+// NoteAttachmentLink is a link attached to a note.
+type NoteAttachmentLink struct {
+	Url   string `json:"url"`
+	Title string `json:"title"`
+}
+
+// NotesSource is the feed notes are archived from: the notes of a publication, or of an author.
+type NotesSource struct {
+	// PubUrl is the url of the publication whose notes are archived.
+	PubUrl string
+	// Handle is the handle of the author whose notes are archived, if PubUrl is empty.
+	Handle string
+}
+
+// Folder returns the name of the folder the notes of the source are archived into, inside NotesFolder:
+// the host of the publication, or the handle of the author prefixed with @, so that sources never mix.
+func (src NotesSource) Folder() string {
+	if src.PubUrl != "" {
+		return Subscription{Url: src.PubUrl}.Folder()
+	}
+	return sanitizeFileName("@" + strings.TrimPrefix(src.Handle, "@"))
+}
+
+// notesPage is a page of a notes feed.
+type notesPage struct {
+	Items []struct {
+		Type           string    `json:"type"`
+		Comment        *Note     `json:"comment"`
+		ParentComments []Comment `json:"parentComments"`
+	} `json:"items"`
+	NextCursor string `json:"nextCursor"`
+}
+
+// notesFeedURL returns the url of the notes feed of the source, without cursor.
+func (e *Extractor) notesFeedURL(ctx context.Context, src NotesSource) (*url.URL, error) {
+	if src.PubUrl != "" {
+		u, err := url.Parse(src.PubUrl)
+		if err != nil {
+			return nil, err
+		}
+		return &url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/api/v1/notes"}, nil
+	}
+	if src.Handle == "" {
+		return nil, fmt.Errorf("a publication url or an author handle is required")
+	}
+
+	// the feed of an author is keyed by their user id
+	u, err := url.Parse(substackURL)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = "/api/v1/user/" + url.PathEscape(strings.TrimPrefix(src.Handle, "@")) + "/public_profile"
+	body, err := e.fetcher.FetchURL(ctx, u.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the profile of %s: %w", src.Handle, err)
+	}
+	defer body.Close()
+	var profile struct {
+		Id int `json:"id"`
+	}
+	if err := json.NewDecoder(body).Decode(&profile); err != nil || profile.Id == 0 {
+		return nil, fmt.Errorf("failed to decode the profile of %s: %v", src.Handle, err)
+	}
+	u.Path = "/api/v1/reader/feed/profile/" + strconv.Itoa(profile.Id)
+	u.RawQuery = url.Values{"types": {"note"}}.Encode()
+	return u, nil
+}
+
+// FetchNotes returns the notes of the source, newest first, with the notes they reply to.
+// The feed is paged through until its end, or until a page holding a note whose id is in known:
+// the notes after it were archived by a previous run.
+func (e *Extractor) FetchNotes(ctx context.Context, src NotesSource, known map[int]bool) ([]Note, error) {
+	feedUrl, err := e.notesFeedURL(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+
+	notes := []Note{}
+	seen := make(map[int]bool)
+	cursor := ""
+	for {
+		q := feedUrl.Query()
+		if cursor != "" {
+			q.Set("cursor", cursor)
+		}
+		pageUrl := *feedUrl
+		pageUrl.RawQuery = q.Encode()
+
+		page, err := e.fetchNotesPage(ctx, pageUrl.String())
+		if err != nil {
+			return nil, err
+		}
+		reachedKnown := false
+		for _, item := range page.Items {
+			// the feed also holds restacked posts, which aren't notes
+			if item.Comment == nil || item.Type != "comment" {
+				continue
+			}
+			if known[item.Comment.Id] {
+				reachedKnown = true
+				continue
+			}
+			if seen[item.Comment.Id] {
+				continue
+			}
+			seen[item.Comment.Id] = true
+			note := *item.Comment
+			note.Context = item.ParentComments
+			notes = append(notes, note)
+		}
+		e.logger.Debug("fetched notes", "url", pageUrl.String(), "count", len(notes))
+
+		if reachedKnown || page.NextCursor == "" || page.NextCursor == cursor {
+			return notes, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// fetchNotesPage fetches and decodes a single page of a notes feed.
+func (e *Extractor) fetchNotesPage(ctx context.Context, pageUrl string) (notesPage, error) {
+	body, err := e.fetcher.FetchURL(ctx, pageUrl)
+	if err != nil {
+		return notesPage{}, err
+	}
+	defer body.Close()
+
+	var page notesPage
+	if err := json.NewDecoder(body).Decode(&page); err != nil {
+		return notesPage{}, fmt.Errorf("failed to decode notes: %w", err)
+	}
+	return page, nil
+}
+
+// NotesArchive holds the archived notes of a source, the community threads of a publication,
+// and the media files attached to them.
+type NotesArchive struct {
+	// Notes are sorted from the oldest to the newest.
+	Notes []Note `json:"notes"`
+	// Threads are sorted from the oldest to the newest.
+	Threads []Thread `json:"threads,omitempty"`
+	// Media holds the media files downloaded for the notes, keyed by their original URL.
+	// Their paths are relative to the folder of the archive.
+	Media map[string]MediaFile `json:"media,omitempty"`
+
+	folder string
+}
+
+// LoadNotesArchive reads the notes archived in folder.
+// If none were archived yet, an empty archive is returned.
+func LoadNotesArchive(folder string) (*NotesArchive, error) {
+	a := &NotesArchive{Notes: []Note{}, Media: make(map[string]MediaFile), folder: folder}
+	data, err := os.ReadFile(filepath.Join(folder, NotesFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return a, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, a); err != nil {
+		return nil, fmt.Errorf("failed to read notes archive: %w", err)
+	}
+	if a.Media == nil {
+		a.Media = make(map[string]MediaFile)
+	}
+	return a, nil
+}
+
+// Folder returns the folder of the archive.
+func (a *NotesArchive) Folder() string {
+	return a.folder
+}
+
+// IDs returns the ids of the archived notes.
+func (a *NotesArchive) IDs() map[int]bool {
+	ids := make(map[int]bool, len(a.Notes))
+	for _, n := range a.Notes {
+		ids[n.Id] = true
+	}
+	return ids
+}
+
+// ThreadIDs returns the ids of the archived threads.
+func (a *NotesArchive) ThreadIDs() map[string]bool {
+	ids := make(map[string]bool, len(a.Threads))
+	for _, t := range a.Threads {
+		ids[t.Id] = true
+	}
+	return ids
+}
+
+// AddThreads adds threads to the archive, replacing the archived threads with the same id.
+// It returns the number of threads that weren't archived yet.
+func (a *NotesArchive) AddThreads(threads []Thread) int {
+	index := make(map[string]int, len(a.Threads))
+	for i, t := range a.Threads {
+		index[t.Id] = i
+	}
+	added := 0
+	for _, t := range threads {
+		if i, ok := index[t.Id]; ok {
+			a.Threads[i] = t
+			continue
+		}
+		index[t.Id] = len(a.Threads)
+		a.Threads = append(a.Threads, t)
+		added++
+	}
+	sort.SliceStable(a.Threads, func(i, j int) bool {
+		return a.Threads[i].Date < a.Threads[j].Date
+	})
+	return added
+}
+
+// Add adds notes to the archive, replacing the archived notes with the same id.
+// It returns the number of notes that weren't archived yet.
+func (a *NotesArchive) Add(notes []Note) int {
+	index := make(map[int]int, len(a.Notes))
+	for i, n := range a.Notes {
+		index[n.Id] = i
+	}
+	added := 0
+	for _, n := range notes {
+		if i, ok := index[n.Id]; ok {
+			a.Notes[i] = n
+			continue
+		}
+		index[n.Id] = len(a.Notes)
+		a.Notes = append(a.Notes, n)
+		added++
+	}
+	// note dates are RFC 3339 timestamps in UTC, so they sort chronologically as strings
+	sort.SliceStable(a.Notes, func(i, j int) bool {
+		return a.Notes[i].Date < a.Notes[j].Date
+	})
+	return added
+}
+
+// Save atomically writes the archive to NotesFileName in its folder.
+func (a *NotesArchive) Save() error {
+	data, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(filepath.Join(a.folder, NotesFileName), func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// DownloadNoteMedia downloads the images attached to the archived notes and threads that weren't downloaded yet
+// into the folder of the archive, or the MediaStore of the Extractor if any, and records them in it.
+// It returns the errors of the images that couldn't be downloaded, if any.
+func (e *Extractor) DownloadNoteMedia(ctx context.Context, a *NotesArchive) MediaErrors {
+	var mediaUrls []string
+	for _, n := range a.Notes {
+		for _, att := range n.Attachments {
+			if _, ok := a.Media[att.ImageUrl]; att.ImageUrl != "" && !ok {
+				mediaUrls = append(mediaUrls, att.ImageUrl)
+			}
+		}
+	}
+	for _, t := range a.Threads {
+		for _, mediaUrl := range t.MediaUrls {
+			if _, ok := a.Media[mediaUrl]; !ok {
+				mediaUrls = append(mediaUrls, mediaUrl)
+			}
+		}
+	}
+	if len(mediaUrls) == 0 {
+		return nil
+	}
+	var files map[string]MediaFile
+	var mediaErrs MediaErrors
+	if e.mediaStore != nil {
+		files, mediaErrs = e.mediaStore.DownloadMedia(ctx, e.mediaFetcher, mediaUrls, a.folder, e.mediaConcurrency)
+	} else {
+		files, mediaErrs = DownloadMedia(ctx, e.mediaFetcher, mediaUrls, a.folder, e.mediaConcurrency)
+	}
+	for mediaUrl, file := range files {
+		a.Media[mediaUrl] = file
+	}
+	return mediaErrs
+}
+
+// WriteMonths writes the archived notes into one file per month, named after it (e.g. 2024-01.html),
+// in the folder of the archive and the given format (html, md, or txt). Notes are in chronological order,
+// followed by the threads of the month. It returns the paths of the files written.
+func (a *NotesArchive) WriteMonths(format string, opts ...OutputOption) ([]string, error) {
+	byMonth := make(map[string][]Note)
+	for _, n := range a.Notes {
+		byMonth[noteMonth(n.Date)] = append(byMonth[noteMonth(n.Date)], n)
+	}
+	threadsByMonth := make(map[string][]Thread)
+	for _, t := range a.Threads {
+		threadsByMonth[noteMonth(t.Date)] = append(threadsByMonth[noteMonth(t.Date)], t)
+	}
+	var months []string
+	for month := range byMonth {
+		months = append(months, month)
+	}
+	for month := range threadsByMonth {
+		if _, ok := byMonth[month]; !ok {
+			months = append(months, month)
+		}
+	}
+	sort.Strings(months)
+
+	var paths []string
+	for _, month := range months {
+		title := "Notes"
+		if t, err := time.Parse("2006-01", month); err == nil {
+			title += ", " + t.Format("January 2006")
+		}
+		path := filepath.Join(a.folder, month+"."+format)
+		if format == "txt" {
+			// written like the comments of posts, html2text losing the dates linking to the notes
+			err := WriteFileAtomic(path, func(w io.Writer) error {
+				_, err := io.WriteString(w, title+"\n"+notesText(byMonth[month], a.Media)+threadsText(threadsByMonth[month], a.Media))
+				return err
+			})
+			if err != nil {
+				return paths, err
+			}
+			paths = append(paths, path)
+			continue
+		}
+		p := Post{Title: title, BodyHTML: notesHTML(byMonth[month]) + threadsHTML(threadsByMonth[month])}
+		if err := p.ReplaceMediaURLs(a.Media); err != nil {
+			return paths, err
+		}
+		if err := p.WriteToFile(path, format, opts...); err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// noteMonth returns the month of the RFC 3339 date of a note or a thread (e.g. 2024-01), or "unknown".
+func noteMonth(date string) string {
+	if t, err := time.Parse(time.RFC3339, date); err == nil {
+		return t.UTC().Format("2006-01")
+	}
+	return "unknown"
+}
+
+// noteURL returns the url of the note on Substack, or an empty string if its id or its author's handle is unknown.
+func noteURL(c Comment) string {
+	if c.Handle == "" || c.Id == 0 {
+		return ""
+	}
+	return substackURL + "/@" + url.PathEscape(c.Handle) + "/note/c-" + strconv.Itoa(c.Id)
+}
+
+// notesHTML returns the HTML of notes, each preceded by the notes it replies to.
+func notesHTML(notes []Note) string {
+	var sb strings.Builder
+	for _, n := range notes {
+		fmt.Fprintf(&sb, "<div class=\"note\" id=\"note-%d\">\n", n.Id)
+		if len(n.Context) > 0 {
+			sb.WriteString("<blockquote class=\"note-context\">\n")
+			for _, c := range n.Context {
+				writeNoteHTML(&sb, c)
+			}
+			sb.WriteString("</blockquote>\n")
+		}
+		writeNoteHTML(&sb, n.Comment)
+		for _, att := range n.Attachments {
+			switch {
+			case att.ImageUrl != "":
+				fmt.Fprintf(&sb, "<p><img src=\"%s\"></p>\n", html.EscapeString(att.ImageUrl))
+			case att.Post != nil:
+				fmt.Fprintf(&sb, "<p class=\"note-attachment\">Post: <a href=\"%s\">%s</a></p>\n", html.EscapeString(att.Post.CanonicalUrl), html.EscapeString(att.Post.Title))
+			case att.LinkMetadata != nil:
+				title := att.LinkMetadata.Title
+				if title == "" {
+					title = att.LinkMetadata.Url
+				}
+				fmt.Fprintf(&sb, "<p class=\"note-attachment\">Link: <a href=\"%s\">%s</a></p>\n", html.EscapeString(att.LinkMetadata.Url), html.EscapeString(title))
+			}
+		}
+		sb.WriteString("</div>\n<hr>\n")
+	}
+	return sb.String()
+}
+
+// writeNoteHTML writes the author, date and body of a note to sb, the date linking to the note on Substack.
+func writeNoteHTML(sb *strings.Builder, c Comment) {
+	author, details := c.meta()
+	details = html.EscapeString(details)
+	if u := noteURL(c); u != "" {
+		details = "<a href=\"" + html.EscapeString(u) + "\">" + details + "</a>"
+	}
+	fmt.Fprintf(sb, "<p class=\"note-meta\"><strong>%s</strong> · %s</p>\n", html.EscapeString(author), details)
+	sb.WriteString(c.bodyHTML())
+}
+
+// notesText returns notes as plain text, each preceded by the notes it replies to, indented.
+// Images are referred to by their downloaded file in media, or their url.
+func notesText(notes []Note, media map[string]MediaFile) string {
+	var sb strings.Builder
+	for _, n := range notes {
+		for _, c := range n.Context {
+			writeNoteText(&sb, c, "    ")
+		}
+		writeNoteText(&sb, n.Comment, "")
+		for _, att := range n.Attachments {
+			switch {
+			case att.ImageUrl != "":
+				image := att.ImageUrl
+				if file, ok := media[att.ImageUrl]; ok {
+					image = file.Path
+				}
+				sb.WriteString("Image: " + image + "\n")
+			case att.Post != nil:
+				sb.WriteString("Post: " + att.Post.Title + " <" + att.Post.CanonicalUrl + ">\n")
+			case att.LinkMetadata != nil:
+				sb.WriteString("Link: " + att.LinkMetadata.Url + "\n")
+			}
+		}
+		sb.WriteString("\n---\n")
+	}
+	return sb.String()
+}
+
+// writeNoteText writes the author, date, url and body of a note to sb, each line prefixed with indent.
+func writeNoteText(sb *strings.Builder, c Comment, indent string) {
+	author, details := c.meta()
+	sb.WriteString("\n" + indent + author + " · " + details + "\n")
+	if u := noteURL(c); u != "" {
+		sb.WriteString(indent + u + "\n")
+	}
+	for _, para := range c.paragraphs() {
+		for _, line := range strings.Split(para, "\n") {
+			sb.WriteString(indent + line + "\n")
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testNote returns a note with the given id and date, by the author with the handle jane.
+func testNote(id int, date string) Note {
+	return Note{Comment: Comment{Id: id, Name: "Jane", Handle: "jane", Body: fmt.Sprintf("Note %d", id), Date: date}}
+}
+
+func TestNotesSourceFolder(t *testing.T) {
+	tests := []struct {
+		src  NotesSource
+		want string
+	}{
+		{NotesSource{PubUrl: "https://example.substack.com/"}, "example.substack.com"},
+		{NotesSource{Handle: "jane"}, "@jane"},
+		{NotesSource{Handle: "@jane"}, "@jane"},
+	}
+	for _, tt := range tests {
+		if got := tt.src.Folder(); got != tt.want {
+			t.Errorf("Folder() of %+v = %q, want %q", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestNoteMonth(t *testing.T) {
+	tests := []struct {
+		date, want string
+	}{
+		{"2024-01-31T23:30:00.000Z", "2024-01"},
+		{"2024-02-01T00:30:00+01:00", "2024-01"},
+		{"yesterday", "unknown"},
+	}
+	for _, tt := range tests {
+		if got := noteMonth(tt.date); got != tt.want {
+			t.Errorf("noteMonth(%q) = %q, want %q", tt.date, got, tt.want)
+		}
+	}
+}
+
+func TestNoteURL(t *testing.T) {
+	tests := []struct {
+		c    Comment
+		want string
+	}{
+		{Comment{Id: 12, Handle: "jane"}, "https://substack.com/@jane/note/c-12"},
+		{Comment{Id: 12}, ""},
+		{Comment{Handle: "jane"}, ""},
+	}
+	for _, tt := range tests {
+		if got := noteURL(tt.c); got != tt.want {
+			t.Errorf("noteURL(%+v) = %q, want %q", tt.c, got, tt.want)
+		}
+	}
+}
+
+func TestNotesArchiveAdd(t *testing.T) {
+	a := &NotesArchive{Notes: []Note{testNote(1, "2024-01-02T00:00:00Z")}}
+	edited := testNote(1, "2024-01-02T00:00:00Z")
+	edited.Body = "Edited"
+
+	if added := a.Add([]Note{testNote(3, "2024-03-01T00:00:00Z"), edited, testNote(2, "2024-01-01T00:00:00Z")}); added != 2 {
+		t.Errorf("got %d notes added, want 2", added)
+	}
+	var ids []int
+	for _, n := range a.Notes {
+		ids = append(ids, n.Id)
+	}
+	if want := []int{2, 1, 3}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got notes %v, want %v", ids, want)
+	}
+	if a.Notes[1].Body != "Edited" {
+		t.Errorf("got body %q, want the edited note", a.Notes[1].Body)
+	}
+	if want := map[int]bool{1: true, 2: true, 3: true}; !reflect.DeepEqual(a.IDs(), want) {
+		t.Errorf("got ids %v, want %v", a.IDs(), want)
+	}
+
+	if added := a.AddThreads([]Thread{{Id: "b", Date: "2024-02-01T00:00:00Z"}, {Id: "a", Date: "2024-01-01T00:00:00Z"}}); added != 2 {
+		t.Errorf("got %d threads added, want 2", added)
+	}
+	if added := a.AddThreads([]Thread{{Id: "a", Date: "2024-01-01T00:00:00Z", Body: "Edited"}}); added != 0 {
+		t.Errorf("got %d threads added again, want 0", added)
+	}
+	if a.Threads[0].Id != "a" || a.Threads[0].Body != "Edited" || a.Threads[1].Id != "b" {
+		t.Errorf("got threads %+v, want a (edited), then b", a.Threads)
+	}
+	if want := map[string]bool{"a": true, "b": true}; !reflect.DeepEqual(a.ThreadIDs(), want) {
+		t.Errorf("got thread ids %v, want %v", a.ThreadIDs(), want)
+	}
+}
+
+func TestNotesArchiveSaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	a, err := LoadNotesArchive(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Notes) != 0 || a.Media == nil || a.Folder() != dir {
+		t.Fatalf("got %+v, want an empty archive in %s", a, dir)
+	}
+	a.Add([]Note{testNote(1, "2024-01-02T00:00:00Z")})
+	a.AddThreads([]Thread{{Id: "a", Date: "2024-01-01T00:00:00Z"}})
+	a.Media["https://example.com/a.png"] = MediaFile{Path: "a.png", MimeType: "image/png"}
+	if err := a.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadNotesArchive(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, a) {
+		t.Errorf("got %+v, want %+v", loaded, a)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, NotesFileName), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadNotesArchive(dir); err == nil {
+		t.Errorf("got no error for an invalid archive")
+	}
+}
+
+func TestNotesHTMLAndText(t *testing.T) {
+	n := testNote(2, "2024-01-02T10:00:00Z")
+	n.Context = []Comment{{Id: 1, Name: "John", Handle: "john", Body: "Question?", Date: "2024-01-01T10:00:00Z"}}
+	n.Attachments = []NoteAttachment{
+		{Type: "image", ImageUrl: "https://example.com/a.png"},
+		{Type: "post", Post: &NoteAttachmentPost{Title: "A & B", CanonicalUrl: "https://example.substack.com/p/a"}},
+		{Type: "link", LinkMetadata: &NoteAttachmentLink{Url: "https://example.org/"}},
+	}
+	media := map[string]MediaFile{"https://example.com/a.png": {Path: "a.png", MimeType: "image/png"}}
+
+	html := notesHTML([]Note{n})
+	for _, want := range []string{
+		`<div class="note" id="note-2">`,
+		`<blockquote class="note-context">` + "\n" + `<p class="note-meta"><strong>John</strong> · <a href="https://substack.com/@john/note/c-1">2024-01-01 10:00 UTC</a></p>`,
+		`<p>Note 2</p>`,
+		`<p><img src="https://example.com/a.png"></p>`,
+		`Post: <a href="https://example.substack.com/p/a">A &amp; B</a>`,
+		`Link: <a href="https://example.org/">https://example.org/</a>`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("got %s, want it to contain %s", html, want)
+		}
+	}
+
+	want := "\n    John · 2024-01-01 10:00 UTC\n    https://substack.com/@john/note/c-1\n    Question?\n" +
+		"\nJane · 2024-01-02 10:00 UTC\nhttps://substack.com/@jane/note/c-2\nNote 2\n" +
+		"Image: a.png\nPost: A & B <https://example.substack.com/p/a>\nLink: https://example.org/\n\n---\n"
+	if got := notesText([]Note{n}, media); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestThreadsHTMLAndText(t *testing.T) {
+	threads := []Thread{{
+		Id: "t<1>", Name: "Jane", Body: "Thread", Date: "2024-01-02T10:00:00Z", MediaUrls: []string{"https://example.com/a.png"},
+		Replies: []ThreadReply{{Id: "r1", Name: "John", Body: "Reply", Date: "2024-01-02T11:00:00Z"}},
+	}}
+
+	html := threadsHTML(threads)
+	for _, want := range []string{
+		`<h2>Threads</h2>`,
+		`<div class="thread" id="thread-t&lt;1&gt;">`,
+		`<p class="note-meta"><strong>Jane</strong> · 2024-01-02 10:00 UTC</p>`,
+		`<blockquote class="thread-replies">` + "\n" + `<p class="note-meta"><strong>John</strong>`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("got %s, want it to contain %s", html, want)
+		}
+	}
+
+	want := "\nThreads\n\nJane · 2024-01-02 10:00 UTC\nThread\nImage: https://example.com/a.png\n" +
+		"\n    John · 2024-01-02 11:00 UTC\n    Reply\n\n---\n"
+	if got := threadsText(threads, nil); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if threadsHTML(nil) != "" || threadsText(nil, nil) != "" {
+		t.Errorf("got output for no threads")
+	}
+}
+
+func TestNotesArchiveWriteMonths(t *testing.T) {
+	dir := t.TempDir()
+	a, err := LoadNotesArchive(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Add([]Note{testNote(1, "2024-01-02T00:00:00Z"), testNote(2, "2024-03-01T00:00:00Z"), testNote(3, "")})
+	a.AddThreads([]Thread{{Id: "a", Name: "Jane", Body: "Thread", Date: "2024-02-01T00:00:00Z"}})
+
+	paths, err := a.WriteMonths("txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range paths {
+		names = append(names, filepath.Base(p))
+	}
+	if want := []string{"2024-01.txt", "2024-02.txt", "2024-03.txt", "unknown.txt"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got files %v, want %v", names, want)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "2024-02.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); !strings.HasPrefix(got, "Notes, February 2024\n") || !strings.Contains(got, "Threads\n") || strings.Contains(got, "Note 1") {
+		t.Errorf("got %q, want the threads of February only", got)
+	}
+}
+
+func TestFetchNotes(t *testing.T) {
+	// each page holds a note and a restacked post; the second page also holds the first note again
+	pages := map[string]string{
+		"": `{"items":[{"type":"comment","comment":{"id":3,"body":"Three"},"parentComments":[{"id":1,"body":"One"}]},{"type":"post"}],"nextCursor":"c1"}`,
+		"c1": `{"items":[{"type":"comment","comment":{"id":3,"body":"Three"}},{"type":"comment","comment":{"id":2,"body":"Two"}},` +
+			`{"type":"comment","comment":{"id":1,"body":"One"}}],"nextCursor":"c2"}`,
+		"c2": `{"items":[{"type":"comment","comment":{"id":0,"body":"Zero"}}]}`,
+	}
+	var requested []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/notes":
+			cursor := r.URL.Query().Get("cursor")
+			requested = append(requested, cursor)
+			fmt.Fprint(w, pages[cursor])
+		case "/api/v1/user/jane/public_profile":
+			json.NewEncoder(w).Encode(map[string]int{"id": 7})
+		case "/api/v1/reader/feed/profile/7":
+			if r.URL.Query().Get("types") != "note" {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, `{"items":[{"type":"comment","comment":{"id":9,"body":"Nine"}}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	e := newTestExtractor(t, filepath.Join(t.TempDir(), "downloaded_posts.log"))
+
+	ids := func(notes []Note) []int {
+		ids := []int{}
+		for _, n := range notes {
+			ids = append(ids, n.Id)
+		}
+		return ids
+	}
+
+	notes, err := e.FetchNotes(context.Background(), NotesSource{PubUrl: srv.URL}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ids(notes), []int{3, 2, 1, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got notes %v, want %v", got, want)
+	}
+	if len(notes[0].Context) != 1 || notes[0].Context[0].Id != 1 {
+		t.Errorf("got context %+v, want note 1", notes[0].Context)
+	}
+
+	// the feed stops at the page holding a known note
+	requested = nil
+	notes, err = e.FetchNotes(context.Background(), NotesSource{PubUrl: srv.URL}, map[int]bool{1: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ids(notes), []int{3, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got notes %v, want %v", got, want)
+	}
+	if want := []string{"", "c1"}; !reflect.DeepEqual(requested, want) {
+		t.Errorf("got pages %q, want %q", requested, want)
+	}
+
+	t.Run("author", func(t *testing.T) {
+		defer func(u string) { substackURL = u }(substackURL)
+		substackURL = srv.URL
+		notes, err := e.FetchNotes(context.Background(), NotesSource{Handle: "@jane"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := ids(notes), []int{9}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got notes %v, want %v", got, want)
+		}
+		if _, err := e.FetchNotes(context.Background(), NotesSource{Handle: "john"}, nil); err == nil {
+			t.Errorf("got no error for an unknown author")
+		}
+	})
+
+	if _, err := e.FetchNotes(context.Background(), NotesSource{}, nil); err == nil {
+		t.Errorf("got no error without a publication or an author")
+	}
+}
@@ -0,0 +1,123 @@
+package lib
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxCoverArtSize is the maximum size of the cover art embedded into podcast episodes.
+const maxCoverArtSize = 5 << 20
+
+// IsPodcast reports whether the Post is a podcast episode with an audio file.
+func (p *Post) IsPodcast() bool {
+	return p.PodcastUrl != ""
+}
+
+// PodcastDurationString returns the duration of the podcast episode, e.g. 1h2m3s, or "" if unknown.
+func (p *Post) PodcastDurationString() string {
+	if p.PodcastDuration <= 0 {
+		return ""
+	}
+	return (time.Duration(p.PodcastDuration * float64(time.Second))).Round(time.Second).String()
+}
+
+// podcastTags returns the ID3 tags describing the Post's podcast episode.
+func (p *Post) podcastTags() ID3Tags {
+	tags := ID3Tags{
+		Title:   p.Title,
+		Artist:  p.PublicationName,
+		Album:   p.PublicationName,
+		Genre:   "Podcast",
+		Comment: p.Description,
+	}
+	if len(p.PostDate) >= len("2006-01-02") {
+		tags.Date = p.PostDate[:len("2006-01-02")]
+	}
+	if p.PodcastEpisodeNumber > 0 {
+		tags.Track = strconv.Itoa(p.PodcastEpisodeNumber)
+	}
+	return tags
+}
+
+// downloadPodcast atomically downloads the audio of the Post's podcast episode into postFolder through the Fetcher f,
+// named after the post slug. MP3 files are tagged with the episode metadata and cover art.
+func downloadPodcast(ctx context.Context, f *Fetcher, p *Post, postFolder string) (MediaFile, error) {
+	body, header, err := f.FetchURLWithHeader(ctx, p.PodcastUrl)
+	if err != nil {
+		return MediaFile{}, fmt.Errorf("failed to download podcast audio: %w", err)
+	}
+	defer body.Close()
+
+	r := bufio.NewReaderSize(body, sniffLen)
+	head, _ := r.Peek(sniffLen) // a shorter head is fine for small files
+	mimeType := detectMediaType(header.Get("Content-Type"), head)
+	fileName := mediaFileName(p.Slug+".mp3", mimeType)
+
+	var tags *ID3Tags
+	if mimeType == "audio/mpeg" {
+		t := p.podcastTags()
+		t.CoverArt, t.CoverArtMIME = fetchCoverArt(ctx, f, p.podcastImageURL())
+		tags = &t
+	}
+
+	err = WriteFileAtomic(filepath.Join(postFolder, fileName), func(w io.Writer) error {
+		if tags != nil {
+			return WriteID3(w, r, *tags)
+		}
+		_, err := io.Copy(w, r)
+		return err
+	})
+	if err != nil {
+		return MediaFile{}, fmt.Errorf("failed to save podcast audio: %w", err)
+	}
+	return MediaFile{Path: fileName, MimeType: mimeType}, nil
+}
+
+// podcastImageURL returns the URL of the episode artwork, falling back to the post cover image.
+func (p *Post) podcastImageURL() string {
+	if p.PodcastEpisodeImageUrl != "" {
+		return p.PodcastEpisodeImageUrl
+	}
+	return p.CoverImage
+}
+
+// fetchCoverArt downloads the image at imageUrl to embed it as cover art.
+// Cover art is best effort: on failure, or if the image is too large, no cover art is returned.
+func fetchCoverArt(ctx context.Context, f *Fetcher, imageUrl string) ([]byte, string) {
+	if imageUrl == "" {
+		return nil, ""
+	}
+	body, header, err := f.FetchURLWithHeader(ctx, imageUrl)
+	if err != nil {
+		return nil, ""
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(body, maxCoverArtSize+1))
+	if err != nil || len(data) > maxCoverArtSize {
+		return nil, ""
+	}
+	mimeType := detectMediaType(header.Get("Content-Type"), data)
+	if !strings.HasPrefix(mimeType, "image/") {
+		return nil, ""
+	}
+	return data, mimeType
+}
+
+// podcastPlayerHTML returns the HTML of a player for the podcast episode saved as audioPath,
+// with a plain link to the file for formats that can't embed a player.
+func (p *Post) podcastPlayerHTML(audioPath string) string {
+	label := "Listen to the episode"
+	if d := p.PodcastDurationString(); d != "" {
+		label += " (" + d + ")"
+	}
+	src := html.EscapeString(localURL(audioPath))
+	return fmt.Sprintf("<div class=\"podcast-player\"><audio controls preload=\"none\" src=\"%s\"></audio><p><a href=\"%s\">%s</a></p></div>\n", src, src, label)
+}
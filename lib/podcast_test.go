@@ -0,0 +1,65 @@
+package lib
+
+import "testing"
+
+func TestPodcastTags(t *testing.T) {
+	tests := []struct {
+		name string
+		post Post
+		want ID3Tags
+	}{
+		{
+			name: "episode",
+			post: Post{Title: "Episode", PublicationName: "Pub", Description: "About", PostDate: "2024-01-02T10:00:00.000Z", PodcastEpisodeNumber: 12},
+			want: ID3Tags{Title: "Episode", Artist: "Pub", Album: "Pub", Genre: "Podcast", Comment: "About", Date: "2024-01-02", Track: "12"},
+		},
+		{
+			name: "no date or episode number",
+			post: Post{Title: "Episode", PublicationName: "Pub", PostDate: "2024"},
+			want: ID3Tags{Title: "Episode", Artist: "Pub", Album: "Pub", Genre: "Podcast"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.post.podcastTags()
+			if got.Title != tt.want.Title || got.Artist != tt.want.Artist || got.Album != tt.want.Album || got.Genre != tt.want.Genre ||
+				got.Comment != tt.want.Comment || got.Date != tt.want.Date || got.Track != tt.want.Track {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPodcastDurationString(t *testing.T) {
+	tests := []struct {
+		duration float64
+		want     string
+	}{
+		{0, ""},
+		{-1, ""},
+		{59.6, "1m0s"},
+		{3723, "1h2m3s"},
+	}
+	for _, tt := range tests {
+		p := Post{PodcastDuration: tt.duration}
+		if got := p.PodcastDurationString(); got != tt.want {
+			t.Errorf("PodcastDurationString() with %v = %q, want %q", tt.duration, got, tt.want)
+		}
+	}
+}
+
+func TestPodcastImageURL(t *testing.T) {
+	tests := []struct {
+		post Post
+		want string
+	}{
+		{Post{PodcastEpisodeImageUrl: "episode.png", CoverImage: "cover.png"}, "episode.png"},
+		{Post{CoverImage: "cover.png"}, "cover.png"},
+		{Post{}, ""},
+	}
+	for _, tt := range tests {
+		if got := tt.post.podcastImageURL(); got != tt.want {
+			t.Errorf("podcastImageURL() of %+v = %q, want %q", tt.post, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// PublicationFileName is the name of the file the metadata of a publication is saved to, at the root of its archive.
+const PublicationFileName = "publication.json"
+
+// Publication holds the metadata of a publication, as shown on its About page.
+type Publication struct {
+	Id           int       `json:"id"`
+	Name         string    `json:"name"`
+	Subtitle     string    `json:"subtitle,omitempty"`
+	Url          string    `json:"url"`
+	Subdomain    string    `json:"subdomain"`
+	CustomDomain string    `json:"custom_domain,omitempty"`
+	LogoUrl      string    `json:"logo_url,omitempty"`
+	Language     string    `json:"language,omitempty"`
+	Authors      []Author  `json:"authors"`
+	Sections     []Section `json:"sections"`
+	Tiers        []Tier    `json:"tiers"`
+	AboutHTML    string    `json:"about_html,omitempty"`
+	FetchedAt    time.Time `json:"fetched_at"`
+}
+
+// Author is a contributor of a publication.
+type Author struct {
+	Id       int    `json:"id"`
+	Name     string `json:"name"`
+	Handle   string `json:"handle,omitempty"`
+	Bio      string `json:"bio,omitempty"`
+	PhotoUrl string `json:"photo_url,omitempty"`
+	Role     string `json:"role,omitempty"`
+}
+
+// Section is a section of a publication.
+type Section struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name"`
+	Slug        string `json:"slug"`
+	Description string `json:"description,omitempty"`
+}
+
+// Tier is a paid subscription plan of a publication. Amount is in the smallest unit of Currency (e.g. cents).
+type Tier struct {
+	Id       string `json:"id"`
+	Name     string `json:"name,omitempty"`
+	Amount   int    `json:"amount"`
+	Currency string `json:"currency"`
+	Interval string `json:"interval"`
+}
+
+// publicationPreloads is the part of the data embedded in the pages of a publication describing it.
+type publicationPreloads struct {
+	Pub struct {
+		Id             int    `json:"id"`
+		Name           string `json:"name"`
+		Subdomain      string `json:"subdomain"`
+		CustomDomain   string `json:"custom_domain"`
+		HeroText       string `json:"hero_text"`
+		LogoUrl        string `json:"logo_url"`
+		Language       string `json:"language"`
+		AuthorId       int    `json:"author_id"`
+		AuthorName     string `json:"author_name"`
+		AuthorHandle   string `json:"author_handle"`
+		AuthorBio      string `json:"author_bio"`
+		AuthorPhotoUrl string `json:"author_photo_url"`
+		Contributors   []struct {
+			UserId   int    `json:"user_id"`
+			Name     string `json:"name"`
+			Handle   string `json:"handle"`
+			Bio      string `json:"bio"`
+			PhotoUrl string `json:"photo_url"`
+			Role     string `json:"role"`
+		} `json:"contributors"`
+		Sections []Section `json:"sections"`
+		Plans    []Tier    `json:"plans"`
+	} `json:"pub"`
+}
+
+// GetPublication returns the metadata of the publication at pubUrl, read from its About page.
+func (e *Extractor) GetPublication(ctx context.Context, pubUrl string) (Publication, error) {
+	u, err := url.Parse(pubUrl)
+	if err != nil {
+		return Publication{}, err
+	}
+	aboutUrl := url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/about"}
+	e.allowCookie(pubUrl)
+
+	body, err := e.fetcher.FetchURL(ctx, aboutUrl.String())
+	if err != nil {
+		return Publication{}, fmt.Errorf("failed to fetch about page: %w", err)
+	}
+	defer body.Close()
+
+	doc, err := goquery.NewDocumentFromReader(body)
+	if err != nil {
+		return Publication{}, fmt.Errorf("failed to fetch about page: %w", err)
+	}
+	jsonString, err := preloadsJSON(doc)
+	if err != nil {
+		return Publication{}, fmt.Errorf("failed to fetch about page: %w", err)
+	}
+	var preloads publicationPreloads
+	if err := json.Unmarshal([]byte(jsonString), &preloads); err != nil {
+		return Publication{}, fmt.Errorf("failed to decode publication: %w", err)
+	}
+	pub := preloads.Pub
+
+	p := Publication{
+		Id:           pub.Id,
+		Name:         pub.Name,
+		Subtitle:     pub.HeroText,
+		Url:          u.Scheme + "://" + u.Host,
+		Subdomain:    pub.Subdomain,
+		CustomDomain: pub.CustomDomain,
+		LogoUrl:      pub.LogoUrl,
+		Language:     pub.Language,
+		Authors:      []Author{},
+		Sections:     pub.Sections,
+		Tiers:        pub.Plans,
+		FetchedAt:    time.Now().UTC(),
+	}
+	if pub.CustomDomain != "" {
+		p.Url = "https://" + pub.CustomDomain
+	}
+	for _, c := range pub.Contributors {
+		p.Authors = append(p.Authors, Author{Id: c.UserId, Name: c.Name, Handle: c.Handle, Bio: c.Bio, PhotoUrl: c.PhotoUrl, Role: c.Role})
+	}
+	// the bio of the owner is only given alongside the publication
+	owner := Author{Id: pub.AuthorId, Name: pub.AuthorName, Handle: pub.AuthorHandle, Bio: pub.AuthorBio, PhotoUrl: pub.AuthorPhotoUrl}
+	found := false
+	for i, a := range p.Authors {
+		if owner.Id != 0 && a.Id == owner.Id {
+			found = true
+			if a.Bio == "" {
+				p.Authors[i].Bio = owner.Bio
+			}
+		}
+	}
+	if !found && owner.Name != "" {
+		p.Authors = append([]Author{owner}, p.Authors...)
+	}
+	if p.Sections == nil {
+		p.Sections = []Section{}
+	}
+	if p.Tiers == nil {
+		p.Tiers = []Tier{}
+	}
+
+	if about := doc.Find("div.body.markup").First(); about.Length() > 0 {
+		aboutHTML, err := about.Html()
+		if err == nil {
+			p.AboutHTML = strings.TrimSpace(aboutHTML)
+		}
+	}
+	return p, nil
+}
+
+// Save writes the publication metadata to PublicationFileName in folder.
+func (p Publication) Save(folder string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(filepath.Join(folder, PublicationFileName), func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
@@ -0,0 +1,199 @@
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Subscription represents a publication to keep in sync, with optional per-entry overrides.
+type Subscription struct {
+	Url    string
+	Format string
+	Before string
+	After  string
+	// Filter holds the criteria selecting the posts of the publication, other than their publication date,
+	// which is set by the Before and After date expressions.
+	Filter PostFilter
+}
+
+// Folder returns the name of the subfolder the publication is downloaded into.
+// It is the host of the publication URL, e.g. https://example.substack.com -> example.substack.com
+func (s Subscription) Folder() string {
+	u, err := url.Parse(s.Url)
+	if err != nil || u.Hostname() == "" {
+		return extractLastSegment(s.Url)
+	}
+	return u.Hostname()
+}
+
+// String returns the subscription in the subscription list format.
+func (s Subscription) String() string {
+	fields := []string{s.Url}
+	if s.Format != "" {
+		fields = append(fields, "format="+s.Format)
+	}
+	if s.Before != "" {
+		fields = append(fields, "before="+s.Before)
+	}
+	if s.After != "" {
+		fields = append(fields, "after="+s.After)
+	}
+	f := s.Filter
+	lists := []struct {
+		key    string
+		values []string
+	}{{"type", f.Types}, {"author", f.Authors}, {"section", f.Sections}, {"tag", f.Tags}, {"slug", f.Slugs}}
+	for _, l := range lists {
+		if len(l.values) > 0 {
+			fields = append(fields, l.key+"="+strings.Join(l.values, ","))
+		}
+	}
+	if f.Audience != "" {
+		fields = append(fields, "audience="+f.Audience)
+	}
+	if f.Pattern != nil {
+		fields = append(fields, "match="+f.Pattern.String())
+	}
+	if f.MinWordCount > 0 {
+		fields = append(fields, "min-words="+strconv.Itoa(f.MinWordCount))
+	}
+	if f.Newest > 0 {
+		fields = append(fields, "newest="+strconv.Itoa(f.Newest))
+	}
+	if f.Oldest > 0 {
+		fields = append(fields, "oldest="+strconv.Itoa(f.Oldest))
+	}
+	return strings.Join(fields, " ")
+}
+
+// ReadSubscriptions reads a subscription list from the given file.
+func ReadSubscriptions(path string) ([]Subscription, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ParseSubscriptions(file)
+}
+
+// ParseSubscriptions parses a subscription list: one publication URL per line,
+// optionally followed by space-separated format=, before= and after= overrides,
+// and the criteria of the post filter: type=, audience=, author=, section=, tag=, match= (a regular expression),
+// min-words=, slug=, newest= and oldest=. Lists are separated by commas, e.g. type=podcast,video.
+// Formats must be one of OutputFormats and dates expressions understood by ParseDateFilter.
+// Blank lines and lines starting with # are ignored.
+func ParseSubscriptions(r io.Reader) ([]Subscription, error) {
+	var subs []Subscription
+
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		sub := Subscription{Url: fields[0]}
+		for _, field := range fields[1:] {
+			key, val, ok := strings.Cut(field, "=")
+			if !ok {
+				return nil, fmt.Errorf("line %d: invalid option %q, expected key=value", lineNum, field)
+			}
+			switch key {
+			case "format":
+				sub.Format = val
+			case "before":
+				sub.Before = val
+			case "after":
+				sub.After = val
+			case "type":
+				sub.Filter.Types = splitList(val)
+			case "audience":
+				sub.Filter.Audience = val
+			case "author":
+				sub.Filter.Authors = splitList(val)
+			case "section":
+				sub.Filter.Sections = splitList(val)
+			case "tag":
+				sub.Filter.Tags = splitList(val)
+			case "slug":
+				sub.Filter.Slugs = splitList(val)
+			case "match":
+				pattern, err := regexp.Compile(val)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: invalid match pattern: %w", lineNum, err)
+				}
+				sub.Filter.Pattern = pattern
+			case "min-words", "newest", "oldest":
+				n, err := strconv.Atoi(val)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: invalid %s: %q is not a number", lineNum, key, val)
+				}
+				switch key {
+				case "min-words":
+					sub.Filter.MinWordCount = n
+				case "newest":
+					sub.Filter.Newest = n
+				case "oldest":
+					sub.Filter.Oldest = n
+				}
+			default:
+				return nil, fmt.Errorf("line %d: unknown option %q", lineNum, key)
+			}
+		}
+		if err := sub.Filter.Validate(); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		if sub.Format != "" && !slices.Contains(OutputFormats, sub.Format) {
+			return nil, fmt.Errorf("line %d: unknown format %q (options: %s)", lineNum, sub.Format, strings.Join(OutputFormats, ", "))
+		}
+		// last-run is resolved when syncing: without a previous run, it leaves its side open here
+		if _, err := ParseDateFilter(sub.After, sub.Before, false, DateContext{}); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		subs = append(subs, sub)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return subs, nil
+}
+
+// WriteSubscriptions writes the subscriptions to w in the subscription list format.
+func WriteSubscriptions(w io.Writer, subs []Subscription) error {
+	for _, sub := range subs {
+		if _, err := fmt.Fprintln(w, sub.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitList splits a comma-separated list, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// extractLastSegment returns the last non-empty path segment of s.
+func extractLastSegment(s string) string {
+	segments := strings.Split(strings.Trim(s, "/"), "/")
+	return segments[len(segments)-1]
+}
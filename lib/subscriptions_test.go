@@ -0,0 +1,133 @@
+package lib
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSubscriptions(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []Subscription
+	}{
+		{
+			name:  "urls",
+			input: "https://a.substack.com\nhttps://b.substack.com\n",
+			want:  []Subscription{{Url: "https://a.substack.com"}, {Url: "https://b.substack.com"}},
+		},
+		{
+			name:  "blank lines and comments",
+			input: "# newsletters\n\n  https://a.substack.com  \n# https://b.substack.com\n",
+			want:  []Subscription{{Url: "https://a.substack.com"}},
+		},
+		{
+			name:  "overrides",
+			input: "https://a.substack.com format=md before=2024-01-01 after=last-run",
+			want:  []Subscription{{Url: "https://a.substack.com", Format: "md", Before: "2024-01-01", After: "last-run"}},
+		},
+		{
+			name:  "filter",
+			input: "https://a.substack.com type=podcast,video audience=paid author=jane section=news, tag=go slug=a,b min-words=100 newest=5",
+			want: []Subscription{{Url: "https://a.substack.com", Filter: PostFilter{
+				Types:        []string{"podcast", "video"},
+				Audience:     "paid",
+				Authors:      []string{"jane"},
+				Sections:     []string{"news"},
+				Tags:         []string{"go"},
+				Slugs:        []string{"a", "b"},
+				MinWordCount: 100,
+				Newest:       5,
+			}}},
+		},
+		{
+			name:  "empty",
+			input: "\n# nothing\n",
+			want:  nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSubscriptions(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSubscriptionsMatch(t *testing.T) {
+	subs, err := ParseSubscriptions(strings.NewReader(`https://a.substack.com match=^weekly-\d+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := subs[0].Filter.Pattern; p == nil || !p.MatchString("weekly-12") || p.MatchString("monthly-1") {
+		t.Errorf("got pattern %v, want ^weekly-\\d+", p)
+	}
+}
+
+func TestParseSubscriptionsErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"option without value", "https://a.substack.com md", `line 1: invalid option "md"`},
+		{"unknown option", "https://a.substack.com color=red", `line 1: unknown option "color"`},
+		{"unknown format", "https://a.substack.com format=pdf", `line 1: unknown format "pdf"`},
+		{"invalid date", "# list\nhttps://a.substack.com after=yesterday", `line 2: invalid date: "yesterday"`},
+		{"dates in the wrong order", "https://a.substack.com after=2024-02-01 before=2024-01-01", "line 1: the after date"},
+		{"unknown type", "https://a.substack.com type=essay", "line 1: unknown post type: essay"},
+		{"unknown audience", "https://a.substack.com audience=founding", "line 1: unknown audience: founding"},
+		{"invalid number", "https://a.substack.com newest=ten", `line 1: invalid newest: "ten" is not a number`},
+		{"newest and oldest", "https://a.substack.com newest=1 oldest=1", "line 1: the newest and oldest limits"},
+		{"invalid pattern", "https://a.substack.com match=(", "line 1: invalid match pattern"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseSubscriptions(strings.NewReader(tt.input))
+			if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscriptionString(t *testing.T) {
+	lines := []string{
+		"https://a.substack.com",
+		"https://a.substack.com format=md before=2024-01-01 after=last-run",
+		"https://a.substack.com type=podcast,video author=jane section=news tag=go,rust slug=a audience=free match=^a min-words=10 newest=3",
+		"https://a.substack.com oldest=2",
+	}
+	for _, line := range lines {
+		subs, err := ParseSubscriptions(strings.NewReader(line))
+		if err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+		if got := subs[0].String(); got != line {
+			t.Errorf("got %q, want %q", got, line)
+		}
+	}
+}
+
+func TestSubscriptionFolder(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://example.substack.com", "example.substack.com"},
+		{"https://www.example.com/", "www.example.com"},
+		{"https://example.com:8080/path", "example.com"},
+		{"example", "example"},
+	}
+	for _, tt := range tests {
+		if got := (Subscription{Url: tt.url}).Folder(); got != tt.want {
+			t.Errorf("Folder(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
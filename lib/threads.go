@@ -0,0 +1,231 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Thread is a community thread of a publication (Substack Chat): a message by the author or a subscriber, with its replies.
+type Thread struct {
+	Id        string        `json:"id"`
+	Body      string        `json:"body"`
+	Date      string        `json:"date"`
+	Name      string        `json:"name"`
+	Handle    string        `json:"handle,omitempty"`
+	MediaUrls []string      `json:"media_urls,omitempty"`
+	Replies   []ThreadReply `json:"replies,omitempty"`
+}
+
+// ThreadReply is a reply to a community thread.
+type ThreadReply struct {
+	Id     string `json:"id"`
+	Body   string `json:"body"`
+	Date   string `json:"date"`
+	Name   string `json:"name"`
+	Handle string `json:"handle,omitempty"`
+}
+
+// threadUser is the author of a thread or a reply, as returned by the community API.
+type threadUser struct {
+	Name   string `json:"name"`
+	Handle string `json:"handle"`
+}
+
+// threadsPage is a page of the threads of a publication, newest first.
+type threadsPage struct {
+	Threads []struct {
+		CommunityPost struct {
+			Id          string `json:"id"`
+			Body        string `json:"body"`
+			CreatedAt   string `json:"created_at"`
+			MediaAssets []struct {
+				Url string `json:"url"`
+			} `json:"media_assets"`
+		} `json:"communityPost"`
+		User threadUser `json:"user"`
+	} `json:"threads"`
+	More bool `json:"more"`
+}
+
+// threadRepliesPage is a page of the replies to a thread, oldest first.
+type threadRepliesPage struct {
+	Replies []struct {
+		Comment struct {
+			Id        string `json:"id"`
+			Body      string `json:"body"`
+			CreatedAt string `json:"created_at"`
+		} `json:"comment"`
+		User threadUser `json:"user"`
+	} `json:"replies"`
+	More bool `json:"more"`
+}
+
+// FetchThreads returns the community threads of the publication at pubUrl, newest first, with their replies.
+// Threads are usually only visible to subscribers, so the fetcher needs the session cookie of one.
+// The threads are paged through until their end, or until a page holding a thread whose id is in known:
+// the threads after it were archived by a previous run.
+func (e *Extractor) FetchThreads(ctx context.Context, pubUrl string, known map[string]bool) ([]Thread, error) {
+	pub, err := e.GetPublication(ctx, pubUrl)
+	if err != nil {
+		return nil, err
+	}
+	if pub.Id == 0 {
+		return nil, fmt.Errorf("failed to find the id of the publication at %s", pubUrl)
+	}
+	feedUrl, err := url.Parse(substackURL)
+	if err != nil {
+		return nil, err
+	}
+	feedUrl.Path = "/api/v1/community/publications/" + strconv.Itoa(pub.Id) + "/posts"
+
+	threads := []Thread{}
+	before := ""
+	for {
+		pageUrl := *feedUrl
+		if before != "" {
+			pageUrl.RawQuery = url.Values{"before": {before}}.Encode()
+		}
+		var page threadsPage
+		if err := e.fetchJSON(ctx, pageUrl.String(), &page); err != nil {
+			return nil, fmt.Errorf("failed to fetch threads: %w", err)
+		}
+		reachedKnown := false
+		for _, item := range page.Threads {
+			post := item.CommunityPost
+			if known[post.Id] {
+				reachedKnown = true
+				continue
+			}
+			t := Thread{Id: post.Id, Body: post.Body, Date: post.CreatedAt, Name: item.User.Name, Handle: item.User.Handle}
+			for _, asset := range post.MediaAssets {
+				if asset.Url != "" {
+					t.MediaUrls = append(t.MediaUrls, asset.Url)
+				}
+			}
+			if t.Replies, err = e.fetchThreadReplies(ctx, post.Id); err != nil {
+				return nil, err
+			}
+			threads = append(threads, t)
+		}
+		e.logger.Debug("fetched threads", "url", pageUrl.String(), "count", len(threads))
+
+		if reachedKnown || !page.More || len(page.Threads) == 0 {
+			return threads, nil
+		}
+		last := page.Threads[len(page.Threads)-1].CommunityPost.CreatedAt
+		if last == "" || last == before {
+			return threads, nil
+		}
+		before = last
+	}
+}
+
+// fetchThreadReplies returns all the replies to the thread with the given id, oldest first.
+func (e *Extractor) fetchThreadReplies(ctx context.Context, threadId string) ([]ThreadReply, error) {
+	repliesUrl, err := url.Parse(substackURL)
+	if err != nil {
+		return nil, err
+	}
+	repliesUrl.Path = "/api/v1/community/posts/" + url.PathEscape(threadId) + "/comments"
+
+	var replies []ThreadReply
+	after := ""
+	for {
+		q := url.Values{"order": {"asc"}}
+		if after != "" {
+			q.Set("after", after)
+		}
+		repliesUrl.RawQuery = q.Encode()
+		var page threadRepliesPage
+		if err := e.fetchJSON(ctx, repliesUrl.String(), &page); err != nil {
+			return nil, fmt.Errorf("failed to fetch the replies to thread %s: %w", threadId, err)
+		}
+		for _, item := range page.Replies {
+			c := item.Comment
+			replies = append(replies, ThreadReply{Id: c.Id, Body: c.Body, Date: c.CreatedAt, Name: item.User.Name, Handle: item.User.Handle})
+		}
+		if !page.More || len(page.Replies) == 0 {
+			return replies, nil
+		}
+		last := page.Replies[len(page.Replies)-1].Comment.CreatedAt
+		if last == "" || last == after {
+			return replies, nil
+		}
+		after = last
+	}
+}
+
+// fetchJSON fetches the JSON document at pageUrl and decodes it into v.
+func (e *Extractor) fetchJSON(ctx context.Context, pageUrl string, v any) error {
+	body, err := e.fetcher.FetchURL(ctx, pageUrl)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return json.NewDecoder(body).Decode(v)
+}
+
+// comment returns the thread as a comment without id, to be rendered like notes, with no link to Substack.
+func (t Thread) comment() Comment {
+	return Comment{Body: t.Body, Date: t.Date, Name: t.Name, Handle: t.Handle}
+}
+
+// comment returns the reply as a comment without id, to be rendered like notes, with no link to Substack.
+func (r ThreadReply) comment() Comment {
+	return Comment{Body: r.Body, Date: r.Date, Name: r.Name, Handle: r.Handle}
+}
+
+// threadsHTML returns the HTML of threads, their replies being nested in a block quote.
+func threadsHTML(threads []Thread) string {
+	if len(threads) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("<h2>Threads</h2>\n")
+	for _, t := range threads {
+		fmt.Fprintf(&sb, "<div class=\"thread\" id=\"thread-%s\">\n", html.EscapeString(t.Id))
+		writeNoteHTML(&sb, t.comment())
+		for _, mediaUrl := range t.MediaUrls {
+			fmt.Fprintf(&sb, "<p><img src=\"%s\"></p>\n", html.EscapeString(mediaUrl))
+		}
+		if len(t.Replies) > 0 {
+			sb.WriteString("<blockquote class=\"thread-replies\">\n")
+			for _, r := range t.Replies {
+				writeNoteHTML(&sb, r.comment())
+			}
+			sb.WriteString("</blockquote>\n")
+		}
+		sb.WriteString("</div>\n<hr>\n")
+	}
+	return sb.String()
+}
+
+// threadsText returns threads as plain text, their replies indented.
+// Images are referred to by their downloaded file in media, or their url.
+func threadsText(threads []Thread, media map[string]MediaFile) string {
+	if len(threads) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("\nThreads\n")
+	for _, t := range threads {
+		writeNoteText(&sb, t.comment(), "")
+		for _, mediaUrl := range t.MediaUrls {
+			image := mediaUrl
+			if file, ok := media[mediaUrl]; ok {
+				image = file.Path
+			}
+			sb.WriteString("Image: " + image + "\n")
+		}
+		for _, r := range t.Replies {
+			writeNoteText(&sb, r.comment(), "    ")
+		}
+		sb.WriteString("\n---\n")
+	}
+	return sb.String()
+}
@@ -2,7 +2,10 @@ package lib
 
 import (
 	"bufio"
+	"io"
 	"os"
+	"path/filepath"
+	"runtime"
 )
 
 func ReadLogFile(logFile string) (map[string]struct{}, error) {
@@ -42,27 +45,60 @@ func WriteLogFile(logFile string, downloadedPosts []string) error {
 			return err
 		}
 	}
-	return writer.Flush()
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return file.Sync()
 }
 
-// DateFilterFunc defines a function type for filtering dates.
-type DateFilterFunc func(string) bool
+// WriteFileAtomic writes the content produced by write to path.
+// The content is written to a temporary file in the same folder, synced to disk and then renamed into place,
+// so path either keeps its previous content or holds the complete new one, even if the process crashes.
+func WriteFileAtomic(path string, write func(w io.Writer) error) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
 
-// MakeDateFilterFunc creates a date filter function based on before and after dates.
-func MakeDateFilterFunc(beforeDate string, afterDate string) DateFilterFunc {
-	var dateFilterFunc DateFilterFunc
-	if beforeDate != "" && afterDate != "" {
-		dateFilterFunc = func(date string) bool {
-			return date > afterDate && date < beforeDate
-		}
-	} else if beforeDate != "" {
-		dateFilterFunc = func(date string) bool {
-			return date < beforeDate
-		}
-	} else if afterDate != "" {
-		dateFilterFunc = func(date string) bool {
-			return date > afterDate
-		}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once the file has been renamed
+
+	err = write(tmp)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
 	}
-	return dateFilterFunc
+	if err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(dir)
 }
+
+// syncDir flushes the directory entry changes (e.g. a rename) of dir to disk.
+// Directories can't be synced on Windows, where this is a no-op.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// DateFilterFunc defines a function type for filtering dates.
+type DateFilterFunc func(string) bool
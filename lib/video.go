@@ -0,0 +1,342 @@
+package lib
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// VideoUpload represents a video hosted by Substack, attached to a video post.
+type VideoUpload struct {
+	Id       string  `json:"id"`
+	Duration float64 `json:"duration"`
+}
+
+// videoEmbedSelector matches the Substack-hosted video embeds in a post body.
+const videoEmbedSelector = `.native-video-embed, [data-component-name="VideoPlaceholder"]`
+
+// videoEmbedAttrs holds the attributes of a Substack-hosted video embed (its data-attrs JSON).
+type videoEmbedAttrs struct {
+	MediaUploadId string `json:"mediaUploadId"`
+}
+
+// hlsPlaylist is a parsed HLS playlist: either a master playlist listing variants,
+// or a media playlist listing segments.
+type hlsPlaylist struct {
+	variants    []hlsVariant
+	segments    []string
+	initSegment string // fMP4 initialization segment (EXT-X-MAP), if any
+	encrypted   bool
+}
+
+// hlsVariant is a variant stream of a master playlist.
+type hlsVariant struct {
+	uri       string
+	bandwidth int
+}
+
+// videoUploadID returns the ID of the video attached to a video post, if any.
+func (p *Post) videoUploadID() string {
+	if p.VideoUpload != nil && p.VideoUpload.Id != "" {
+		return p.VideoUpload.Id
+	}
+	return p.VideoUploadId
+}
+
+// videoPlaylistURL returns the URL of the HLS playlist of a Substack-hosted video,
+// served by the publication the post at postUrl belongs to.
+func videoPlaylistURL(postUrl string, uploadID string) (string, error) {
+	u, err := url.Parse(postUrl)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid post url: %s", postUrl)
+	}
+	return fmt.Sprintf("%s://%s/api/v1/video/upload/%s/src?type=hls", u.Scheme, u.Host, url.PathEscape(uploadID)), nil
+}
+
+// downloadVideos downloads the Substack-hosted videos of the post (the video of a video post
+// and the videos embedded in its body) into postFolder, or the MediaStore s if not nil,
+// and rewrites the body to play the local files.
+// It returns the downloaded files and the errors of the videos that couldn't be downloaded, keyed by playlist URL.
+func downloadVideos(ctx context.Context, f *Fetcher, s *MediaStore, p *Post, postFolder string) (map[string]MediaFile, MediaErrors) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(p.BodyHTML))
+	if err != nil {
+		return nil, MediaErrors{p.CanonicalUrl: err}
+	}
+
+	files := make(map[string]MediaFile)
+	videoErrs := make(MediaErrors)
+	download := func(uploadID string) (MediaFile, bool) {
+		playlistUrl, err := videoPlaylistURL(p.CanonicalUrl, uploadID)
+		if err != nil {
+			videoErrs[uploadID] = err
+			return MediaFile{}, false
+		}
+		if file, ok := files[playlistUrl]; ok {
+			return file, true
+		}
+		file, err := s.save(playlistUrl, postFolder, func(folder string) (MediaFile, error) {
+			return downloadHLS(ctx, f, playlistUrl, folder, "video-"+uploadID)
+		})
+		if err != nil {
+			videoErrs[playlistUrl] = err
+			return MediaFile{}, false
+		}
+		files[playlistUrl] = file
+		return file, true
+	}
+
+	embedded := make(map[string]bool)
+	doc.Find(videoEmbedSelector).Each(func(i int, s *goquery.Selection) {
+		var attrs videoEmbedAttrs
+		if err := json.Unmarshal([]byte(s.AttrOr("data-attrs", "")), &attrs); err != nil || attrs.MediaUploadId == "" {
+			return
+		}
+		embedded[attrs.MediaUploadId] = true
+		if file, ok := download(attrs.MediaUploadId); ok {
+			s.ReplaceWithHtml(videoPlayerHTML(file))
+		}
+	})
+
+	if id := p.videoUploadID(); id != "" && !embedded[id] {
+		if file, ok := download(id); ok {
+			doc.Find("body").PrependHtml(videoPlayerHTML(file))
+		}
+	}
+
+	if len(files) > 0 {
+		body, err := doc.Find("body").Html()
+		if err != nil {
+			videoErrs[p.CanonicalUrl] = err
+		} else {
+			p.BodyHTML = body
+		}
+	}
+
+	if len(videoErrs) == 0 {
+		return files, nil
+	}
+	return files, videoErrs
+}
+
+// videoPlayerHTML returns the HTML of a player for the downloaded video file.
+// Browsers can't play MPEG-TS files, which only get a link to open them with a media player.
+func videoPlayerHTML(file MediaFile) string {
+	src := html.EscapeString(localURL(file.Path))
+	if file.MimeType == "video/mp2t" {
+		return fmt.Sprintf("<div class=\"video-player\"><p><a href=\"%s\" type=\"video/mp2t\">Download the video</a> (MPEG-TS, play it with a media player such as VLC)</p></div>", src)
+	}
+	return fmt.Sprintf("<div class=\"video-player\"><video controls preload=\"metadata\" src=\"%s\"></video><p><a href=\"%s\">Watch the video</a></p></div>", src, src)
+}
+
+// downloadHLS downloads the HLS stream at playlistUrl through the Fetcher f and concatenates its segments
+// into a single file in outputFolder, named baseName with the extension of the container (.ts or .mp4).
+// MPEG-TS streams are remuxed to MP4 when ffmpeg is available, so that browsers can play them.
+// For master playlists, the variant with the highest bandwidth is downloaded. Encrypted streams are not supported.
+func downloadHLS(ctx context.Context, f *Fetcher, playlistUrl string, outputFolder string, baseName string) (MediaFile, error) {
+	playlist, err := fetchPlaylist(ctx, f, playlistUrl)
+	if err != nil {
+		return MediaFile{}, err
+	}
+
+	if len(playlist.variants) > 0 {
+		best := playlist.variants[0]
+		for _, v := range playlist.variants[1:] {
+			if v.bandwidth > best.bandwidth {
+				best = v
+			}
+		}
+		playlist, err = fetchPlaylist(ctx, f, best.uri)
+		if err != nil {
+			return MediaFile{}, err
+		}
+	}
+
+	if playlist.encrypted {
+		return MediaFile{}, errors.New("failed to download video: encrypted streams are not supported")
+	}
+	if len(playlist.segments) == 0 {
+		return MediaFile{}, errors.New("failed to download video: playlist has no segments")
+	}
+
+	// TS segments can be concatenated as-is; fMP4 segments too, after their initialization segment
+	segments := playlist.segments
+	mimeType := "video/mp2t"
+	if playlist.initSegment != "" {
+		segments = append([]string{playlist.initSegment}, segments...)
+		mimeType = "video/mp4"
+	}
+	fileName := sanitizeFileName(baseName) + extensionForType(mimeType)
+
+	err = WriteFileAtomic(filepath.Join(outputFolder, fileName), func(w io.Writer) error {
+		for _, segment := range segments {
+			if err := copySegment(ctx, f, segment, w); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return MediaFile{}, fmt.Errorf("failed to download video: %w", err)
+	}
+	if mimeType == "video/mp2t" {
+		mp4Name := sanitizeFileName(baseName) + extensionForType("video/mp4")
+		if remuxToMP4(ctx, filepath.Join(outputFolder, fileName), filepath.Join(outputFolder, mp4Name)) == nil {
+			return MediaFile{Path: mp4Name, MimeType: "video/mp4"}, nil
+		}
+	}
+	return MediaFile{Path: fileName, MimeType: mimeType}, nil
+}
+
+// remuxToMP4 copies the streams of the MPEG-TS file at tsPath into an MP4 file at mp4Path with ffmpeg,
+// without re-encoding them, and removes tsPath. It fails if ffmpeg isn't installed, leaving tsPath untouched.
+func remuxToMP4(ctx context.Context, tsPath string, mp4Path string) error {
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return err
+	}
+	// written under a temporary name, so that an interrupted remux doesn't leave a partial file
+	tmpPath := mp4Path + ".part"
+	cmd := exec.CommandContext(ctx, ffmpeg, "-nostdin", "-loglevel", "error", "-y", "-i", tsPath,
+		"-c", "copy", "-bsf:a", "aac_adtstoasc", "-movflags", "+faststart", "-f", "mp4", tmpPath)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to remux video: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	if err := os.Rename(tmpPath, mp4Path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Remove(tsPath)
+}
+
+// copySegment downloads the segment at segmentUrl and appends it to w.
+func copySegment(ctx context.Context, f *Fetcher, segmentUrl string, w io.Writer) error {
+	body, err := f.FetchURL(ctx, segmentUrl)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	_, err = io.Copy(w, body)
+	return err
+}
+
+// fetchPlaylist downloads and parses the HLS playlist at playlistUrl.
+func fetchPlaylist(ctx context.Context, f *Fetcher, playlistUrl string) (hlsPlaylist, error) {
+	base, err := url.Parse(playlistUrl)
+	if err != nil {
+		return hlsPlaylist{}, err
+	}
+	body, err := f.FetchURL(ctx, playlistUrl)
+	if err != nil {
+		return hlsPlaylist{}, fmt.Errorf("failed to fetch video playlist: %w", err)
+	}
+	defer body.Close()
+	return parsePlaylist(base, body)
+}
+
+// parsePlaylist parses an HLS playlist, resolving the URIs it contains against base.
+func parsePlaylist(base *url.URL, r io.Reader) (hlsPlaylist, error) {
+	var playlist hlsPlaylist
+	resolve := func(uri string) string {
+		ref, err := url.Parse(uri)
+		if err != nil {
+			return uri
+		}
+		return base.ResolveReference(ref).String()
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	first := true
+	pendingVariant := false
+	var variant hlsVariant
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if first {
+			if line != "#EXTM3U" {
+				return hlsPlaylist{}, errors.New("invalid video playlist: missing #EXTM3U header")
+			}
+			first = false
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:"):
+			attrs := parseHLSAttributes(strings.TrimPrefix(line, "#EXT-X-STREAM-INF:"))
+			variant = hlsVariant{}
+			variant.bandwidth, _ = strconv.Atoi(attrs["BANDWIDTH"])
+			pendingVariant = true
+		case strings.HasPrefix(line, "#EXT-X-KEY:"):
+			attrs := parseHLSAttributes(strings.TrimPrefix(line, "#EXT-X-KEY:"))
+			if attrs["METHOD"] != "" && attrs["METHOD"] != "NONE" {
+				playlist.encrypted = true
+			}
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			attrs := parseHLSAttributes(strings.TrimPrefix(line, "#EXT-X-MAP:"))
+			if uri := attrs["URI"]; uri != "" {
+				playlist.initSegment = resolve(uri)
+			}
+		case strings.HasPrefix(line, "#"):
+			// other tags and comments are irrelevant for downloading
+		case pendingVariant:
+			variant.uri = resolve(line)
+			playlist.variants = append(playlist.variants, variant)
+			pendingVariant = false
+		default:
+			playlist.segments = append(playlist.segments, resolve(line))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return hlsPlaylist{}, err
+	}
+	if first {
+		return hlsPlaylist{}, errors.New("invalid video playlist: empty")
+	}
+
+	return playlist, nil
+}
+
+// parseHLSAttributes parses an HLS attribute list, e.g. BANDWIDTH=1280000,CODECS="avc1.4d401f,mp4a.40.2"
+func parseHLSAttributes(s string) map[string]string {
+	attrs := make(map[string]string)
+	for s != "" {
+		eq := strings.IndexByte(s, '=')
+		if eq == -1 {
+			break
+		}
+		key := strings.TrimSpace(s[:eq])
+		s = s[eq+1:]
+
+		var val string
+		if strings.HasPrefix(s, `"`) {
+			end := strings.IndexByte(s[1:], '"')
+			if end == -1 {
+				val, s = s[1:], ""
+			} else {
+				val, s = s[1:end+1], s[end+2:]
+			}
+		} else if comma := strings.IndexByte(s, ','); comma != -1 {
+			val, s = s[:comma], s[comma:]
+		} else {
+			val, s = s, ""
+		}
+		attrs[key] = val
+		s = strings.TrimPrefix(s, ",")
+	}
+	return attrs
+}
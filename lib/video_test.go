@@ -0,0 +1,236 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestParseHLSAttributes(t *testing.T) {
+	tests := []struct {
+		in   string
+		want map[string]string
+	}{
+		{"BANDWIDTH=1280000", map[string]string{"BANDWIDTH": "1280000"}},
+		{`BANDWIDTH=1280000,CODECS="avc1.4d401f,mp4a.40.2",RESOLUTION=1280x720`,
+			map[string]string{"BANDWIDTH": "1280000", "CODECS": "avc1.4d401f,mp4a.40.2", "RESOLUTION": "1280x720"}},
+		{`METHOD=AES-128,URI="https://example.com/key?a=1,b=2",IV=0x1`,
+			map[string]string{"METHOD": "AES-128", "URI": "https://example.com/key?a=1,b=2", "IV": "0x1"}},
+		{`URI="init.mp4"`, map[string]string{"URI": "init.mp4"}},
+		{`URI="unterminated`, map[string]string{"URI": "unterminated"}},
+		{"", map[string]string{}},
+		{"NOVALUE", map[string]string{}},
+	}
+	for _, tt := range tests {
+		if got := parseHLSAttributes(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseHLSAttributes(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParsePlaylist(t *testing.T) {
+	base, _ := url.Parse("https://cdn.example.com/video/abc/master.m3u8?token=x")
+
+	tests := []struct {
+		name     string
+		playlist string
+		want     hlsPlaylist
+	}{
+		{
+			name: "master",
+			playlist: "#EXTM3U\n#EXT-X-VERSION:3\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360\nlow/index.m3u8\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=2400000,CODECS=\"avc1.4d401f,mp4a.40.2\"\n\nhttps://other.example.com/high.m3u8\n",
+			want: hlsPlaylist{variants: []hlsVariant{
+				{uri: "https://cdn.example.com/video/abc/low/index.m3u8", bandwidth: 800000},
+				{uri: "https://other.example.com/high.m3u8", bandwidth: 2400000},
+			}},
+		},
+		{
+			name: "media",
+			playlist: "#EXTM3U\r\n#EXT-X-TARGETDURATION:10\r\n#EXTINF:10.0,\r\nseg0.ts\r\n#EXTINF:10.0,\r\n/abs/seg1.ts\r\n" +
+				"#EXT-X-KEY:METHOD=NONE\r\n#EXT-X-ENDLIST\r\n",
+			want: hlsPlaylist{segments: []string{
+				"https://cdn.example.com/video/abc/seg0.ts",
+				"https://cdn.example.com/abs/seg1.ts",
+			}},
+		},
+		{
+			name:     "fmp4",
+			playlist: "#EXTM3U\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:6,\nseg0.m4s\n",
+			want: hlsPlaylist{
+				initSegment: "https://cdn.example.com/video/abc/init.mp4",
+				segments:    []string{"https://cdn.example.com/video/abc/seg0.m4s"},
+			},
+		},
+		{
+			name:     "encrypted",
+			playlist: "#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"key\"\n#EXTINF:6,\nseg0.ts\n",
+			want: hlsPlaylist{
+				segments:  []string{"https://cdn.example.com/video/abc/seg0.ts"},
+				encrypted: true,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePlaylist(base, strings.NewReader(tt.playlist))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePlaylistErrors(t *testing.T) {
+	base, _ := url.Parse("https://cdn.example.com/master.m3u8")
+	tests := []struct {
+		name     string
+		playlist string
+		want     string
+	}{
+		{"empty", "\n\n", "invalid video playlist: empty"},
+		{"missing header", "<html>Not found</html>", "invalid video playlist: missing #EXTM3U header"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parsePlaylist(base, strings.NewReader(tt.playlist))
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVideoPlaylistURL(t *testing.T) {
+	got, err := videoPlaylistURL("https://example.substack.com/p/post?utm=x", "a b/c")
+	if want := "https://example.substack.com/api/v1/video/upload/a%20b%2Fc/src?type=hls"; err != nil || got != want {
+		t.Errorf("got %q, %v, want %q", got, err, want)
+	}
+	if _, err := videoPlaylistURL("/p/post", "abc"); err == nil {
+		t.Errorf("got no error for a post url without host")
+	}
+}
+
+func TestVideoPlayerHTML(t *testing.T) {
+	tests := []struct {
+		file MediaFile
+		want []string
+	}{
+		{MediaFile{Path: "video-a.mp4", MimeType: "video/mp4"}, []string{`<video controls preload="metadata" src="video-a.mp4">`}},
+		{MediaFile{Path: "../media/ab/c d.ts", MimeType: "video/mp2t"}, []string{`<a href="../media/ab/c%20d.ts" type="video/mp2t">`, "VLC"}},
+	}
+	for _, tt := range tests {
+		got := videoPlayerHTML(tt.file)
+		for _, want := range tt.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("videoPlayerHTML(%+v) = %s, want it to contain %s", tt.file, got, want)
+			}
+		}
+		if tt.file.MimeType == "video/mp2t" && strings.Contains(got, "<video") {
+			t.Errorf("videoPlayerHTML(%+v) = %s, want no player for MPEG-TS", tt.file, got)
+		}
+	}
+}
+
+func TestDownloadHLS(t *testing.T) {
+	// without ffmpeg, MPEG-TS streams are kept as they are
+	t.Setenv("PATH", "")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/master.m3u8":
+			fmt.Fprint(w, "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=100\nlow.m3u8\n#EXT-X-STREAM-INF:BANDWIDTH=900\nhigh.m3u8\n")
+		case "/high.m3u8":
+			fmt.Fprint(w, "#EXTM3U\n#EXTINF:1,\nhigh0.ts\n#EXTINF:1,\nhigh1.ts\n#EXT-X-ENDLIST\n")
+		case "/fmp4.m3u8":
+			fmt.Fprint(w, "#EXTM3U\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:1,\nf0.m4s\n")
+		case "/encrypted.m3u8":
+			fmt.Fprint(w, "#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"key\"\n#EXTINF:1,\ne0.ts\n")
+		default:
+			fmt.Fprint(w, "<"+strings.TrimPrefix(r.URL.Path, "/")+">")
+		}
+	}))
+	defer srv.Close()
+	f := NewFetcher(WithRatePerSecond(1000))
+
+	tests := []struct {
+		playlist string
+		want     MediaFile
+		content  string
+	}{
+		{"master.m3u8", MediaFile{Path: "video-x.ts", MimeType: "video/mp2t"}, "<high0.ts><high1.ts>"},
+		{"fmp4.m3u8", MediaFile{Path: "video-x.mp4", MimeType: "video/mp4"}, "<init.mp4><f0.m4s>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.playlist, func(t *testing.T) {
+			dir := t.TempDir()
+			got, err := downloadHLS(context.Background(), f, srv.URL+"/"+tt.playlist, dir, "video-x")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			data, err := os.ReadFile(filepath.Join(dir, got.Path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.content {
+				t.Errorf("got content %q, want %q", data, tt.content)
+			}
+		})
+	}
+
+	t.Run("encrypted", func(t *testing.T) {
+		dir := t.TempDir()
+		if _, err := downloadHLS(context.Background(), f, srv.URL+"/encrypted.m3u8", dir, "video-x"); err == nil {
+			t.Errorf("got no error for an encrypted stream")
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) > 0 {
+			t.Errorf("got files %v, want none", entries)
+		}
+	})
+}
+
+func TestRemuxToMP4(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake ffmpeg is a shell script")
+	}
+	// the fake ffmpeg copies its input (after -i) to its output (the last argument)
+	bin := t.TempDir()
+	script := "#!/bin/sh\nwhile [ \"$1\" != \"-i\" ]; do shift; done\nin=\"$2\"\nfor out; do :; done\ncp \"$in\" \"$out\"\n"
+	if err := os.WriteFile(filepath.Join(bin, "ffmpeg"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	dir := t.TempDir()
+	tsPath, mp4Path := filepath.Join(dir, "v.ts"), filepath.Join(dir, "v.mp4")
+	if err := os.WriteFile(tsPath, []byte("stream"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := remuxToMP4(context.Background(), tsPath, mp4Path); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(mp4Path); err != nil || string(data) != "stream" {
+		t.Errorf("got %q, %v, want the remuxed stream", data, err)
+	}
+	if _, err := os.Stat(tsPath); !os.IsNotExist(err) {
+		t.Errorf("MPEG-TS file not removed after remuxing")
+	}
+	if _, err := os.Stat(mp4Path + ".part"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left after remuxing")
+	}
+}
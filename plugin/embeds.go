@@ -0,0 +1,333 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+
+	md "github.com/JohannesKaufmann/html-to-markdown"
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Embed describes external content embedded in a post: a tweet, an Instagram post, a Spotify track, ...
+type Embed struct {
+	Kind   string // e.g. "Tweet", "Spotify"
+	URL    string // the embedded content on its original site
+	Title  string
+	Author string
+	Text   string
+	Date   string
+	Image  string // preview image URL
+}
+
+// EmbedHandler recognizes one kind of embed, matched by Selector, and describes it.
+// Parse returns false if the element isn't a usable embed.
+type EmbedHandler struct {
+	Selector string
+	Parse    func(s *goquery.Selection) (Embed, bool)
+}
+
+// EmbedHandlers are the handlers of the embeds supported in Substack posts, tried in order.
+// Wrappers come before bare iframes, so the generic iframe handler only gets the iframes no other handler knows.
+var EmbedHandlers = []EmbedHandler{
+	{Selector: `div.tweet, [data-component-name="Twitter2ToDOM"]`, Parse: parseTweet},
+	{Selector: `div.instagram, [data-component-name="InstagramToDOM"]`, Parse: parseInstagram},
+	{Selector: `.spotify-wrap`, Parse: parseSpotify},
+	{Selector: `.apple-podcast-container, iframe.apple-podcast`, Parse: parseApplePodcast},
+	{Selector: `.vimeo-wrap, [data-component-name="VimeoToDOM"]`, Parse: parseVimeo},
+	{Selector: `.bluesky-wrap`, Parse: parseBluesky},
+	{Selector: `.youtube-wrap, [data-component-name="Youtube2ToDOM"]`, Parse: parseYoutube},
+	{Selector: `.embedded-post-wrap, .embedded-publication-wrap`, Parse: parseLinkPreview},
+	{Selector: `iframe[src]`, Parse: parseIframe},
+}
+
+// Embeds registers a hook replacing every supported embed with a static representation
+// (its text, a link to the original and its preview image), converted to Markdown as regular content.
+func Embeds(opts ...Option) md.Plugin {
+	return func(c *md.Converter) []md.Rule {
+		c.Before(func(selec *goquery.Selection) {
+			ReplaceEmbeds(selec, opts...)
+		})
+		return nil
+	}
+}
+
+// ReplaceEmbeds replaces every supported embed found in selec with its static representation (see Embed.HTML).
+func ReplaceEmbeds(selec *goquery.Selection, opts ...Option) {
+	o := newOptions(opts)
+	for _, h := range EmbedHandlers {
+		selec.Find(h.Selector).Each(func(i int, s *goquery.Selection) {
+			e, ok := h.Parse(s)
+			if !ok {
+				return
+			}
+			o.logger.Debug("embed replaced", "kind", e.Kind, "url", e.URL)
+			s.ReplaceWithHtml(e.HTML())
+		})
+	}
+}
+
+// HTML returns a static representation of the embed, which doesn't need any script or network access:
+// its text as a quote, its preview image and a link to the original.
+func (e Embed) HTML() string {
+	var sb strings.Builder
+	sb.WriteString(`<blockquote class="embed">`)
+	if e.Text != "" {
+		for _, para := range strings.Split(strings.TrimSpace(e.Text), "\n\n") {
+			sb.WriteString("<p>" + strings.ReplaceAll(html.EscapeString(strings.TrimSpace(para)), "\n", "<br/>") + "</p>")
+		}
+	}
+	if e.Image != "" {
+		fmt.Fprintf(&sb, `<p><a href="%s"><img src="%s" alt="%s"/></a></p>`,
+			html.EscapeString(e.URL), html.EscapeString(e.Image), html.EscapeString(e.Title))
+	}
+
+	title := e.Title
+	if title == "" {
+		title = e.URL
+	}
+	sb.WriteString("<p>" + html.EscapeString(e.Kind) + ": ")
+	if e.URL != "" {
+		fmt.Fprintf(&sb, `<a href="%s">%s</a>`, html.EscapeString(e.URL), html.EscapeString(title))
+	} else {
+		sb.WriteString(html.EscapeString(title))
+	}
+	if e.Author != "" {
+		sb.WriteString(" by " + html.EscapeString(e.Author))
+	}
+	if e.Date != "" {
+		sb.WriteString(" (" + html.EscapeString(e.Date) + ")")
+	}
+	sb.WriteString("</p></blockquote>")
+	return sb.String()
+}
+
+// embedAttrs returns the data-attrs JSON of a Substack embed, as found on s or its first descendant having one.
+func embedAttrs(s *goquery.Selection) map[string]any {
+	raw, ok := s.Attr("data-attrs")
+	if !ok {
+		raw = s.Find("[data-attrs]").First().AttrOr("data-attrs", "")
+	}
+	attrs := make(map[string]any)
+	json.Unmarshal([]byte(raw), &attrs) // missing or invalid attributes leave the map empty
+	return attrs
+}
+
+// attrString returns the first non-empty string (or number) among the given keys of attrs.
+func attrString(attrs map[string]any, keys ...string) string {
+	for _, k := range keys {
+		switch v := attrs[k].(type) {
+		case string:
+			if v = strings.TrimSpace(v); v != "" {
+				return v
+			}
+		case float64:
+			return fmt.Sprintf("%.0f", v)
+		}
+	}
+	return ""
+}
+
+// embedDate returns the date part of an ISO 8601 timestamp, or the timestamp as is if it isn't one.
+func embedDate(date string) string {
+	if len(date) >= len("2006-01-02") && date[4] == '-' && date[7] == '-' {
+		return date[:len("2006-01-02")]
+	}
+	return date
+}
+
+// iframeSrc returns the src of the iframe of an embed (s itself or its first iframe descendant).
+func iframeSrc(s *goquery.Selection) string {
+	if goquery.NodeName(s) == "iframe" {
+		return s.AttrOr("src", "")
+	}
+	return s.Find("iframe[src]").First().AttrOr("src", "")
+}
+
+func parseTweet(s *goquery.Selection) (Embed, bool) {
+	attrs := embedAttrs(s)
+	e := Embed{
+		Kind: "Tweet",
+		URL:  attrString(attrs, "url"),
+		Text: attrString(attrs, "full_text", "text"),
+		Date: embedDate(attrString(attrs, "date")),
+	}
+	name, username := attrString(attrs, "name"), attrString(attrs, "username")
+	switch {
+	case name != "" && username != "":
+		e.Author = fmt.Sprintf("%s (@%s)", name, username)
+	case username != "":
+		e.Author = "@" + username
+	default:
+		e.Author = name
+	}
+	if photos, ok := attrs["photos"].([]any); ok && len(photos) > 0 {
+		if photo, ok := photos[0].(map[string]any); ok {
+			e.Image = attrString(photo, "img_url", "url")
+		}
+	}
+
+	if e.URL == "" {
+		e.URL = s.Find(`a[href*="twitter.com/"], a[href*="x.com/"]`).First().AttrOr("href", "")
+	}
+	if e.Text == "" {
+		e.Text = strings.TrimSpace(s.Find(".tweet-text").First().Text())
+	}
+	return e, e.URL != "" || e.Text != ""
+}
+
+func parseInstagram(s *goquery.Selection) (Embed, bool) {
+	attrs := embedAttrs(s)
+	e := Embed{
+		Kind:   "Instagram post",
+		Title:  attrString(attrs, "title"),
+		Author: attrString(attrs, "author_name"),
+		Image:  attrString(attrs, "thumbnail_url"),
+	}
+	if id := attrString(attrs, "instagram_id"); id != "" {
+		e.URL = "https://www.instagram.com/p/" + url.PathEscape(id) + "/"
+	} else {
+		e.URL = s.Find(`a[href*="instagram.com/"]`).First().AttrOr("href", "")
+	}
+	if e.Image == "" {
+		e.Image = s.Find("img[src]").First().AttrOr("src", "")
+	}
+	return e, e.URL != ""
+}
+
+func parseSpotify(s *goquery.Selection) (Embed, bool) {
+	attrs := embedAttrs(s)
+	e := Embed{
+		Kind:   "Spotify",
+		URL:    attrString(attrs, "url"),
+		Title:  attrString(attrs, "title"),
+		Author: attrString(attrs, "subtitle"),
+		Text:   attrString(attrs, "description"),
+		Image:  attrString(attrs, "image"),
+	}
+	if e.URL == "" {
+		e.URL = strings.Replace(iframeSrc(s), "open.spotify.com/embed/", "open.spotify.com/", 1)
+	}
+	return e, e.URL != ""
+}
+
+func parseApplePodcast(s *goquery.Selection) (Embed, bool) {
+	attrs := embedAttrs(s)
+	e := Embed{
+		Kind:   "Apple Podcasts",
+		URL:    attrString(attrs, "url"),
+		Title:  attrString(attrs, "title", "podcastTitle"),
+		Author: attrString(attrs, "podcastByline", "author"),
+		Image:  attrString(attrs, "coverArt", "image"),
+	}
+	if e.URL == "" {
+		e.URL = iframeSrc(s)
+	}
+	e.URL = strings.Replace(e.URL, "embed.podcasts.apple.com", "podcasts.apple.com", 1)
+	return e, e.URL != ""
+}
+
+var vimeoID = regexp.MustCompile(`player\.vimeo\.com/video/(\d+)`)
+
+func parseVimeo(s *goquery.Selection) (Embed, bool) {
+	attrs := embedAttrs(s)
+	id := attrString(attrs, "videoId")
+	if id == "" {
+		if parts := vimeoID.FindStringSubmatch(iframeSrc(s)); len(parts) == 2 {
+			id = parts[1]
+		}
+	}
+	if id == "" {
+		return Embed{}, false
+	}
+	return Embed{Kind: "Vimeo video", URL: "https://vimeo.com/" + id}, true
+}
+
+func parseBluesky(s *goquery.Selection) (Embed, bool) {
+	attrs := embedAttrs(s)
+	e := Embed{
+		Kind:   "Bluesky post",
+		URL:    attrString(attrs, "url"),
+		Text:   attrString(attrs, "text"),
+		Author: attrString(attrs, "authorName"),
+		Date:   embedDate(attrString(attrs, "createdAt")),
+		Image:  attrString(attrs, "imageUrl"),
+	}
+	handle := attrString(attrs, "authorHandle")
+	if handle != "" {
+		if e.Author != "" {
+			e.Author = fmt.Sprintf("%s (@%s)", e.Author, handle)
+		} else {
+			e.Author = "@" + handle
+		}
+	}
+	if e.URL == "" {
+		profile := handle
+		if profile == "" {
+			profile = attrString(attrs, "authorDid")
+		}
+		if postID := attrString(attrs, "postId"); profile != "" && postID != "" {
+			e.URL = fmt.Sprintf("https://bsky.app/profile/%s/post/%s", profile, postID)
+		} else {
+			e.URL = iframeSrc(s)
+		}
+	}
+	return e, e.URL != "" || e.Text != ""
+}
+
+func parseYoutube(s *goquery.Selection) (Embed, bool) {
+	id := attrString(embedAttrs(s), "videoId")
+	if id == "" {
+		if parts := youtubeID.FindStringSubmatch(iframeSrc(s)); len(parts) == 2 {
+			id = parts[1]
+		}
+	}
+	if id == "" {
+		return Embed{}, false
+	}
+	return youtubeEmbed(id, s.Find("iframe").AttrOr("title", "")), true
+}
+
+// youtubeEmbed returns the Embed of the YouTube video with the given ID, with its thumbnail as preview image.
+func youtubeEmbed(id string, title string) Embed {
+	return Embed{
+		Kind:  "YouTube video",
+		URL:   "https://www.youtube.com/watch?v=" + id,
+		Title: title,
+		Image: fmt.Sprintf("https://img.youtube.com/vi/%s/0.jpg", id),
+	}
+}
+
+func parseLinkPreview(s *goquery.Selection) (Embed, bool) {
+	attrs := embedAttrs(s)
+	e := Embed{
+		Kind:   "Link",
+		URL:    attrString(attrs, "url", "base_url"),
+		Title:  attrString(attrs, "title", "name"),
+		Author: attrString(attrs, "publication_name", "author_name"),
+		Text:   attrString(attrs, "truncated_body_text", "description", "hero_text"),
+		Date:   embedDate(attrString(attrs, "date", "post_date")),
+		Image:  attrString(attrs, "cover_image", "image", "logo_url", "publication_logo_url"),
+	}
+	if e.URL == "" {
+		e.URL = s.Find("a[href]").First().AttrOr("href", "")
+		if goquery.NodeName(s) == "a" {
+			e.URL = s.AttrOr("href", "")
+		}
+	}
+	return e, e.URL != ""
+}
+
+func parseIframe(s *goquery.Selection) (Embed, bool) {
+	src := s.AttrOr("src", "")
+	if parts := youtubeID.FindStringSubmatch(src); len(parts) == 2 {
+		return youtubeEmbed(parts[1], s.AttrOr("title", "")), true
+	}
+	if !strings.HasPrefix(src, "http") {
+		return Embed{}, false
+	}
+	return Embed{Kind: "Embedded content", URL: src, Title: s.AttrOr("title", "")}, true
+}
@@ -0,0 +1,176 @@
+package plugin
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEmbedDate(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"2024-01-02T10:00:00.000Z", "2024-01-02"},
+		{"2024-01-02", "2024-01-02"},
+		{"Jan 2, 2024", "Jan 2, 2024"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := embedDate(tt.in); got != tt.want {
+			t.Errorf("embedDate(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestAttrString(t *testing.T) {
+	attrs := map[string]any{"empty": " ", "title": " Title ", "id": float64(12345678), "list": []any{"a"}}
+	tests := []struct {
+		keys []string
+		want string
+	}{
+		{[]string{"title"}, "Title"},
+		{[]string{"missing", "empty", "title"}, "Title"},
+		{[]string{"id"}, "12345678"},
+		{[]string{"list", "missing"}, ""},
+	}
+	for _, tt := range tests {
+		if got := attrString(attrs, tt.keys...); got != tt.want {
+			t.Errorf("attrString(%v) = %q, want %q", tt.keys, got, tt.want)
+		}
+	}
+}
+
+func TestEmbedHandlers(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want Embed
+		ok   bool
+	}{
+		{
+			name: "tweet",
+			html: `<div class="tweet" data-attrs='{"url":"https://x.com/a/status/1","full_text":"Hello","name":"A","username":"a","date":"2024-01-02T10:00:00.000Z","photos":[{"img_url":"https://pbs.twimg.com/a.jpg"}]}'></div>`,
+			want: Embed{Kind: "Tweet", URL: "https://x.com/a/status/1", Text: "Hello", Author: "A (@a)", Date: "2024-01-02", Image: "https://pbs.twimg.com/a.jpg"},
+			ok:   true,
+		},
+		{
+			name: "tweet from the markup",
+			html: `<div class="tweet"><div class="tweet-text"> Hi </div><a href="https://twitter.com/a/status/2">link</a></div>`,
+			want: Embed{Kind: "Tweet", URL: "https://twitter.com/a/status/2", Text: "Hi"},
+			ok:   true,
+		},
+		{
+			name: "instagram",
+			html: `<div class="instagram" data-attrs='{"instagram_id":"Cabc","title":"Post","author_name":"someone"}'><img src="thumb.jpg"/></div>`,
+			want: Embed{Kind: "Instagram post", URL: "https://www.instagram.com/p/Cabc/", Title: "Post", Author: "someone", Image: "thumb.jpg"},
+			ok:   true,
+		},
+		{
+			name: "spotify iframe",
+			html: `<div class="spotify-wrap"><iframe src="https://open.spotify.com/embed/track/abc"></iframe></div>`,
+			want: Embed{Kind: "Spotify", URL: "https://open.spotify.com/track/abc"},
+			ok:   true,
+		},
+		{
+			name: "apple podcast",
+			html: `<iframe class="apple-podcast" src="https://embed.podcasts.apple.com/us/podcast/id1"></iframe>`,
+			want: Embed{Kind: "Apple Podcasts", URL: "https://podcasts.apple.com/us/podcast/id1"},
+			ok:   true,
+		},
+		{
+			name: "vimeo",
+			html: `<div class="vimeo-wrap"><iframe src="https://player.vimeo.com/video/42?h=1"></iframe></div>`,
+			want: Embed{Kind: "Vimeo video", URL: "https://vimeo.com/42"},
+			ok:   true,
+		},
+		{
+			name: "bluesky",
+			html: `<div class="bluesky-wrap" data-attrs='{"postId":"3k","authorHandle":"a.bsky.social","authorName":"A","text":"Post","createdAt":"2024-01-02T00:00:00Z"}'></div>`,
+			want: Embed{Kind: "Bluesky post", URL: "https://bsky.app/profile/a.bsky.social/post/3k", Text: "Post", Author: "A (@a.bsky.social)", Date: "2024-01-02"},
+			ok:   true,
+		},
+		{
+			name: "youtube",
+			html: `<div class="youtube-wrap"><iframe title="Talk" src="https://www.youtube-nocookie.com/embed/xyz?rel=0"></iframe></div>`,
+			want: Embed{Kind: "YouTube video", URL: "https://www.youtube.com/watch?v=xyz", Title: "Talk", Image: "https://img.youtube.com/vi/xyz/0.jpg"},
+			ok:   true,
+		},
+		{
+			name: "embedded post",
+			html: `<div class="embedded-post-wrap" data-attrs='{"url":"https://b.substack.com/p/other","title":"Other","publication_name":"B","truncated_body_text":"Intro","date":"2024-01-02T00:00:00Z","cover_image":"cover.png"}'></div>`,
+			want: Embed{Kind: "Link", URL: "https://b.substack.com/p/other", Title: "Other", Author: "B", Text: "Intro", Date: "2024-01-02", Image: "cover.png"},
+			ok:   true,
+		},
+		{
+			name: "iframe",
+			html: `<iframe src="https://example.com/widget" title="Widget"></iframe>`,
+			want: Embed{Kind: "Embedded content", URL: "https://example.com/widget", Title: "Widget"},
+			ok:   true,
+		},
+		{
+			name: "relative iframe",
+			html: `<iframe src="/local"></iframe>`,
+		},
+		{
+			name: "empty tweet",
+			html: `<div class="tweet"></div>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := parseBody(t, tt.html)
+			for _, h := range EmbedHandlers {
+				s := body.Find(h.Selector).First()
+				if s.Length() == 0 {
+					continue
+				}
+				got, ok := h.Parse(s)
+				if ok != tt.ok || (ok && got != tt.want) {
+					t.Errorf("got %+v, %v, want %+v, %v", got, ok, tt.want, tt.ok)
+				}
+				return
+			}
+			if tt.ok {
+				t.Errorf("no handler for %s", tt.html)
+			}
+		})
+	}
+}
+
+func TestEmbedHTML(t *testing.T) {
+	tests := []struct {
+		embed Embed
+		want  string
+	}{
+		{
+			Embed{Kind: "Tweet", URL: "https://x.com/a/status/1", Text: "One <b>\nline\n\nTwo", Author: "A", Date: "2024-01-02", Image: "a.jpg"},
+			`<blockquote class="embed"><p>One &lt;b&gt;<br/>line</p><p>Two</p>` +
+				`<p><a href="https://x.com/a/status/1"><img src="a.jpg" alt=""/></a></p>` +
+				`<p>Tweet: <a href="https://x.com/a/status/1">https://x.com/a/status/1</a> by A (2024-01-02)</p></blockquote>`,
+		},
+		{
+			Embed{Kind: "Link", Title: "No URL"},
+			`<blockquote class="embed"><p>Link: No URL</p></blockquote>`,
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.embed.HTML(); got != tt.want {
+			t.Errorf("HTML() of %+v = %s, want %s", tt.embed, got, tt.want)
+		}
+	}
+}
+
+func TestReplaceEmbeds(t *testing.T) {
+	body := parseBody(t, `<p>Before</p><div class="youtube-wrap"><iframe src="https://www.youtube.com/embed/xyz"></iframe></div>`+
+		`<iframe src="https://example.com/widget"></iframe><p>After</p>`)
+	ReplaceEmbeds(body)
+
+	if n := body.Find("iframe").Length(); n != 0 {
+		t.Errorf("got %d iframes left, want none", n)
+	}
+	got, _ := body.Html()
+	for _, want := range []string{"<p>Before</p>", "YouTube video: ", `href="https://www.youtube.com/watch?v=xyz"`, "Embedded content: ", "<p>After</p>"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("got %s, want it to contain %s", got, want)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package plugin
+
+import (
+	"html"
+	"regexp"
+	"strings"
+
+	md "github.com/JohannesKaufmann/html-to-markdown"
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Substack footnotes are made of anchors in the text (a.footnote-anchor, linking to #footnote-N)
+// and of a footnotes section at the end of the post (div.footnote, whose a.footnote-number links back to #footnote-anchor-N).
+const (
+	footnoteAnchorSelector = "a.footnote-anchor"
+	footnoteSelector       = "div.footnote"
+	footnoteNumberSelector = "a.footnote-number"
+)
+
+// trailingNumber matches the number at the end of a footnote id or link, e.g. #footnote-anchor-3.
+var trailingNumber = regexp.MustCompile(`(\d+)$`)
+
+// footnoteNumber returns the number of a footnote anchor or footnote number link: its text,
+// falling back to the number in its id or href.
+func footnoteNumber(s *goquery.Selection) string {
+	if n := strings.TrimSpace(s.Text()); n != "" {
+		return n
+	}
+	for _, attr := range []string{"id", "href"} {
+		if m := trailingNumber.FindString(s.AttrOr(attr, "")); m != "" {
+			return m
+		}
+	}
+	return ""
+}
+
+// NormalizeFootnotes makes the links between the footnote anchors found in selec and their footnotes local and bidirectional:
+// each anchor links to #footnote-N and has the id footnote-anchor-N, and each footnote number links back to it.
+// Substack sometimes uses absolute links to the online post, which break in a local copy.
+func NormalizeFootnotes(selec *goquery.Selection) {
+	selec.Find(footnoteAnchorSelector).Each(func(i int, s *goquery.Selection) {
+		if n := footnoteNumber(s); n != "" {
+			s.SetAttr("id", "footnote-anchor-"+n)
+			s.SetAttr("href", "#footnote-"+n)
+		}
+	})
+	selec.Find(footnoteSelector).Each(func(i int, s *goquery.Selection) {
+		number := s.Find(footnoteNumberSelector).First()
+		if n := footnoteNumber(number); n != "" {
+			number.SetAttr("id", "footnote-"+n)
+			number.SetAttr("href", "#footnote-anchor-"+n)
+		}
+	})
+}
+
+// FootnotesToEndnotes rewrites the footnotes found in selec for plain text output:
+// anchors become [N] references, and footnotes numbered [N] endnotes under a "Notes" heading.
+func FootnotesToEndnotes(selec *goquery.Selection) {
+	selec.Find(footnoteAnchorSelector).Each(func(i int, s *goquery.Selection) {
+		s.ReplaceWithHtml("[" + html.EscapeString(footnoteNumber(s)) + "]")
+	})
+
+	footnotes := selec.Find(footnoteSelector)
+	footnotes.Each(func(i int, s *goquery.Selection) {
+		number := s.Find(footnoteNumberSelector).First()
+		ref := "[" + html.EscapeString(footnoteNumber(number)) + "] "
+		number.Remove()
+		if p := s.Find("p").First(); p.Length() > 0 {
+			p.PrependHtml(ref)
+		} else {
+			s.PrependHtml(ref)
+		}
+	})
+	footnotes.First().BeforeHtml("<p>Notes</p>")
+}
+
+// Footnotes converts Substack footnotes to Markdown footnotes: [^1] in the text and [^1]: ... at the end.
+func Footnotes() md.Plugin {
+	return func(c *md.Converter) []md.Rule {
+		c.Before(func(selec *goquery.Selection) {
+			// the number of each footnote is moved to an attribute, so it isn't rendered as a link
+			selec.Find(footnoteSelector).Each(func(i int, s *goquery.Selection) {
+				number := s.Find(footnoteNumberSelector).First()
+				s.SetAttr("data-footnote", footnoteNumber(number))
+				number.Remove()
+			})
+		})
+		return []md.Rule{
+			{
+				Filter: []string{"a"},
+				Replacement: func(content string, selec *goquery.Selection, opt *md.Options) *string {
+					if !selec.HasClass("footnote-anchor") {
+						return nil
+					}
+					text := "[^" + footnoteNumber(selec) + "]"
+					return &text
+				},
+			},
+			{
+				Filter: []string{"div"},
+				Replacement: func(content string, selec *goquery.Selection, opt *md.Options) *string {
+					number, ok := selec.Attr("data-footnote")
+					if !ok || number == "" || !selec.HasClass("footnote") {
+						return nil
+					}
+					// paragraphs after the first are indented to belong to the footnote
+					lines := strings.Split(strings.TrimSpace(content), "\n")
+					for i := 1; i < len(lines); i++ {
+						if lines[i] != "" {
+							lines[i] = "    " + lines[i]
+						}
+					}
+					text := "\n\n[^" + number + "]: " + strings.Join(lines, "\n") + "\n\n"
+					return &text
+				},
+			},
+		}
+	}
+}
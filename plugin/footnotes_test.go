@@ -0,0 +1,78 @@
+package plugin
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// footnotesHTML is a post with two footnotes, the first of them linked with absolute URLs to the online post.
+const footnotesHTML = `<p>Text<a class="footnote-anchor" id="footnote-anchor-1" href="https://x.substack.com/p/post#footnote-1">1</a>` +
+	` and more<a class="footnote-anchor" href="#footnote-2">2</a>.</p>` +
+	`<div class="footnote"><a class="footnote-number" href="https://x.substack.com/p/post#footnote-anchor-1">1</a><div class="footnote-content"><p>First note.</p></div></div>` +
+	`<div class="footnote"><a class="footnote-number" id="footnote-2" href="#footnote-anchor-2"></a><div class="footnote-content"><p>Second note.</p><p>Second paragraph.</p></div></div>`
+
+// parseBody parses html and returns the selection of its body.
+func parseBody(t *testing.T, html string) *goquery.Selection {
+	t.Helper()
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc.Find("body")
+}
+
+func TestFootnoteNumber(t *testing.T) {
+	tests := []struct {
+		html string
+		want string
+	}{
+		{`<a class="footnote-anchor" href="#footnote-1"> 3 </a>`, "3"},
+		{`<a class="footnote-anchor" id="footnote-anchor-12" href="#x"></a>`, "12"},
+		{`<a class="footnote-number" href="https://x.substack.com/p/post#footnote-anchor-4"></a>`, "4"},
+		{`<a class="footnote-number" href="#notes"></a>`, ""},
+	}
+	for _, tt := range tests {
+		if got := footnoteNumber(parseBody(t, tt.html).Find("a")); got != tt.want {
+			t.Errorf("footnoteNumber(%s) = %q, want %q", tt.html, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeFootnotes(t *testing.T) {
+	body := parseBody(t, footnotesHTML)
+	NormalizeFootnotes(body)
+
+	anchors := body.Find(footnoteAnchorSelector)
+	numbers := body.Find(footnoteNumberSelector)
+	for i, n := range []string{"1", "2"} {
+		anchor, number := anchors.Eq(i), numbers.Eq(i)
+		if id, href := anchor.AttrOr("id", ""), anchor.AttrOr("href", ""); id != "footnote-anchor-"+n || href != "#footnote-"+n {
+			t.Errorf("anchor %s: got id %q and href %q", n, id, href)
+		}
+		if id, href := number.AttrOr("id", ""), number.AttrOr("href", ""); id != "footnote-"+n || href != "#footnote-anchor-"+n {
+			t.Errorf("footnote %s: got id %q and href %q", n, id, href)
+		}
+	}
+}
+
+func TestFootnotesToEndnotes(t *testing.T) {
+	body := parseBody(t, footnotesHTML)
+	FootnotesToEndnotes(body)
+
+	want := "Text[1] and more[2].Notes[1] First note.[2] Second note.Second paragraph."
+	if got := body.Text(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if n := body.Find("a").Length(); n != 0 {
+		t.Errorf("got %d links left, want none", n)
+	}
+}
+
+func TestFootnotes(t *testing.T) {
+	want := "Text[^1] and more[^2].\n\n[^1]: First note.\n\n[^2]: Second note.\n\n    Second paragraph."
+	if got := strings.TrimSpace(convert(t, footnotesHTML, Footnotes())); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,33 @@
+package plugin
+
+import (
+	"io"
+	"log/slog"
+)
+
+// Option configures the plugins of this package.
+type Option func(*options)
+
+// options holds the configuration shared by the plugins of this package.
+type options struct {
+	logger *slog.Logger
+}
+
+// WithLogger sets the logger of the plugins, which log the conversions they make at debug level.
+// By default, nothing is logged.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		if logger != nil {
+			o.logger = logger
+		}
+	}
+}
+
+// newOptions applies opts to the default options.
+func newOptions(opts []Option) options {
+	o := options{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
@@ -0,0 +1,227 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	md "github.com/JohannesKaufmann/html-to-markdown"
+	"github.com/PuerkitoBio/goquery"
+)
+
+// substackPlugins maps the name of each Substack-specific Markdown rule to its plugin.
+var substackPlugins = map[string]func(opts ...Option) md.Plugin{
+	"buttons":    DropButtons,
+	"captions":   func(...Option) md.Plugin { return CaptionedImages() },
+	"code":       func(...Option) md.Plugin { return CodeBlocks() },
+	"embeds":     Embeds,
+	"footnotes":  func(...Option) md.Plugin { return Footnotes() },
+	"galleries":  func(...Option) md.Plugin { return ImageGalleries() },
+	"latex":      func(...Option) md.Plugin { return Latex() },
+	"pullquotes": func(...Option) md.Plugin { return PullQuotes() },
+}
+
+// SubstackRules returns the names of the Substack-specific Markdown rules, in alphabetical order.
+func SubstackRules() []string {
+	names := make([]string, 0, len(substackPlugins))
+	for name := range substackPlugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsSubstackRule reports whether name is the name of a Substack-specific Markdown rule.
+func IsSubstackRule(name string) bool {
+	_, ok := substackPlugins[name]
+	return ok
+}
+
+// Substack returns the plugins converting Substack-specific constructs to Markdown, except the disabled ones.
+func Substack(disabled []string, opts ...Option) []md.Plugin {
+	skip := make(map[string]bool)
+	for _, name := range disabled {
+		skip[name] = true
+	}
+	var plugins []md.Plugin
+	for _, name := range SubstackRules() {
+		if !skip[name] {
+			plugins = append(plugins, substackPlugins[name](opts...))
+		}
+	}
+	return plugins
+}
+
+// CaptionedImages keeps the caption of images right under them, in italics,
+// and drops the "expand" buttons and links of Substack images.
+func CaptionedImages() md.Plugin {
+	return func(c *md.Converter) []md.Rule {
+		c.Before(func(selec *goquery.Selection) {
+			selec.Find(".image-link-expand").Remove()
+			// image links only point to the image itself
+			selec.Find("a.image-link").Each(func(i int, s *goquery.Selection) {
+				if s.Find("img").Length() > 0 {
+					s.Children().Unwrap()
+				}
+			})
+		})
+		return []md.Rule{
+			{
+				Filter: []string{"figcaption"},
+				Replacement: func(content string, selec *goquery.Selection, opt *md.Options) *string {
+					caption := strings.TrimSpace(content)
+					if caption == "" {
+						return md.String("")
+					}
+					text := "\n\n*" + caption + "*\n\n"
+					return &text
+				},
+			},
+		}
+	}
+}
+
+// galleryAttrs holds the attributes of a Substack image gallery (its data-attrs JSON).
+type galleryAttrs struct {
+	Gallery struct {
+		Images []struct {
+			Src string `json:"src"`
+		} `json:"images"`
+		Caption string `json:"caption"`
+	} `json:"gallery"`
+}
+
+// ImageGalleries renders Substack image galleries as a sequence of images followed by their caption.
+func ImageGalleries() md.Plugin {
+	return func(c *md.Converter) []md.Rule {
+		return []md.Rule{
+			{
+				Filter: []string{"div"},
+				Replacement: func(content string, selec *goquery.Selection, opt *md.Options) *string {
+					if !selec.HasClass("image-gallery-embed") {
+						return nil
+					}
+					var attrs galleryAttrs
+					json.Unmarshal([]byte(selec.AttrOr("data-attrs", "")), &attrs) // the images may only be in the markup
+
+					var images []string
+					selec.Find("img[src]").Each(func(i int, img *goquery.Selection) {
+						images = append(images, fmt.Sprintf("![%s](%s)", img.AttrOr("alt", ""), img.AttrOr("src", "")))
+					})
+					if len(images) == 0 {
+						for _, img := range attrs.Gallery.Images {
+							if img.Src != "" {
+								images = append(images, fmt.Sprintf("![](%s)", img.Src))
+							}
+						}
+					}
+
+					caption := strings.TrimSpace(attrs.Gallery.Caption)
+					if caption == "" {
+						caption = strings.TrimSpace(selec.Find("figcaption, .image-gallery-caption").First().Text())
+					}
+					text := "\n\n" + strings.Join(images, "\n\n")
+					if caption != "" {
+						text += "\n\n*" + caption + "*"
+					}
+					text += "\n\n"
+					return &text
+				},
+			},
+		}
+	}
+}
+
+// ctaSelector matches Substack's subscribe, share and app widgets.
+const ctaSelector = `.subscription-widget-wrap, .subscription-widget-wrap-editor, .subscribe-widget, ` +
+	`[data-component-name="SubscribeWidgetToDOM"], .share-dialog, .install-substack-app-embed, ` +
+	`[data-component-name="InstallSubstackAppToDOM"]`
+
+// ctaURL matches the links of Substack's call-to-action buttons: subscribe, share, comment and get the app.
+var ctaURL = regexp.MustCompile(`(?i)(/subscribe|/share|[?&]action=(share|comment)|/comments(/|\?|#|$)|substack\.com/app|/app-link/)`)
+
+// DropButtons drops Substack's call-to-action buttons and widgets (subscribe, share, leave a comment, get the app).
+// Other buttons are kept as regular links.
+func DropButtons(opts ...Option) md.Plugin {
+	o := newOptions(opts)
+	return func(c *md.Converter) []md.Rule {
+		c.Before(func(selec *goquery.Selection) {
+			selec.Find(ctaSelector).Remove()
+			selec.Find("p.button-wrapper, .captioned-button-wrap").Each(func(i int, s *goquery.Selection) {
+				if href := s.Find("a[href]").AttrOr("href", ""); ctaURL.MatchString(href) {
+					o.logger.Debug("call-to-action button dropped", "href", href)
+					s.Remove()
+				}
+			})
+		})
+		return nil
+	}
+}
+
+// PullQuotes renders Substack pull quotes as block quotes.
+func PullQuotes() md.Plugin {
+	return func(c *md.Converter) []md.Rule {
+		c.Before(func(selec *goquery.Selection) {
+			selec.Find("div.pullquote").Each(func(i int, s *goquery.Selection) {
+				inner, err := s.Html()
+				if err == nil {
+					s.ReplaceWithHtml("<blockquote>" + inner + "</blockquote>")
+				}
+			})
+		})
+		return nil
+	}
+}
+
+// latexAttrs holds the attributes of a Substack LaTeX block (its data-attrs JSON).
+type latexAttrs struct {
+	Expression string `json:"persistentExpression"`
+}
+
+// Latex renders Substack LaTeX blocks as $$ math blocks with their source expression.
+func Latex() md.Plugin {
+	return func(c *md.Converter) []md.Rule {
+		return []md.Rule{
+			{
+				Filter: []string{"div"},
+				Replacement: func(content string, selec *goquery.Selection, opt *md.Options) *string {
+					if !selec.HasClass("latex-rendered") {
+						return nil
+					}
+					var attrs latexAttrs
+					if err := json.Unmarshal([]byte(selec.AttrOr("data-attrs", "")), &attrs); err != nil || strings.TrimSpace(attrs.Expression) == "" {
+						return nil
+					}
+					text := "\n\n$$\n" + strings.TrimSpace(attrs.Expression) + "\n$$\n\n"
+					return &text
+				},
+			},
+		}
+	}
+}
+
+// CodeBlocks renders code blocks as fenced code blocks, with their language when known.
+func CodeBlocks() md.Plugin {
+	return func(c *md.Converter) []md.Rule {
+		return []md.Rule{
+			{
+				Filter: []string{"pre"},
+				Replacement: func(content string, selec *goquery.Selection, opt *md.Options) *string {
+					var language string
+					for _, class := range strings.Fields(selec.Find("code").AttrOr("class", "")) {
+						if strings.HasPrefix(class, "language-") {
+							language = strings.TrimPrefix(class, "language-")
+							break
+						}
+					}
+					code := strings.TrimSuffix(selec.Text(), "\n")
+					fence := md.CalculateCodeFence('`', code)
+					text := "\n\n" + fence + language + "\n" + code + "\n" + fence + "\n\n"
+					return &text
+				},
+			},
+		}
+	}
+}
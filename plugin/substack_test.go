@@ -0,0 +1,121 @@
+package plugin
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	md "github.com/JohannesKaufmann/html-to-markdown"
+)
+
+// convert converts html to Markdown with the given plugins.
+func convert(t *testing.T, html string, plugins ...md.Plugin) string {
+	t.Helper()
+	c := md.NewConverter("", true, nil)
+	c.Use(plugins...)
+	out, err := c.ConvertString(html)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestSubstackRules(t *testing.T) {
+	want := []string{"buttons", "captions", "code", "embeds", "footnotes", "galleries", "latex", "pullquotes"}
+	if got := SubstackRules(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, name := range want {
+		if !IsSubstackRule(name) {
+			t.Errorf("IsSubstackRule(%q) = false, want true", name)
+		}
+	}
+	if IsSubstackRule("tables") {
+		t.Errorf("IsSubstackRule(%q) = true, want false", "tables")
+	}
+	if got := len(Substack([]string{"latex", "code", "unknown"})); got != len(want)-2 {
+		t.Errorf("got %d plugins, want %d", got, len(want)-2)
+	}
+}
+
+func TestSubstackPlugins(t *testing.T) {
+	tests := []struct {
+		name   string
+		plugin md.Plugin
+		html   string
+		want   string
+	}{
+		{
+			name:   "captioned image",
+			plugin: CaptionedImages(),
+			html: `<figure><a class="image-link" href="https://example.com/a.png"><img src="https://example.com/a.png" alt="A"/>` +
+				`<div class="image-link-expand"><button>Expand</button></div></a><figcaption>The <em>caption</em></figcaption></figure>`,
+			want: "![A](https://example.com/a.png)\n\n*The _caption_*",
+		},
+		{
+			name:   "empty caption",
+			plugin: CaptionedImages(),
+			html:   `<figure><img src="a.png"/><figcaption> </figcaption></figure>`,
+			want:   "![](a.png)",
+		},
+		{
+			name:   "gallery",
+			plugin: ImageGalleries(),
+			html: `<div class="image-gallery-embed" data-attrs='{"gallery":{"caption":"Two pictures"}}'>` +
+				`<img src="a.png" alt="A"/><img src="b.png"/></div>`,
+			want: "![A](a.png)\n\n![](b.png)\n\n*Two pictures*",
+		},
+		{
+			name:   "gallery without images in the markup",
+			plugin: ImageGalleries(),
+			html:   `<div class="image-gallery-embed" data-attrs='{"gallery":{"images":[{"src":"a.png"},{"src":""}]}}'><figcaption>Caption</figcaption></div>`,
+			want:   "![](a.png)\n\n*Caption*",
+		},
+		{
+			name:   "call-to-action buttons",
+			plugin: DropButtons(),
+			html: `<p>Text</p><p class="button-wrapper"><a class="button" href="https://x.substack.com/subscribe?utm=1">Subscribe</a></p>` +
+				`<p class="button-wrapper"><a class="button" href="https://x.substack.com/p/post/comments">Comment</a></p>` +
+				`<div class="subscription-widget-wrap"><p>Subscribe to the newsletter</p></div>` +
+				`<p class="button-wrapper"><a class="button" href="https://example.com/book">Buy the book</a></p>`,
+			want: "Text\n\n[Buy the book](https://example.com/book)",
+		},
+		{
+			name:   "pull quote",
+			plugin: PullQuotes(),
+			html:   `<div class="pullquote"><p>Quoted</p></div>`,
+			want:   "> Quoted",
+		},
+		{
+			name:   "latex",
+			plugin: Latex(),
+			html:   `<div class="latex-rendered" data-attrs='{"persistentExpression":" e^{i\\pi} + 1 = 0 "}'><span>rendered</span></div>`,
+			want:   "$$\ne^{i\\pi} + 1 = 0\n$$",
+		},
+		{
+			name:   "latex without expression",
+			plugin: Latex(),
+			html:   `<div class="latex-rendered" data-attrs="{}"><span>rendered</span></div>`,
+			want:   "rendered",
+		},
+		{
+			name:   "code block",
+			plugin: CodeBlocks(),
+			html:   "<pre><code class=\"hljs language-go\">func main() {\n\tfmt.Println(\"```\")\n}\n</code></pre>",
+			want:   "````go\nfunc main() {\n\tfmt.Println(\"```\")\n}\n````",
+		},
+		{
+			name:   "code block without language",
+			plugin: CodeBlocks(),
+			html:   "<pre><code>ls -l</code></pre>",
+			want:   "```\nls -l\n```",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := strings.TrimSpace(convert(t, tt.html, tt.plugin)); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
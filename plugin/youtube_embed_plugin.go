@@ -16,7 +16,8 @@ var youtubeID = regexp.MustCompile(`(?:youtube\.com|youtube-nocookie\.com)\/embe
 
 // YoutubeEmbed registers a rule (for iframes) and
 // returns a markdown compatible representation (link to video, ...).
-func YoutubeEmbed() md.Plugin {
+func YoutubeEmbed(opts ...Option) md.Plugin {
+	o := newOptions(opts)
 	return func(c *md.Converter) []md.Rule {
 		return []md.Rule{
 			{
@@ -24,18 +25,18 @@ func YoutubeEmbed() md.Plugin {
 				Replacement: func(content string, selec *goquery.Selection, opt *md.Options) *string {
 					src := selec.AttrOr("src", "")
 					if !strings.Contains(src, "youtube.com") && !strings.Contains(src, "youtube-nocookie.com") {
-						fmt.Println("Not a YouTube iframe:", src)
+						o.logger.Debug("not a YouTube iframe", "src", src)
 						return nil
 					}
 					alt := selec.AttrOr("title", "")
 					parts := youtubeID.FindStringSubmatch(src)
 					if len(parts) != 2 {
-						fmt.Println("YouTube ID not found in src:", src)
+						o.logger.Debug("YouTube ID not found in src", "src", src)
 						return nil
 					}
 					id := parts[1]
 					text := fmt.Sprintf("[![%s](https://img.youtube.com/vi/%s/0.jpg)](https://www.youtube.com/watch?v=%s)", alt, id, id)
-					fmt.Println("YouTube video embedded:", text)
+					o.logger.Debug("YouTube video embedded", "id", id)
 					return &text
 				},
 			},